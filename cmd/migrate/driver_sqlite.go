@@ -0,0 +1,7 @@
+// +build sqlite
+
+package main
+
+// The sqlite driver requires cgo so unlike the other drivers it is compiled
+// in only with the sqlite build tag: CGO_ENABLED=1 go build -tags sqlite
+import _ "github.com/pasztorpisti/migrate/driver/sqlite"
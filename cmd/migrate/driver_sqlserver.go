@@ -0,0 +1,5 @@
+// +build !no_sqlserver
+
+package main
+
+import _ "github.com/pasztorpisti/migrate/driver/sqlserver"
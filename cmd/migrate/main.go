@@ -1,14 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/pasztorpisti/migrate/core"
 	"log"
 	"os"
+	"os/signal"
 	"runtime"
 	"sort"
 	"strings"
+	"syscall"
+	"time"
 )
 
 const usage = `Usage: migrate [migrate_options] <command> [command_options] [command_args]
@@ -27,9 +32,13 @@ Commands:
   init      Create the migrations table in the DB if not exists.
   new       Create a new migration file or squash existing ones.
   status    Print info about the current state of the migrations.
+  history   Print the log of the executed migrations.
   plan      Print the plan that would be executed by a goto command.
   goto      Migrate to a specific version of the DB schema.
+  redo      Backward migrate and then forward migrate the newest migrations.
   hack      Manipulate a single migration step. Useful for troubleshooting.
+  baseline  Mark the migrations of an existing DB as applied without executing them.
+  validate  Check the migration files for problems without connecting to the DB.
   version   Print version info.
 
 Use 'migrate <command> -help' for more info about a command.
@@ -43,17 +52,23 @@ const (
 type migrateOptions struct {
 	ConfigFile string
 	DB         string
+	// Context is cancelled on SIGINT and SIGTERM.
+	Context context.Context
 }
 
 var commands = map[string]func(opts *migrateOptions, args []string) error{
-	"config":  cmdConfig,
-	"init":    cmdInit,
-	"new":     cmdNew,
-	"goto":    cmdGoto,
-	"plan":    cmdPlan,
-	"status":  cmdStatus,
-	"hack":    cmdHack,
-	"version": cmdVersion,
+	"config":   cmdConfig,
+	"init":     cmdInit,
+	"new":      cmdNew,
+	"goto":     cmdGoto,
+	"redo":     cmdRedo,
+	"plan":     cmdPlan,
+	"status":   cmdStatus,
+	"history":  cmdHistory,
+	"hack":     cmdHack,
+	"baseline": cmdBaseline,
+	"validate": cmdValidate,
+	"version":  cmdVersion,
 }
 
 func main() {
@@ -81,9 +96,27 @@ func main() {
 		os.Exit(1)
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		// A second signal kills the process without waiting for the rollback.
+		<-ctx.Done()
+		stop()
+	}()
+	opts.Context = ctx
+
+	if version == "" {
+		version = "dev"
+	}
+	core.Version = version
+
 	err := cmdFunc(&opts, args[1:])
 	if err != nil {
 		log.Print(err)
+		var execErr *core.ExecError
+		if errors.As(err, &execErr) && execErr.Statement != "" {
+			log.Print(execErr.StatementExcerpt())
+		}
 		os.Exit(1)
 	}
 }
@@ -92,14 +125,18 @@ var stdoutPrinter = core.NewPrinter(os.Stdout)
 
 const configTemplate = `dev:
   db:
-    # DB driver: can be postgres or mysql
+    # DB driver: can be postgres, cockroachdb, mysql, sqlite or sqlserver.
+    # The sqlite driver is available only in binaries built with cgo and
+    # the sqlite build tag (the release binaries don't include it).
     driver: postgres
 
     # DB driver specific connection parameters.
     # In case of mysql it looks like this: user@tcp(localhost:3306)/db_name
     #
     # Mysql data_source format: https://github.com/go-sql-driver/mysql#dsn-data-source-name
-    # Postgres data_source format: https://godoc.org/github.com/lib/pq
+    # Postgres and cockroachdb data_source format: https://godoc.org/github.com/lib/pq
+    # Sqlite data_source format: https://github.com/mattn/go-sqlite3#connection-string
+    # Sqlserver data_source format: https://github.com/denisenkom/go-mssqldb#connection-parameters-and-dsn
     #
     # You can interpolate environment variables by using {env:ENV_VAR_NAME}
     # placeholders. Outside of the placeholders you have to escape/prefix the
@@ -111,6 +148,20 @@ const configTemplate = `dev:
     # The name of the migrations table. Optional, default value: migrations
     #migrations_table: migrations
 
+    # The name of the append-only history table that logs every forward,
+    # backward, failed, hack and baseline operation. See "migrate history".
+    # Optional, default value: <migrations_table>_history
+    #history_table: migrations_history
+
+    # postgres and cockroachdb only: the schema of the migrations and history
+    # tables. The init command creates the schema if it doesn't exist.
+    # Optional, default: the tables are unqualified (see search_path)
+    #migrations_schema: ops
+
+    # postgres and cockroachdb only: the search_path of every connection.
+    # Optional, default: the search_path of the DB user
+    #search_path: 'app, public'
+
   migration_source:
     # The relative or absolute path to the directory that contains the migration files.
     # A relative path is relative to the parent dir of this config file.
@@ -179,6 +230,22 @@ const configTemplate = `dev:
     # Optional. Default: '[id][description,prefix:_].sql'
     #filename_pattern: '[id][description,prefix:_].[direction,forward:fw,backward:bw].sql'
 
+    # With substitute_vars==true the {env:VAR} template parameters and the
+    # {var:name} parameters defined by the vars field of this config section
+    # are substituted in the migration files. The plan -sql command shows the
+    # substituted SQL but the checksums are calculated from the original text.
+    # The { and \ characters of the migrations have to be escaped as \{ and \\.
+    #
+    # Optional. Default: false
+    #substitute_vars: true
+
+    # The registry of the Go migrations (see dir.RegisterGoMigration) that are
+    # loaded together with the migration files. Only builds of the migrate tool
+    # that register Go migrations can use it.
+    #
+    # Optional. Default: no Go migrations
+    #go_migrations: main
+
   # If allow_migration_gaps==false (which is the default setting) then the plan
   # and goto commands fail with an error message if there is at least one
   # unapplied migration that is older (has smaller ID) than an applied migration.
@@ -201,11 +268,128 @@ const configTemplate = `dev:
   # Optional. Default: false
   #allow_migration_gaps: true
 
+  # The goto and hack commands hold a DB lock while they work with the
+  # migrations table to prevent concurrent migrate processes (e.g.: two CI
+  # pipelines) from applying the same migrations. This setting controls how
+  # long they wait for the lock before failing with an "another migration is
+  # in progress" error. Use 0 to fail immediately without waiting.
+  #
+  # postgres uses pg_advisory_xact_lock, mysql uses GET_LOCK (whole seconds),
+  # sqlserver uses sp_getapplock, cockroachdb locks a row of the
+  # <migrations_table>_lock table with SELECT FOR UPDATE.
+  # sqlite doesn't support locking.
+  #
+  # Optional. Default: 1m
+  #lock_timeout: 5m
+
+  # The goto and hack commands store the checksum of the forward migration
+  # SQL in the migrations table. The status, plan and goto commands report
+  # the applied migrations that have been modified since they were applied.
+  # With fail_on_checksum_mismatch=true the plan and goto commands fail
+  # instead of printing a warning.
+  #
+  # Optional. Default: false
+  #fail_on_checksum_mismatch: true
+
+  # The maximum execution time of a migration applied by the goto command.
+  # The -timeout option of goto overrides it and the timeout parameter of
+  # the +migrate directive (e.g.: -- +migrate forward timeout=30s) overrides
+  # both for a single migration. Besides cancelling the migration on the
+  # client side the postgres and mysql drivers set the statement_timeout and
+  # lock_timeout (postgres) or max_execution_time and lock_wait_timeout
+  # (mysql) server variables in the transaction of the migration. The
+  # sqlserver driver sets only LOCK_TIMEOUT.
+  #
+  # Optional. Default: 0 (no timeout)
+  #statement_timeout: 30s
+
+  # The applied_by value is recorded in the migrations table along with the
+  # forward migrated migrations. The hostname, the execution time and the
+  # version of this tool are recorded too. Use "migrate status -v" to see them.
+  # It can contain {env:VAR} and {cmd:command} template parameters.
+  #
+  # Optional. Default: the name of the OS user
+  #applied_by: '{env:CI_JOB_USER}'
+
+  # Migrations can contain conditional blocks that are executed only if
+  # their condition is true. The "db" value of the conditions is the name of
+  # this config section (e.g.: dev). The tags define additional values.
+  # E.g.:
+  #   -- +migrate if db=dev,test region=eu
+  #   INSERT INTO users (name) VALUES ('test');
+  #   -- +migrate endif
+  # The plan -sql command shows the skipped blocks commented out.
+  #
+  # Optional. Default: no tags
+  #tags:
+  #  region: eu
+
+  # The values of the {var:name} template parameters of the migrations.
+  # They are used only with migration_source.substitute_vars==true.
+  # E.g.: CREATE USER {var:replication_user};
+  #
+  # Optional. Default: no vars
+  #vars:
+  #  replication_user: replicator
+
+  # Hooks are SQL statements or shell commands executed by the goto and redo
+  # commands when there is something to migrate:
+  # - before_goto: after acquiring the migration lock before the first migration
+  # - after_goto: after the last migration if all migrations succeeded
+  # - before_each: before each migration
+  # - after_each: after each successful migration
+  # - on_failure: when a migration or a hook fails
+  # A hook has either an sql field (executed through the DB connection outside
+  # of the transactions of the migrations) or a cmd field (executed by the
+  # shell). The environment of the commands contains MIGRATE_HOOK,
+  # MIGRATE_MIGRATION, MIGRATE_DIRECTION (forward or backward) and in case of
+  # on_failure MIGRATE_ERROR. The optional timeout field limits the execution
+  # time of a hook. The failure of a hook aborts the migrations unless it has
+  # ignore_error: true. The on_failure hooks run even if the migrations were
+  # interrupted (e.g.: Ctrl-C), their default timeout is 1m and their
+  # failures are only warnings.
+  #
+  # Optional. Default: no hooks
+  #hooks:
+  #  before_goto:
+  #    - cmd: pg_dump mydb > backup.sql
+  #      timeout: 10m
+  #  after_goto:
+  #    - sql: REFRESH MATERIALIZED VIEW user_stats;
+  #  on_failure:
+  #    - cmd: ./notify.sh "migration $MIGRATE_MIGRATION failed: $MIGRATE_ERROR"
+  #      ignore_error: true
+
+  # With targets the init, status, plan and goto commands are executed on
+  # multiple schemas or databases (e.g.: one per customer). The {target}
+  # placeholder of the db.data_source and the other db params is replaced
+  # with the name of the target. E.g.: migrations_schema: '{target}' and
+  # search_path: '{target}' for schema-per-target postgres setups or
+  # data_source: 'postgres://localhost/{target}' for database-per-target.
+  # The output of each target is followed by a summary. The other commands
+  # don't support config sections with targets.
+  #
+  # Optional. Default: no targets (a single DB)
+  #targets:
+  #  # The list of targets. Exclusive with query.
+  #  list: [customer1, customer2]
+  #
+  #  # A query that returns the names of the targets in its only column.
+  #  #query: "SELECT nspname FROM pg_namespace WHERE nspname LIKE 'customer%'"
+  #
+  #  # The data source of the connection that executes the query. Required
+  #  # only if db.data_source contains {target}.
+  #  #data_source: 'postgres://localhost/postgres'
+  #
+  #  # The maximum number of targets processed concurrently. Default: 1
+  #  #parallelism: 4
+
 prod:
   db:
     driver: postgres
     data_source: 'postgres://{env:DB_USER}:{env:DB_PASSWORD}@{env:DB_HOST}:5432/postgres'
     #migrations_table: migrations
+    #history_table: migrations_history
   migration_source:
     path: migrations
     #filename_pattern: '[id][description,prefix:_].[direction,forward:fw,backward:bw].sql'
@@ -297,13 +481,14 @@ func cmdInit(opts *migrateOptions, args []string) error {
 	}
 
 	return core.CmdInit(&core.CmdInitInput{
+		Context:    opts.Context,
 		Output:     stdoutPrinter,
 		ConfigFile: opts.ConfigFile,
 		DB:         opts.DB,
 	})
 }
 
-const gotoUsage = `Usage: migrate goto [-quiet] <migration_id>
+const gotoUsage = `Usage: migrate goto [-quiet] [-format text|json] [-timeout <duration>] <migration_id>
 
 Backward migrate everything that is newer than <migration_id> and
 forward migrate <migration_id> along with everything that is older.
@@ -320,6 +505,12 @@ Args:
 
         initial      Backward migrates everything.
         latest       Forward migrates everything.
+        previous     The same as -1.
+        next         The same as +1.
+        -N, +N       Moves N migrations backward or forward relative to the
+                     newest forward migrated one. Negative values have to
+                     be preceded by "--" to avoid parsing them as options.
+                     E.g.: migrate goto -- -2
 `
 
 func cmdGoto(opts *migrateOptions, args []string) error {
@@ -330,6 +521,8 @@ func cmdGoto(opts *migrateOptions, args []string) error {
 		log.Print(gotoUsageArgs)
 	}
 	quiet := fs.Bool("quiet", false, "Don't log migration steps.")
+	format := fs.String("format", core.FormatText, "Output format: text or json. The json output is printed after executing the steps.")
+	timeout := fs.Duration("timeout", 0, "Maximum execution time of each migration (e.g.: 30s). Overrides the statement_timeout config.")
 	fs.Parse(args)
 
 	if fs.NArg() != 1 {
@@ -342,15 +535,77 @@ func cmdGoto(opts *migrateOptions, args []string) error {
 	migrationID := fs.Arg(0)
 
 	return core.CmdGoto(&core.CmdGotoInput{
+		Context:     opts.Context,
 		Output:      stdoutPrinter,
 		ConfigFile:  opts.ConfigFile,
 		DB:          opts.DB,
 		MigrationID: migrationID,
 		Quiet:       *quiet,
+		Format:      *format,
+		Timeout:     *timeout,
 	})
 }
 
-const planUsage = `Usage: migrate plan [-sql] [-sys] <migration_id>
+const redoUsage = `Usage: migrate redo [-n N] [-dry-run [-sql] [-sys]] [-quiet] [-format text|json] [-timeout <duration>]
+
+Backward migrates the newest N forward migrated migrations and then forward
+migrates them again. Useful while developing a migration.
+
+Options:
+`
+
+func cmdRedo(opts *migrateOptions, args []string) error {
+	fs := flag.NewFlagSet("redo", flag.ExitOnError)
+	fs.Usage = func() {
+		log.Print(redoUsage)
+		fs.PrintDefaults()
+	}
+	n := fs.Int("n", 1, "The number of migrations to redo.")
+	dryRun := fs.Bool("dry-run", false, "Print the plan like the plan command instead of executing it.")
+	sql := fs.Bool("sql", false, "With -dry-run: log the migration SQL statements (those that modify user tables).")
+	sys := fs.Bool("sys", false, "With -dry-run: log all SQL statements including those that modify the migrations table. Implies -sql.")
+	quiet := fs.Bool("quiet", false, "Don't log migration steps.")
+	format := fs.String("format", core.FormatText, "Output format: text or json.")
+	timeout := fs.Duration("timeout", 0, "Maximum execution time of each migration (e.g.: 30s). Overrides the statement_timeout config.")
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		log.Printf("Unwanted extra arguments: %q", fs.Args())
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *n < 1 {
+		log.Print("The -n option has to be positive.")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	if *dryRun {
+		return core.CmdPlan(&core.CmdPlanInput{
+			Context:        opts.Context,
+			Output:         stdoutPrinter,
+			ConfigFile:     opts.ConfigFile,
+			DB:             opts.DB,
+			PrintSQL:       *sql,
+			PrintSystemSQL: *sys,
+			Format:         *format,
+			Redo:           *n,
+		})
+	}
+
+	return core.CmdGoto(&core.CmdGotoInput{
+		Context:    opts.Context,
+		Output:     stdoutPrinter,
+		ConfigFile: opts.ConfigFile,
+		DB:         opts.DB,
+		Quiet:      *quiet,
+		Format:     *format,
+		Timeout:    *timeout,
+		Redo:       *n,
+	})
+}
+
+const planUsage = `Usage: migrate plan [-sql] [-sys] [-format text|json] <migration_id>
 
 Print a plan without modifying the database.
 
@@ -366,6 +621,12 @@ Args:
 
         initial      Backward migrates everything.
         latest       Forward migrates everything.
+        previous     The same as -1.
+        next         The same as +1.
+        -N, +N       Moves N migrations backward or forward relative to the
+                     newest forward migrated one. Negative values have to
+                     be preceded by "--" to avoid parsing them as options.
+                     E.g.: migrate plan -- -2
 
 `
 
@@ -378,6 +639,7 @@ func cmdPlan(opts *migrateOptions, args []string) error {
 	}
 	sql := fs.Bool("sql", false, "Log the migration SQL statements (those that modify user tables).")
 	sys := fs.Bool("sys", false, "Log all SQL statements including those that modify the migrations table. Implies -sql.")
+	format := fs.String("format", core.FormatText, "Output format: text or json.")
 	fs.Parse(args)
 
 	if fs.NArg() != 1 {
@@ -390,25 +652,34 @@ func cmdPlan(opts *migrateOptions, args []string) error {
 	migrationID := fs.Arg(0)
 
 	return core.CmdPlan(&core.CmdPlanInput{
+		Context:        opts.Context,
 		Output:         stdoutPrinter,
 		ConfigFile:     opts.ConfigFile,
 		DB:             opts.DB,
 		MigrationID:    migrationID,
 		PrintSQL:       *sql,
 		PrintSystemSQL: *sys,
+		Format:         *format,
 	})
 }
 
-const statusUsage = `Usage: migrate status
+const statusUsage = `Usage: migrate status [-v] [-format text|json]
 
 Print the status of the migrations.
+With -v it also prints when, by whom, on which host, with which migrate
+version and how quickly the applied migrations were applied.
+
+Options:
 `
 
 func cmdStatus(opts *migrateOptions, args []string) error {
 	fs := flag.NewFlagSet("status", flag.ExitOnError)
 	fs.Usage = func() {
 		log.Print(statusUsage)
+		fs.PrintDefaults()
 	}
+	format := fs.String("format", core.FormatText, "Output format: text or json.")
+	verbose := fs.Bool("v", false, "Print the details of the applied migrations.")
 	fs.Parse(args)
 
 	if fs.NArg() != 0 {
@@ -418,12 +689,85 @@ func cmdStatus(opts *migrateOptions, args []string) error {
 	}
 
 	return core.CmdStatus(&core.CmdStatusInput{
+		Context:    opts.Context,
 		Output:     stdoutPrinter,
 		ConfigFile: opts.ConfigFile,
 		DB:         opts.DB,
+		Format:     *format,
+		Verbose:    *verbose,
 	})
 }
 
+const historyUsage = `Usage: migrate history [-migration <migration_id>] [-since <time>] [-until <time>] [-failed] [-n <limit>] [-format text|json]
+
+Print the log of the executed migrations from the history table in
+chronological order. Unlike the migrations table the history table keeps
+the backward migrated and the failed migrations too. The goto, redo, hack
+and baseline commands append to it.
+
+The <time> can be an RFC3339 timestamp (2006-01-02T15:04:05Z) or a date
+(2006-01-02) in UTC.
+
+Options:
+`
+
+func cmdHistory(opts *migrateOptions, args []string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	fs.Usage = func() {
+		log.Print(historyUsage)
+		fs.PrintDefaults()
+	}
+	format := fs.String("format", core.FormatText, "Output format: text or json.")
+	migration := fs.String("migration", "", "Print only the entries of this migration.")
+	since := fs.String("since", "", "Print only the entries recorded at or after this time.")
+	until := fs.String("until", "", "Print only the entries recorded before this time.")
+	failed := fs.Bool("failed", false, "Print only the failed migrations.")
+	limit := fs.Int("n", 0, "Print only the newest n entries. 0 means no limit.")
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		log.Printf("Unwanted extra arguments: %q", fs.Args())
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	filter := core.HistoryFilter{
+		Migration:  *migration,
+		OnlyFailed: *failed,
+		Limit:      *limit,
+	}
+	var err error
+	if filter.Since, err = parseHistoryTime(*since); err != nil {
+		log.Printf("Invalid -since value: %s", err)
+		fs.Usage()
+		os.Exit(1)
+	}
+	if filter.Until, err = parseHistoryTime(*until); err != nil {
+		log.Printf("Invalid -until value: %s", err)
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	return core.CmdHistory(&core.CmdHistoryInput{
+		Context:    opts.Context,
+		Output:     stdoutPrinter,
+		ConfigFile: opts.ConfigFile,
+		DB:         opts.DB,
+		Filter:     filter,
+		Format:     *format,
+	})
+}
+
+func parseHistoryTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
 const hackUsage = `Usage: migrate hack [-force] [-useronly|-sysonly] <forward|backward> <migration_id>
 
 Forward- or backward-migrate a single step specified by <migration_id>.
@@ -494,6 +838,7 @@ func cmdHack(opts *migrateOptions, args []string) error {
 	}
 
 	return core.CmdHack(&core.CmdHackInput{
+		Context:     opts.Context,
 		Output:      stdoutPrinter,
 		ConfigFile:  opts.ConfigFile,
 		DB:          opts.DB,
@@ -505,6 +850,79 @@ func cmdHack(opts *migrateOptions, args []string) error {
 	})
 }
 
+const baselineUsage = `Usage: migrate baseline <migration_id>
+
+Records every migration up to and including <migration_id> as forward
+migrated in the migrations table without executing them. Use it to bring an
+existing database under migrate. It creates the migrations table if it
+doesn't exist. The changes are made in a single transaction and the
+recorded migrations are printed. Migrations that are already recorded
+are left untouched.
+
+Args:
+  <migration_id>
+        This is either the name of a forward migration file or its
+        numeric id (with or without zero prefix).
+        It can also be the special value "latest".
+`
+
+func cmdBaseline(opts *migrateOptions, args []string) error {
+	fs := flag.NewFlagSet("baseline", flag.ExitOnError)
+	fs.Usage = func() {
+		log.Print(baselineUsage)
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		if fs.NArg() > 1 {
+			log.Printf("Unwanted extra arguments: %q", fs.Args()[1:])
+		}
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	return core.CmdBaseline(&core.CmdBaselineInput{
+		Context:     opts.Context,
+		Output:      stdoutPrinter,
+		ConfigFile:  opts.ConfigFile,
+		DB:          opts.DB,
+		MigrationID: fs.Arg(0),
+	})
+}
+
+const validateUsage = `Usage: migrate validate
+
+Loads all migrations and reports every problem found in them:
+unparsable filenames, invalid +migrate directives, duplicate IDs,
+forward and backward steps with different descriptions, empty forward
+steps, missing backward steps (warning) and gaps between the IDs when
+the filename_pattern uses generate:sequence.
+
+It doesn't connect to the DB so the db.data_source doesn't have to be
+valid (or even present) in the config. Exits with a non-zero status if
+it finds at least one error.
+`
+
+func cmdValidate(opts *migrateOptions, args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	fs.Usage = func() {
+		log.Print(validateUsage)
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		log.Printf("Unwanted extra arguments: %q", fs.Args())
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	return core.CmdValidate(&core.CmdValidateInput{
+		Output:     stdoutPrinter,
+		ConfigFile: opts.ConfigFile,
+		DB:         opts.DB,
+	})
+}
+
 const versionUsage = `Usage: migrate version
 
 Shows the version and build information.
@@ -527,9 +945,6 @@ func cmdVersion(opts *migrateOptions, args []string) error {
 		os.Exit(1)
 	}
 
-	if version == "" {
-		version = "dev"
-	}
 	fmt.Printf("version     : %s\n", version)
 	if buildDate != "" {
 		fmt.Printf("build date  : %s\n", buildDate)
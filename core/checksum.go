@@ -0,0 +1,78 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Checksummer is implemented by steps that can calculate the checksum of
+// their contents. The checksum of a forward step is stored in the
+// migrations table and is used to detect migrations that have been
+// modified after being applied.
+type Checksummer interface {
+	Checksum() string
+}
+
+// StepChecksum returns the checksum of step if it implements Checksummer,
+// otherwise an empty string.
+func StepChecksum(step Step) string {
+	if c, ok := step.(Checksummer); ok {
+		return c.Checksum()
+	}
+	return ""
+}
+
+// checksumSQL returns the hex encoded sha256 of a SQL string.
+// The leading and trailing whitespaces are ignored because squashing
+// migration files doesn't preserve them.
+func checksumSQL(query string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(query)))
+	return hex.EncodeToString(sum[:])
+}
+
+// checksumMismatches returns the names of the forward migrated items that
+// have been modified since they were applied. Items without a recorded
+// checksum and items that don't exist in migrations are ignored.
+func checksumMismatches(migrations MigrationEntries, forwardMigrations []*MigrationNameAndTime) ([]string, error) {
+	var res []string
+	for _, m := range forwardMigrations {
+		if m.Checksum == "" {
+			continue
+		}
+		index, ok := migrations.IndexForName(m.Name)
+		if !ok || migrations.Name(index) != m.Name {
+			continue
+		}
+		forward, _, err := migrations.Steps(index)
+		if err != nil {
+			return nil, fmt.Errorf("error loading forward step for migration %q: %s", m.Name, err)
+		}
+		checksum := StepChecksum(forward)
+		if checksum != "" && checksum != m.Checksum {
+			res = append(res, m.Name)
+		}
+	}
+	return res, nil
+}
+
+// checkChecksums returns a warning for each migration that has been modified
+// since it was applied. It returns an error instead of warnings if fail==true.
+func checkChecksums(migrations MigrationEntries, forwardMigrations []*MigrationNameAndTime, fail bool) (warnings []string, err error) {
+	names, err := checksumMismatches(migrations, forwardMigrations)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	if fail {
+		return nil, fmt.Errorf("the following migrations have been modified since they were applied "+
+			"and you have fail_on_checksum_mismatch=true in your config: %s", strings.Join(names, ", "))
+	}
+	for _, name := range names {
+		warnings = append(warnings, name+" has been modified since it was applied (checksum mismatch)")
+	}
+	return warnings, nil
+}
@@ -0,0 +1,65 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// fakeMigrationEntries contains forward steps only.
+type fakeMigrationEntries []*SQLExecStep
+
+func (o fakeMigrationEntries) NumMigrations() int {
+	return len(o)
+}
+
+func (o fakeMigrationEntries) Name(index int) string {
+	return string(rune('a' + index))
+}
+
+func (o fakeMigrationEntries) Steps(index int) (forward, backward Step, err error) {
+	return o[index], nil, nil
+}
+
+func (o fakeMigrationEntries) IndexForName(name string) (index int, ok bool) {
+	for i := range o {
+		if o.Name(i) == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (o fakeMigrationEntries) New(args []string) (name string, err error) {
+	panic("not implemented")
+}
+
+func TestStepChecksum(t *testing.T) {
+	t.Run("SQLExecStep", func(t *testing.T) {
+		step := &SQLExecStep{Query: "\nCREATE TABLE t (id INT);\n\n"}
+		assert.Equal(t, checksumSQL("CREATE TABLE t (id INT);"), StepChecksum(step))
+		assert.Len(t, StepChecksum(step), 64)
+	})
+	t.Run("Step without checksum", func(t *testing.T) {
+		assert.Equal(t, "", StepChecksum(Steps{}))
+	})
+}
+
+func TestChecksumMismatches(t *testing.T) {
+	migrations := fakeMigrationEntries{
+		{Query: "query a"},
+		{Query: "query b"},
+		{Query: "query c"},
+	}
+	forwardMigrations := []*MigrationNameAndTime{
+		{Name: "a", Checksum: checksumSQL("query a")},
+		{Name: "b", Checksum: checksumSQL("modified query b")},
+		// applied by an older version without checksum
+		{Name: "c"},
+		// missing migration file
+		{Name: "d", Checksum: checksumSQL("query d")},
+	}
+
+	names, err := checksumMismatches(migrations, forwardMigrations)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b"}, names)
+}
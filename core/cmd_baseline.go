@@ -0,0 +1,162 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type CmdBaselineInput struct {
+	// Context is optional. See contextOrBackground.
+	Context     context.Context
+	Output      Printer
+	ConfigFile  string
+	DB          string
+	MigrationID string
+}
+
+// CmdBaseline records every migration up to and including MigrationID as
+// forward migrated without executing them. It creates the migrations table
+// if it doesn't exist. All changes are made in a single transaction.
+func CmdBaseline(input *CmdBaselineInput) (retErr error) {
+	if input.MigrationID == Initial {
+		return fmt.Errorf("baseline doesn't accept %q as the migration ID", Initial)
+	}
+	ctx := contextOrBackground(input.Context)
+
+	cfg, err := loadAndValidateSingleDBConfig(input.ConfigFile, input.DB, "baseline")
+	if err != nil {
+		return err
+	}
+
+	driverFactory, ok := GetDriverFactory(cfg.Driver)
+	if !ok {
+		return fmt.Errorf("invalid DB driver: %s", cfg.Driver)
+	}
+
+	driver, err := driverFactory.NewDriver(cfg.DriverParams)
+	if err != nil {
+		return fmt.Errorf("error creating %q DB driver: %s", cfg.Driver, err)
+	}
+
+	db, err := driver.Open(cfg.DataSource)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	mdb, err := driver.NewMigrationDB()
+	if err != nil {
+		return err
+	}
+
+	migrations, err := loadMigrations(cfg, input.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	targetIdx := migrations.NumMigrations() - 1
+	if input.MigrationID != Latest {
+		targetIdx, ok = migrations.IndexForName(input.MigrationID)
+		if !ok {
+			return fmt.Errorf("invalid target migration: %v", input.MigrationID)
+		}
+	}
+
+	unlock, err := acquireLock(ctx, db, mdb, cfg.LockTimeout)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := unlock(); err != nil && retErr == nil {
+			retErr = fmt.Errorf("error releasing the migration lock: %s", err)
+		}
+	}()
+
+	tx, err := db.BeginTX(ctx)
+	if err != nil {
+		return err
+	}
+	recorded, err := recordBaseline(ExecCtx{
+		Context: ctx,
+		DB:      tx,
+		Output:  nullPrinter{},
+	}, mdb, migrations, targetIdx, newApplyInfo(cfg.AppliedBy))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if len(recorded) == 0 {
+		input.Output.Println("Nothing to record.")
+		return nil
+	}
+	for _, name := range recorded {
+		input.Output.Println("Recorded as forward migrated:", name)
+	}
+	return nil
+}
+
+// recordBaseline creates the migrations table if necessary and records the
+// migrations up to and including targetIdx as forward migrated.
+// It returns the names of the newly recorded migrations.
+func recordBaseline(ctx ExecCtx, mdb MigrationDB, migrations MigrationEntries, targetIdx int, info ApplyInfo) ([]string, error) {
+	createTable, err := mdb.CreateTable()
+	if err != nil {
+		return nil, err
+	}
+	err = createTable.Execute(ctx)
+	if err != nil && err != ErrMigrationsTableAlreadyExists {
+		return nil, fmt.Errorf("error creating the migrations table: %s", err)
+	}
+
+	forwardMigrations, err := mdb.GetForwardMigrations(ctx.Context, ctx.DB)
+	if err != nil {
+		return nil, err
+	}
+	forwardMap := make(map[string]struct{}, len(forwardMigrations))
+	for _, m := range forwardMigrations {
+		forwardMap[m.Name] = struct{}{}
+	}
+
+	var recorded []string
+	for i := 0; i <= targetIdx; i++ {
+		name := migrations.Name(i)
+		if _, ok := forwardMap[name]; ok {
+			continue
+		}
+		forward, _, err := migrations.Steps(i)
+		if err != nil {
+			return nil, fmt.Errorf("error loading forward step for migration %q: %s", name, err)
+		}
+		checksum := StepChecksum(forward)
+		step, err := mdb.ForwardMigrate(&MigrationRecord{
+			Name:      name,
+			Checksum:  checksum,
+			ApplyInfo: info,
+		})
+		if err != nil {
+			return nil, err
+		}
+		historyStep, err := mdb.RecordHistory(&HistoryEntry{
+			Time:      time.Now().UTC(),
+			Migration: name,
+			Direction: direction(true),
+			Command:   HistoryBaseline,
+			Result:    HistoryOK,
+			Checksum:  checksum,
+			ApplyInfo: info,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := (Steps{step, historyStep}).Execute(ctx); err != nil {
+			return nil, fmt.Errorf("error recording %q: %s", name, err)
+		}
+		recorded = append(recorded, name)
+	}
+	return recorded, nil
+}
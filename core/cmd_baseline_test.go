@@ -0,0 +1,80 @@
+package core
+
+import (
+	"context"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestRecordBaseline(t *testing.T) {
+	migrations := fakeMigrationEntries{
+		{Query: "query a"},
+		{Query: "query b"},
+		{Query: "query c"},
+	}
+	info := ApplyInfo{AppliedBy: "ci", Hostname: "host", Version: "v1"}
+
+	t.Run("records missing migrations up to target", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		db := NewMockDB(ctrl)
+		mdb := NewMockMigrationDB(ctrl)
+		createTable := NewMockStep(ctrl)
+		recordB := NewMockStep(ctrl)
+		historyB := NewMockStep(ctrl)
+		ctx := ExecCtx{
+			Context: context.Background(),
+			DB:      db,
+			Output:  nullPrinter{},
+		}
+
+		gomock.InOrder(
+			mdb.EXPECT().CreateTable().Return(createTable, nil),
+			createTable.EXPECT().Execute(ctx).Return(ErrMigrationsTableAlreadyExists),
+			mdb.EXPECT().GetForwardMigrations(context.Background(), db).Return([]*MigrationNameAndTime{{Name: "a"}}, nil),
+			mdb.EXPECT().ForwardMigrate(&MigrationRecord{Name: "b", Checksum: checksumSQL("query b"), ApplyInfo: info}).Return(recordB, nil),
+			mdb.EXPECT().RecordHistory(gomock.Any()).Do(func(e *HistoryEntry) {
+				assert.Equal(t, "b", e.Migration)
+				assert.Equal(t, "forward", e.Direction)
+				assert.Equal(t, HistoryBaseline, e.Command)
+				assert.Equal(t, HistoryOK, e.Result)
+				assert.Equal(t, checksumSQL("query b"), e.Checksum)
+				assert.Equal(t, info, e.ApplyInfo)
+			}).Return(historyB, nil),
+			recordB.EXPECT().Execute(ctx),
+			historyB.EXPECT().Execute(ctx),
+		)
+
+		recorded, err := recordBaseline(ctx, mdb, migrations, 1, info)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"b"}, recorded)
+	})
+
+	t.Run("record error", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		db := NewMockDB(ctrl)
+		mdb := NewMockMigrationDB(ctrl)
+		createTable := NewMockStep(ctrl)
+		recordA := NewMockStep(ctrl)
+		ctx := ExecCtx{
+			Context: context.Background(),
+			DB:      db,
+			Output:  nullPrinter{},
+		}
+
+		gomock.InOrder(
+			mdb.EXPECT().CreateTable().Return(createTable, nil),
+			createTable.EXPECT().Execute(ctx),
+			mdb.EXPECT().GetForwardMigrations(context.Background(), db).Return(nil, nil),
+			mdb.EXPECT().ForwardMigrate(&MigrationRecord{Name: "a", Checksum: checksumSQL("query a"), ApplyInfo: info}).Return(recordA, nil),
+			mdb.EXPECT().RecordHistory(gomock.Any()).Return(Steps{}, nil),
+			recordA.EXPECT().Execute(ctx).Return(assert.AnError),
+		)
+
+		_, err := recordBaseline(ctx, mdb, migrations, 2, info)
+		assert.Error(t, err)
+	})
+}
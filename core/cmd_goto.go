@@ -1,141 +1,140 @@
 package core
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"path/filepath"
+	"time"
 )
 
 type CmdGotoInput struct {
+	// Context is optional. See contextOrBackground.
+	Context     context.Context
 	Output      Printer
 	ConfigFile  string
 	DB          string
 	MigrationID string
 	Quiet       bool
+	// Format is FormatText (default) or FormatJSON.
+	Format string
+	// Timeout overrides the statement_timeout config if it isn't zero.
+	Timeout time.Duration
+	// Redo is the number of migrations to redo. See PlanRedo.
+	// MigrationID is ignored if Redo isn't zero.
+	Redo int
 }
 
 func CmdGoto(input *CmdGotoInput) error {
-	steps, db, err := preparePlanForCmd(&preparePlanInput{
-		Output:      input.Output,
-		ConfigFile:  input.ConfigFile,
-		DB:          input.DB,
-		MigrationID: input.MigrationID,
-	})
-	if err != nil {
+	if err := validateFormat(input.Format); err != nil {
 		return err
 	}
-	defer db.Close()
-
-	execCtx := ExecCtx{
-		DB:     db,
-		Output: input.Output,
-	}
-	if input.Quiet {
-		execCtx.Output = nullPrinter{}
-	}
-	return steps.Execute(execCtx)
-}
-
-type preparePlanInput struct {
-	Output      Printer
-	ConfigFile  string
-	DB          string
-	MigrationID string
-}
 
-func preparePlanForCmd(input *preparePlanInput) (_ Steps, _ ClosableDB, retErr error) {
+	ctx := contextOrBackground(input.Context)
 	cfg, err := loadAndValidateDBConfig(input.ConfigFile, input.DB)
 	if err != nil {
-		return nil, nil, err
-	}
-
-	driverFactory, ok := GetDriverFactory(cfg.Driver)
-	if !ok {
-		return nil, nil, fmt.Errorf("invalid DB driver: %s", cfg.Driver)
+		return err
 	}
-
-	driver, err := driverFactory.NewDriver(cfg.DriverParams)
+	migrations, err := loadMigrations(cfg, input.ConfigFile)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error creating %q DB driver: %s", cfg.Driver, err)
+		return err
 	}
 
-	db, err := driver.Open(cfg.DataSource)
-	if err != nil {
-		return nil, nil, err
+	if cfg.Targets != nil {
+		return runOnTargets(ctx, cfg, input.Output, input.Format, func(ctx context.Context, cfg *dbConfig, output Printer) (interface{}, error) {
+			report, err := gotoTarget(ctx, input, cfg, migrations, output)
+			if report == nil {
+				return nil, err
+			}
+			return report, err
+		})
 	}
-	defer func() {
-		if retErr != nil {
-			db.Close()
-		}
-	}()
 
-	mdb, err := driver.NewMigrationDB()
-	if err != nil {
-		return nil, nil, err
-	}
-	forwardMigrations, err := mdb.GetForwardMigrations(db)
-	if err != nil {
-		return nil, nil, err
-	}
-	forwardNames := make([]string, len(forwardMigrations))
-	for i, m := range forwardMigrations {
-		forwardNames[i] = m.Name
+	report, err := gotoTarget(ctx, input, cfg, migrations, input.Output)
+	if report != nil && input.Format == FormatJSON {
+		if err2 := printJSON(input.Output, report); err == nil {
+			err = err2
+		}
 	}
+	return err
+}
 
-	sourceFactory, ok := GetMigrationSourceFactory(cfg.MigrationSourceType)
-	if !ok {
-		return nil, nil, fmt.Errorf("unknown migration_source type in config: %s", cfg.MigrationSourceType)
+// gotoTarget executes the goto command on a single DB. The text output
+// goes to output. The returned report is nil if the plan couldn't be
+// created.
+func gotoTarget(ctx context.Context, input *CmdGotoInput, cfg *dbConfig, migrations MigrationEntries, output Printer) (*PlanReport, error) {
+	options := &MigratorOptions{
+		StatementTimeout: input.Timeout,
+		Output:           output,
+		Quiet:            input.Quiet,
 	}
-	source, err := sourceFactory.NewMigrationSource(filepath.Dir(input.ConfigFile), cfg.MigrationSourceParams)
-	if err != nil {
-		return nil, nil, fmt.Errorf("error creating migration source: %s", err)
+	if input.Format == FormatJSON {
+		options.Output = nil
 	}
-	migrations, err := source.MigrationEntries()
+	m, db, err := openMigrator(cfg, migrations, options)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error loading migrations: %s", err)
+		return nil, err
 	}
+	defer db.Close()
 
-	forwardMigrated := make([]bool, migrations.NumMigrations())
-	for _, name := range forwardNames {
-		index, ok := migrations.IndexForName(name)
-		// We don't accept aliases as forward migrated names.
-		// This is why we check for (name != input.Migrations.Name(index)).
-		if !ok || name != migrations.Name(index) {
-			return nil, nil, fmt.Errorf("can't find migration file for forward migrated item %q", name)
-		}
-		forwardMigrated[index] = true
+	if input.Redo != 0 {
+		return m.Redo(ctx, input.Redo)
 	}
+	return m.Goto(ctx, input.MigrationID)
+}
 
-	if !cfg.AllowMigrationGaps {
-		allowForwardMigrated := true
-		for _, fm := range forwardMigrated {
-			if fm {
-				if !allowForwardMigrated {
-					return nil, nil, errMigrationGap
-				}
-			} else {
-				allowForwardMigrated = false
-			}
-		}
+// executeWithTimeout executes step with the timeout of the step if
+// it is a *MigrationStep with a non-zero Timeout.
+func executeWithTimeout(ctx ExecCtx, step Step) error {
+	ms, ok := step.(*MigrationStep)
+	if !ok || ms.Timeout == 0 {
+		return step.Execute(ctx)
 	}
 
-	steps, err := Plan(&PlanInput{
-		Migrations:      migrations,
-		ForwardMigrated: forwardMigrated,
-		Target:          input.MigrationID,
-		MigrationDB:     mdb,
-	})
-	if err != nil {
-		return nil, nil, err
-	}
+	var cancel context.CancelFunc
+	ctx.Context, cancel = context.WithTimeout(ctx.Context, ms.Timeout)
+	defer cancel()
 
-	if len(steps) == 0 {
-		input.Output.Println("Nothing to migrate.")
+	err := step.Execute(ctx)
+	if err != nil && ctx.Context.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %v: %w", ms.Timeout, err)
 	}
+	return err
+}
 
-	return steps, db, nil
+// recordFailureTimeout limits the time spent with recording a failed
+// migration in the history table.
+const recordFailureTimeout = 10 * time.Second
+
+// recordFailure appends a copy of entry with HistoryFailed result to the
+// history table. It is executed after the transaction of the failed
+// migration has been rolled back.
+func recordFailure(db DB, mdb MigrationDB, entry *HistoryEntry, failure error) error {
+	e := *entry
+	e.Time = time.Now().UTC()
+	e.Result = HistoryFailed
+	e.Error = failure.Error()
+	step, err := mdb.RecordHistory(&e)
+	if err == nil {
+		// The context of the command can be cancelled at this point
+		// so we use a new one.
+		ctx, cancel := context.WithTimeout(context.Background(), recordFailureTimeout)
+		defer cancel()
+		err = step.Execute(ExecCtx{
+			Context: ctx,
+			DB:      db,
+			Output:  nullPrinter{},
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("error recording the failure of %s in the history table: %s", e.Migration, err)
+	}
+	return nil
 }
 
+// ErrInterrupted is the prefix of the error returned by the goto and hack
+// commands when they are stopped by cancelling their context.
+var ErrInterrupted = errors.New("interrupted")
+
 // errMigrationGap is an ugly error message.
 var errMigrationGap = errors.New(`There are gaps between the migrations that have already been applied so the plan
 and goto commands don't work because you don't have allow_migration_gaps=true
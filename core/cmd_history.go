@@ -0,0 +1,101 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type CmdHistoryInput struct {
+	// Context is optional. See contextOrBackground.
+	Context    context.Context
+	Output     Printer
+	ConfigFile string
+	DB         string
+	// Filter.Migration can be a migration ID too.
+	Filter HistoryFilter
+	// Format is FormatText (default) or FormatJSON.
+	Format string
+}
+
+func CmdHistory(input *CmdHistoryInput) error {
+	if err := validateFormat(input.Format); err != nil {
+		return err
+	}
+
+	cfg, err := loadAndValidateSingleDBConfig(input.ConfigFile, input.DB, "history")
+	if err != nil {
+		return err
+	}
+
+	driverFactory, ok := GetDriverFactory(cfg.Driver)
+	if !ok {
+		return fmt.Errorf("invalid DB driver: %s", cfg.Driver)
+	}
+
+	driver, err := driverFactory.NewDriver(cfg.DriverParams)
+	if err != nil {
+		return fmt.Errorf("error creating %q DB driver: %s", cfg.Driver, err)
+	}
+
+	db, err := driver.Open(cfg.DataSource)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	mdb, err := driver.NewMigrationDB()
+	if err != nil {
+		return err
+	}
+
+	filter := input.Filter
+	if filter.Migration != "" {
+		// The history can contain migrations that don't exist anymore
+		// so we resolve the migration ID only if it is possible.
+		if migrations, err := loadMigrations(cfg, input.ConfigFile); err == nil {
+			if i, ok := migrations.IndexForName(filter.Migration); ok {
+				filter.Migration = migrations.Name(i)
+			}
+		}
+	}
+
+	entries, err := mdb.GetHistory(contextOrBackground(input.Context), db, &filter)
+	if err != nil {
+		return fmt.Errorf("error reading the history table: %s", err)
+	}
+
+	if input.Format == FormatJSON {
+		report := &HistoryReport{
+			Entries: make([]*HistoryItem, 0, len(entries)),
+		}
+		for _, e := range entries {
+			report.Entries = append(report.Entries, newHistoryItem(e))
+		}
+		return printJSON(input.Output, report)
+	}
+
+	if len(entries) == 0 {
+		input.Output.Println("The history is empty.")
+		return nil
+	}
+	for _, e := range entries {
+		input.Output.Printf("%s %-8s %-8s %-6s %s (%s",
+			e.Time.UTC().Format("2006-01-02 15:04:05"), e.Command, e.Direction, e.Result, e.Migration,
+			e.Duration.Truncate(time.Millisecond))
+		if e.AppliedBy != "" {
+			input.Output.Printf(", by %s", e.AppliedBy)
+		}
+		if e.Hostname != "" {
+			input.Output.Printf(", on %s", e.Hostname)
+		}
+		if e.Version != "" {
+			input.Output.Printf(", migrate %s", e.Version)
+		}
+		input.Output.Println(")")
+		if e.Error != "" {
+			input.Output.Printf("    %s\n", e.Error)
+		}
+	}
+	return nil
+}
@@ -1,19 +1,34 @@
 package core
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+)
 
 type CmdInitInput struct {
+	// Context is optional. See contextOrBackground.
+	Context    context.Context
 	Output     Printer
 	ConfigFile string
 	DB         string
 }
 
 func CmdInit(input *CmdInitInput) error {
+	ctx := contextOrBackground(input.Context)
 	cfg, err := loadAndValidateDBConfig(input.ConfigFile, input.DB)
 	if err != nil {
 		return err
 	}
 
+	if cfg.Targets != nil {
+		return runOnTargets(ctx, cfg, input.Output, FormatText, func(ctx context.Context, cfg *dbConfig, output Printer) (interface{}, error) {
+			return nil, initTarget(ctx, cfg, output)
+		})
+	}
+	return initTarget(ctx, cfg, input.Output)
+}
+
+func initTarget(ctx context.Context, cfg *dbConfig, output Printer) error {
 	driverFactory, ok := GetDriverFactory(cfg.Driver)
 	if !ok {
 		return fmt.Errorf("invalid DB driver: %s", cfg.Driver)
@@ -40,15 +55,16 @@ func CmdInit(input *CmdInitInput) error {
 	}
 
 	err = step.Execute(ExecCtx{
-		DB:     db,
-		Output: nullPrinter{},
+		Context: ctx,
+		DB:      db,
+		Output:  nullPrinter{},
 	})
 
 	switch err {
 	case nil:
-		input.Output.Println("Init success.")
+		output.Println("Init success.")
 	case ErrMigrationsTableAlreadyExists:
-		input.Output.Println("Already initialised.")
+		output.Println("Already initialised.")
 		err = nil
 	}
 
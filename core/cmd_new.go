@@ -1,10 +1,5 @@
 package core
 
-import (
-	"fmt"
-	"path/filepath"
-)
-
 type CmdNewInput struct {
 	ConfigFile string
 	DB         string
@@ -17,17 +12,9 @@ func CmdNew(input *CmdNewInput) error {
 		return err
 	}
 
-	sourceFactory, ok := GetMigrationSourceFactory(cfg.MigrationSourceType)
-	if !ok {
-		return fmt.Errorf("unknown migration_source type in config: %s", cfg.MigrationSourceType)
-	}
-	source, err := sourceFactory.NewMigrationSource(filepath.Dir(input.ConfigFile), cfg.MigrationSourceParams)
+	migrations, err := loadMigrations(cfg, input.ConfigFile)
 	if err != nil {
-		return fmt.Errorf("error creating migration source: %s", err)
-	}
-	migrations, err := source.MigrationEntries()
-	if err != nil {
-		return fmt.Errorf("error loading migrations: %s", err)
+		return err
 	}
 
 	_, err = migrations.New(input.Args)
@@ -1,30 +1,108 @@
 package core
 
+import (
+	"bytes"
+	"context"
+)
+
 type CmdPlanInput struct {
+	// Context is optional. See contextOrBackground.
+	Context        context.Context
 	Output         Printer
 	ConfigFile     string
 	DB             string
 	MigrationID    string
 	PrintSQL       bool
 	PrintSystemSQL bool
+	// Format is FormatText (default) or FormatJSON.
+	Format string
+	// Redo is the number of migrations to redo. See PlanRedo.
+	// MigrationID is ignored if Redo isn't zero.
+	Redo int
 }
 
 func CmdPlan(input *CmdPlanInput) error {
-	steps, db, err := preparePlanForCmd(&preparePlanInput{
-		Output:      input.Output,
-		ConfigFile:  input.ConfigFile,
-		DB:          input.DB,
-		MigrationID: input.MigrationID,
-	})
+	if err := validateFormat(input.Format); err != nil {
+		return err
+	}
+
+	ctx := contextOrBackground(input.Context)
+	cfg, err := loadAndValidateDBConfig(input.ConfigFile, input.DB)
+	if err != nil {
+		return err
+	}
+	migrations, err := loadMigrations(cfg, input.ConfigFile)
 	if err != nil {
 		return err
 	}
-	db.Close()
 
-	steps.Print(PrintCtx{
-		Output:         input.Output,
+	if cfg.Targets != nil {
+		return runOnTargets(ctx, cfg, input.Output, input.Format, func(ctx context.Context, cfg *dbConfig, output Printer) (interface{}, error) {
+			report, err := planTarget(ctx, input, cfg, migrations, output)
+			if report == nil {
+				return nil, err
+			}
+			return report, err
+		})
+	}
+
+	report, err := planTarget(ctx, input, cfg, migrations, input.Output)
+	if err != nil {
+		return err
+	}
+	if report != nil {
+		return printJSON(input.Output, report)
+	}
+	return nil
+}
+
+// planTarget executes the plan command on a single DB. It prints the plan
+// to output in text format and returns nil report. In JSON format it
+// returns the report without printing it.
+func planTarget(ctx context.Context, input *CmdPlanInput, cfg *dbConfig, migrations MigrationEntries, output Printer) (*PlanReport, error) {
+	m, db, err := openMigrator(cfg, migrations, &MigratorOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var p *MigrationPlan
+	if input.Redo != 0 {
+		p, err = m.PlanRedo(ctx, input.Redo)
+	} else {
+		p, err = m.Plan(ctx, input.MigrationID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	printCtx := PrintCtx{
+		Output:         output,
 		PrintSQL:       input.PrintSQL || input.PrintSystemSQL,
 		PrintSystemSQL: input.PrintSystemSQL,
-	})
-	return nil
+	}
+
+	if input.Format != FormatJSON {
+		p.printNotes(output)
+		p.Steps.Print(printCtx)
+		return nil, nil
+	}
+
+	report := newPlanReport(p)
+	for _, step := range p.Steps {
+		r := newStepReport(step, StepPlanned, nil)
+		if printCtx.PrintSQL {
+			var buf bytes.Buffer
+			printCtx.Output = NewPrinter(&buf)
+			if ms, ok := step.(*MigrationStep); ok {
+				// skipping the title
+				ms.Step.Print(printCtx)
+			} else {
+				step.Print(printCtx)
+			}
+			r.SQL = buf.String()
+		}
+		report.Steps = append(report.Steps, r)
+	}
+	return report, nil
 }
@@ -1,70 +1,75 @@
 package core
 
 import (
-	"fmt"
-	"path/filepath"
+	"context"
+	"strings"
+	"time"
 )
 
 type CmdStatusInput struct {
+	// Context is optional. See contextOrBackground.
+	Context    context.Context
 	Output     Printer
 	ConfigFile string
 	DB         string
+	// Format is FormatText (default) or FormatJSON.
+	Format string
+	// Verbose prints the details recorded about the applied migrations
+	// in text format. The JSON format always contains them.
+	Verbose bool
 }
 
 func CmdStatus(input *CmdStatusInput) error {
-	cfg, err := loadAndValidateDBConfig(input.ConfigFile, input.DB)
-	if err != nil {
+	if err := validateFormat(input.Format); err != nil {
 		return err
 	}
 
-	driverFactory, ok := GetDriverFactory(cfg.Driver)
-	if !ok {
-		return fmt.Errorf("invalid DB driver: %s", cfg.Driver)
-	}
-
-	driver, err := driverFactory.NewDriver(cfg.DriverParams)
-	if err != nil {
-		return fmt.Errorf("error creating %q DB driver: %s", cfg.Driver, err)
-	}
-
-	db, err := driver.Open(cfg.DataSource)
+	ctx := contextOrBackground(input.Context)
+	cfg, err := loadAndValidateDBConfig(input.ConfigFile, input.DB)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
-
-	mdb, err := driver.NewMigrationDB()
+	migrations, err := loadMigrations(cfg, input.ConfigFile)
 	if err != nil {
 		return err
 	}
 
-	sourceFactory, ok := GetMigrationSourceFactory(cfg.MigrationSourceType)
-	if !ok {
-		return fmt.Errorf("unknown migration_source type in config: %s", cfg.MigrationSourceType)
+	if cfg.Targets != nil {
+		return runOnTargets(ctx, cfg, input.Output, input.Format, func(ctx context.Context, cfg *dbConfig, output Printer) (interface{}, error) {
+			report, err := statusTarget(ctx, input, cfg, migrations, output)
+			if report == nil {
+				return nil, err
+			}
+			return report, err
+		})
 	}
-	source, err := sourceFactory.NewMigrationSource(filepath.Dir(input.ConfigFile), cfg.MigrationSourceParams)
+
+	report, err := statusTarget(ctx, input, cfg, migrations, input.Output)
 	if err != nil {
-		return fmt.Errorf("error creating migration source: %s", err)
+		return err
 	}
-	migrations, err := source.MigrationEntries()
+	if input.Format == FormatJSON {
+		return printJSON(input.Output, report)
+	}
+	return nil
+}
+
+// statusTarget executes the status command on a single DB. It prints the
+// status to output only in text format.
+func statusTarget(ctx context.Context, input *CmdStatusInput, cfg *dbConfig, migrations MigrationEntries, output Printer) (*StatusReport, error) {
+	m, db, err := openMigrator(cfg, migrations, &MigratorOptions{})
 	if err != nil {
-		return fmt.Errorf("error loading migrations: %s", err)
+		return nil, err
 	}
+	defer db.Close()
 
-	forwardMigrations, err := mdb.GetForwardMigrations(db)
+	report, err := m.Status(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	var invalidNames []string
-	forwardMap := make(map[string]struct{}, len(forwardMigrations))
-	for _, m := range forwardMigrations {
-		_, ok := migrations.IndexForName(m.Name)
-		if !ok {
-			invalidNames = append(invalidNames, m.Name)
-		} else {
-			forwardMap[m.Name] = struct{}{}
-		}
+	if input.Format == FormatJSON {
+		return report, nil
 	}
 
 	checkbox := func(checked bool) string {
@@ -74,20 +79,50 @@ func CmdStatus(input *CmdStatusInput) error {
 		return "[ ]"
 	}
 
-	numMigrations := migrations.NumMigrations()
-	for i := 0; i < numMigrations; i++ {
-		name := migrations.Name(i)
-		_, ok := forwardMap[name]
-		input.Output.Printf("%s %s\n", checkbox(ok), name)
+	for _, ms := range report.Migrations {
+		var notes []string
+		if ms.Repeatable {
+			notes = append(notes, "repeatable")
+		}
+		if ms.Modified {
+			notes = append(notes, "modified since applied")
+		}
+		if len(notes) != 0 {
+			output.Printf("%s %s (%s)\n", checkbox(ms.Applied), ms.Name, strings.Join(notes, ", "))
+		} else {
+			output.Printf("%s %s\n", checkbox(ms.Applied), ms.Name)
+		}
+		if input.Verbose && ms.Applied {
+			output.Printf("    %s\n", appliedDetails(ms))
+		}
 	}
 
-	for _, name := range invalidNames {
-		input.Output.Printf("!!! Invalid name in migrations table: %s\n", name)
+	for _, name := range report.InvalidNames {
+		output.Printf("!!! Invalid name in migrations table: %s\n", name)
 	}
 
-	if numMigrations == 0 && len(invalidNames) == 0 {
-		input.Output.Println("There are no migrations.")
+	if len(report.Migrations) == 0 && len(report.InvalidNames) == 0 {
+		output.Println("There are no migrations.")
 	}
 
-	return nil
+	return report, nil
+}
+
+// appliedDetails returns the details of an applied migration
+// for the verbose text output of the status command.
+func appliedDetails(ms *MigrationStatus) string {
+	parts := []string{"applied at " + ms.AppliedAt.UTC().Format("2006-01-02 15:04:05 UTC")}
+	if ms.AppliedBy != "" {
+		parts = append(parts, "by "+ms.AppliedBy)
+	}
+	if ms.Hostname != "" {
+		parts = append(parts, "on "+ms.Hostname)
+	}
+	if ms.Version != "" {
+		parts = append(parts, "with migrate "+ms.Version)
+	}
+	if ms.DurationMillis != 0 {
+		parts = append(parts, "in "+(time.Duration(ms.DurationMillis)*time.Millisecond).String())
+	}
+	return strings.Join(parts, " ")
 }
@@ -0,0 +1,52 @@
+package core
+
+import (
+	"fmt"
+)
+
+type CmdValidateInput struct {
+	Output     Printer
+	ConfigFile string
+	DB         string
+}
+
+func CmdValidate(input *CmdValidateInput) error {
+	cfg, err := loadDBConfig(input.ConfigFile, input.DB)
+	if err != nil {
+		return err
+	}
+
+	source, err := newMigrationSource(cfg, input.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	validator, ok := source.(MigrationSourceValidator)
+	if !ok {
+		// The best we can do is loading the migrations.
+		if _, err := source.MigrationEntries(); err != nil {
+			return fmt.Errorf("error loading migrations: %s", err)
+		}
+		input.Output.Println("No problems found.")
+		return nil
+	}
+
+	numErrors := 0
+	problems := validator.Validate()
+	for _, p := range problems {
+		if p.Warning {
+			input.Output.Println("WARNING:", p.Message)
+		} else {
+			input.Output.Println("ERROR:", p.Message)
+			numErrors++
+		}
+	}
+
+	if numErrors != 0 {
+		return fmt.Errorf("found %d error(s) and %d warning(s)", numErrors, len(problems)-numErrors)
+	}
+	if len(problems) == 0 {
+		input.Output.Println("No problems found.")
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"os"
+	"time"
 )
 
 type dbConfig struct {
@@ -17,31 +18,96 @@ type dbConfig struct {
 	MigrationSourceType   string
 	MigrationSourceParams map[string]string
 	AllowMigrationGaps    bool
+	LockTimeout           time.Duration
+
+	FailOnChecksumMismatch bool
+
+	// StatementTimeout is the default execution timeout of the migrations
+	// applied by the goto command. Zero means no timeout.
+	StatementTimeout time.Duration
+
+	// AppliedBy is recorded in the migrations table. The name of the OS
+	// user is recorded if it is empty.
+	AppliedBy string
+
+	// Targets is nil if the config section describes a single DB.
+	Targets *targetsConfig
+
+	// Name is the name of the config section.
+	Name string
+	// Tags are the custom values of the conditional blocks of migrations
+	// besides the "db" value that is the name of the config section.
+	// See ConditionalMigrationSource.
+	Tags map[string]string
+	// Vars are the values of the {var:name} template parameters of the
+	// migrations. See VariableMigrationSource.
+	Vars map[string]string
+
+	// Hooks are executed by the goto and redo commands.
+	Hooks Hooks
 }
 
 func (o *dbConfig) Validate() error {
-	dsn, err := performSubstitution(o.DataSource)
+	if o.Driver == "" {
+		return errors.New("missing db.driver field")
+	}
+	if o.DataSource == "" {
+		return errors.New("missing db.data_source field")
+	}
+
+	if o.Targets != nil {
+		if err := o.Targets.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// The {target} parameter is substituted later by forTarget.
+	dsn, err := performTemplateSubstitution(o.DataSource, o.Targets != nil)
 	if err != nil {
 		return fmt.Errorf("error substituting template parameters to db.data_source %q: %s", o.DataSource, err)
 	}
 	o.DataSource = dsn
+
+	appliedBy, err := performSubstitution(o.AppliedBy)
+	if err != nil {
+		return fmt.Errorf("error substituting template parameters to applied_by %q: %s", o.AppliedBy, err)
+	}
+	o.AppliedBy = appliedBy
 	return nil
 }
 
 func performSubstitution(s string) (string, error) {
+	return performTemplateSubstitution(s, false)
+}
+
+// performTemplateSubstitution substitutes the {env:VAR} and {cmd:command}
+// template parameters. With keepUnknown=true the unknown template
+// parameters are kept and the result remains in escaped format so it
+// can be parsed again.
+func performTemplateSubstitution(s string, keepUnknown bool) (string, error) {
 	sections, err := template.Parse(s)
 	if err != nil {
 		return "", err
 	}
 	return template.Execute(&template.ExecuteInput{
-		Sections:     sections,
-		LookupVar:    os.LookupEnv,
-		ExecCmd:      template.RemoveTrailingNewlines(template.ExecCmd),
-		VarParamName: "env",
-		CmdParamName: "cmd",
+		Sections:                    sections,
+		LookupVar:                   os.LookupEnv,
+		ExecCmd:                     template.RemoveTrailingNewlines(template.ExecCmd),
+		VarParamName:                "env",
+		CmdParamName:                "cmd",
+		IgnoreUnknownTemplateParams: keepUnknown,
+		EscapedResult:               keepUnknown,
 	})
 }
 
+// hookSection is a hook of the hooks field of a config section.
+type hookSection struct {
+	SQL         string  `yaml:"sql"`
+	Cmd         string  `yaml:"cmd"`
+	Timeout     *string `yaml:"timeout"`
+	IgnoreError bool    `yaml:"ignore_error"`
+}
+
 func loadConfigFile(filename string) (map[string]*dbConfig, error) {
 	b, err := ioutil.ReadFile(filename)
 	if err != nil {
@@ -52,6 +118,29 @@ func loadConfigFile(filename string) (map[string]*dbConfig, error) {
 		DB                 map[string]string `yaml:"db"`
 		MigrationSource    map[string]string `yaml:"migration_source"`
 		AllowMigrationGaps bool              `yaml:"allow_migration_gaps"`
+		LockTimeout        *string           `yaml:"lock_timeout"`
+
+		FailOnChecksumMismatch bool    `yaml:"fail_on_checksum_mismatch"`
+		StatementTimeout       *string `yaml:"statement_timeout"`
+		AppliedBy              string  `yaml:"applied_by"`
+
+		Tags map[string]string `yaml:"tags"`
+		Vars map[string]string `yaml:"vars"`
+
+		Hooks struct {
+			BeforeGoto []*hookSection `yaml:"before_goto"`
+			AfterGoto  []*hookSection `yaml:"after_goto"`
+			BeforeEach []*hookSection `yaml:"before_each"`
+			AfterEach  []*hookSection `yaml:"after_each"`
+			OnFailure  []*hookSection `yaml:"on_failure"`
+		} `yaml:"hooks"`
+
+		Targets *struct {
+			List        []string `yaml:"list"`
+			Query       string   `yaml:"query"`
+			DataSource  string   `yaml:"data_source"`
+			Parallelism int      `yaml:"parallelism"`
+		} `yaml:"targets"`
 	}
 	var cfg map[string]*section
 	err = yaml.UnmarshalStrict(b, &cfg)
@@ -59,17 +148,13 @@ func loadConfigFile(filename string) (map[string]*dbConfig, error) {
 		return nil, err
 	}
 
-	section2DBConfig := func(s *section) (*dbConfig, error) {
-		driver, ok := s.DB["driver"]
-		if !ok {
-			return nil, errors.New("missing db.driver field")
-		}
+	// The db.driver and db.data_source fields are checked by
+	// dbConfig.Validate because some commands don't need a DB.
+	section2DBConfig := func(name string, s *section) (*dbConfig, error) {
+		driver := s.DB["driver"]
 		delete(s.DB, "driver")
 
-		dsn, ok := s.DB["data_source"]
-		if !ok {
-			return nil, errors.New("missing db.data_source field")
-		}
+		dsn := s.DB["data_source"]
 		delete(s.DB, "data_source")
 
 		mst, ok := s.MigrationSource["type"]
@@ -78,6 +163,58 @@ func loadConfigFile(filename string) (map[string]*dbConfig, error) {
 		}
 		delete(s.MigrationSource, "type")
 
+		lockTimeout, err := parseDuration("lock_timeout", s.LockTimeout, defaultLockTimeout)
+		if err != nil {
+			return nil, err
+		}
+		statementTimeout, err := parseDuration("statement_timeout", s.StatementTimeout, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := s.Tags[dbCondition]; ok {
+			return nil, fmt.Errorf("the %q tag is reserved for the name of the config section", dbCondition)
+		}
+
+		var hooks Hooks
+		for _, h := range []struct {
+			Name string
+			Src  []*hookSection
+			Dest *[]*Hook
+		}{
+			{hookBeforeGoto, s.Hooks.BeforeGoto, &hooks.BeforeGoto},
+			{hookAfterGoto, s.Hooks.AfterGoto, &hooks.AfterGoto},
+			{hookBeforeEach, s.Hooks.BeforeEach, &hooks.BeforeEach},
+			{hookAfterEach, s.Hooks.AfterEach, &hooks.AfterEach},
+			{hookOnFailure, s.Hooks.OnFailure, &hooks.OnFailure},
+		} {
+			for i, hs := range h.Src {
+				timeout, err := parseDuration(fmt.Sprintf("hooks.%s[%d].timeout", h.Name, i), hs.Timeout, 0)
+				if err != nil {
+					return nil, err
+				}
+				*h.Dest = append(*h.Dest, &Hook{
+					SQL:         hs.SQL,
+					Cmd:         hs.Cmd,
+					Timeout:     timeout,
+					IgnoreError: hs.IgnoreError,
+				})
+			}
+		}
+		if err := hooks.Validate(); err != nil {
+			return nil, err
+		}
+
+		var targets *targetsConfig
+		if s.Targets != nil {
+			targets = &targetsConfig{
+				List:        s.Targets.List,
+				Query:       s.Targets.Query,
+				DataSource:  s.Targets.DataSource,
+				Parallelism: s.Targets.Parallelism,
+			}
+		}
+
 		return &dbConfig{
 			Driver:                driver,
 			DriverParams:          s.DB,
@@ -85,12 +222,24 @@ func loadConfigFile(filename string) (map[string]*dbConfig, error) {
 			MigrationSourceType:   mst,
 			MigrationSourceParams: s.MigrationSource,
 			AllowMigrationGaps:    s.AllowMigrationGaps,
+			LockTimeout:           lockTimeout,
+
+			FailOnChecksumMismatch: s.FailOnChecksumMismatch,
+			StatementTimeout:       statementTimeout,
+			AppliedBy:              s.AppliedBy,
+			Targets:                targets,
+
+			Name: name,
+			Tags: s.Tags,
+			Vars: s.Vars,
+
+			Hooks: hooks,
 		}, nil
 	}
 
 	res := make(map[string]*dbConfig, len(cfg))
 	for name, sect := range cfg {
-		dbc, err := section2DBConfig(sect)
+		dbc, err := section2DBConfig(name, sect)
 		if err != nil {
 			return nil, fmt.Errorf("error loading section %q from config: %s", name, err)
 		}
@@ -99,7 +248,9 @@ func loadConfigFile(filename string) (map[string]*dbConfig, error) {
 	return res, err
 }
 
-func loadAndValidateDBConfig(configFilename, db string) (*dbConfig, error) {
+// loadDBConfig loads a section of the config file without validating the
+// DB settings. It is used by commands that don't connect to the DB.
+func loadDBConfig(configFilename, db string) (*dbConfig, error) {
 	cfg, err := loadConfigFile(configFilename)
 	if err != nil {
 		return nil, fmt.Errorf("error loading config file %q: %s", configFilename, err)
@@ -109,6 +260,44 @@ func loadAndValidateDBConfig(configFilename, db string) (*dbConfig, error) {
 	if !ok {
 		return nil, fmt.Errorf("DB %q isn't defined in config file %q", db, configFilename)
 	}
+	return dbCfg, nil
+}
+
+func loadAndValidateDBConfig(configFilename, db string) (*dbConfig, error) {
+	dbCfg, err := loadDBConfig(configFilename, db)
+	if err != nil {
+		return nil, err
+	}
+	if err := dbCfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid DB %q in config file %q: %s", db, configFilename, err)
+	}
+	return dbCfg, nil
+}
 
-	return dbCfg, dbCfg.Validate()
+// loadAndValidateSingleDBConfig is loadAndValidateDBConfig for the commands
+// that don't support the targets of a config section.
+func loadAndValidateSingleDBConfig(configFilename, db, command string) (*dbConfig, error) {
+	dbCfg, err := loadAndValidateDBConfig(configFilename, db)
+	if err != nil {
+		return nil, err
+	}
+	if dbCfg.Targets != nil {
+		return nil, fmt.Errorf("the %s command doesn't support DB %q with targets in config file %q", command, db, configFilename)
+	}
+	return dbCfg, nil
+}
+
+// parseDuration parses the optional non-negative duration config field key.
+func parseDuration(key string, s *string, defaultValue time.Duration) (time.Duration, error) {
+	if s == nil {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(*s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %s", key, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative %s: %v", key, d)
+	}
+	return d, nil
 }
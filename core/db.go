@@ -1,6 +1,7 @@
 package core
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 )
@@ -12,17 +13,19 @@ import (
 // Note: the current implementation isn't goroutine safe.
 
 type Querier interface {
-	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
 }
 
 type Execer interface {
-	Exec(query string, args ...interface{}) (sql.Result, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
 }
 
 type DB interface {
 	Querier
 	Execer
-	BeginTX() (TX, error)
+	// BeginTX starts a transaction. The transaction is rolled back by
+	// database/sql if ctx is cancelled before Commit.
+	BeginTX(ctx context.Context) (TX, error)
 }
 
 type ClosableDB interface {
@@ -44,7 +47,7 @@ type TXCloser interface {
 type stdDB interface {
 	Querier
 	Execer
-	Begin() (*sql.Tx, error)
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
 	Close() error
 }
 
@@ -63,8 +66,8 @@ type dbWrapper struct {
 	stdDB
 }
 
-func (o dbWrapper) BeginTX() (TX, error) {
-	tx, err := o.stdDB.Begin()
+func (o dbWrapper) BeginTX(ctx context.Context) (TX, error) {
+	tx, err := o.stdDB.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -79,7 +82,7 @@ type txWrapper struct {
 	stdTx
 }
 
-func (o *txWrapper) BeginTX() (TX, error) {
+func (o *txWrapper) BeginTX(ctx context.Context) (TX, error) {
 	return wrapTxWrapper(o), nil
 }
 
@@ -92,7 +95,7 @@ type recursiveTXWrapper struct {
 	finished bool
 }
 
-func (o *recursiveTXWrapper) BeginTX() (TX, error) {
+func (o *recursiveTXWrapper) BeginTX(ctx context.Context) (TX, error) {
 	return newParentTX(&recursiveTXWrapper{TX: o}), nil
 }
 
@@ -129,8 +132,8 @@ type parentTX struct {
 	hasRollback bool
 }
 
-func (o *parentTX) BeginTX() (TX, error) {
-	tx, err := o.TX.BeginTX()
+func (o *parentTX) BeginTX(ctx context.Context) (TX, error) {
+	tx, err := o.TX.BeginTX(ctx)
 	if err == nil {
 		tx = &childTX{
 			TX:     tx,
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"context"
 	"database/sql"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
@@ -15,9 +16,9 @@ func TestDBWrapper(t *testing.T) {
 			w := WrapDB(db)
 
 			fakeTx := &sql.Tx{}
-			db.EXPECT().Begin().Return(fakeTx, nil)
+			db.EXPECT().BeginTx(context.Background(), nil).Return(fakeTx, nil)
 
-			tx, err := w.BeginTX()
+			tx, err := w.BeginTX(context.Background())
 
 			assert.NoError(t, err)
 			assert.NotNil(t, tx)
@@ -30,9 +31,9 @@ func TestDBWrapper(t *testing.T) {
 			db := NewMockstdDB(ctrl)
 			w := dbWrapper{db}
 
-			db.EXPECT().Begin().Return(nil, assert.AnError)
+			db.EXPECT().BeginTx(context.Background(), nil).Return(nil, assert.AnError)
 
-			_, err := w.BeginTX()
+			_, err := w.BeginTX(context.Background())
 
 			assert.Equal(t, assert.AnError, err)
 			ctrl.Finish()
@@ -73,9 +74,9 @@ func TestDBWrapper(t *testing.T) {
 			w := WrapDB(db)
 
 			rows := &sql.Rows{}
-			db.EXPECT().Query("test query", "arg1", 2).Return(rows, nil)
+			db.EXPECT().QueryContext(context.Background(), "test query", "arg1", 2).Return(rows, nil)
 
-			res, err := w.Query("test query", "arg1", 2)
+			res, err := w.QueryContext(context.Background(), "test query", "arg1", 2)
 
 			assert.NoError(t, err)
 			assert.Equal(t, rows, res)
@@ -86,9 +87,9 @@ func TestDBWrapper(t *testing.T) {
 			db := NewMockstdDB(ctrl)
 			w := WrapDB(db)
 
-			db.EXPECT().Query("test query", "arg1", 2).Return(nil, assert.AnError)
+			db.EXPECT().QueryContext(context.Background(), "test query", "arg1", 2).Return(nil, assert.AnError)
 
-			_, err := w.Query("test query", "arg1", 2)
+			_, err := w.QueryContext(context.Background(), "test query", "arg1", 2)
 
 			assert.Equal(t, assert.AnError, err)
 			ctrl.Finish()
@@ -102,9 +103,9 @@ func TestDBWrapper(t *testing.T) {
 			w := WrapDB(db)
 			sqlRes := NewMockResult(ctrl)
 
-			db.EXPECT().Exec("test query", "arg1", 2).Return(sqlRes, nil)
+			db.EXPECT().ExecContext(context.Background(), "test query", "arg1", 2).Return(sqlRes, nil)
 
-			res, err := w.Exec("test query", "arg1", 2)
+			res, err := w.ExecContext(context.Background(), "test query", "arg1", 2)
 
 			assert.NoError(t, err)
 			assert.Equal(t, sqlRes, res)
@@ -115,9 +116,9 @@ func TestDBWrapper(t *testing.T) {
 			db := NewMockstdDB(ctrl)
 			w := WrapDB(db)
 
-			db.EXPECT().Exec("test query", "arg1", 2).Return(nil, assert.AnError)
+			db.EXPECT().ExecContext(context.Background(), "test query", "arg1", 2).Return(nil, assert.AnError)
 
-			_, err := w.Exec("test query", "arg1", 2)
+			_, err := w.ExecContext(context.Background(), "test query", "arg1", 2)
 
 			assert.Equal(t, assert.AnError, err)
 			ctrl.Finish()
@@ -131,7 +132,7 @@ func TestTxWrapper(t *testing.T) {
 		tx := NewMockstdTx(ctrl)
 		w := wrapTx(tx)
 
-		rtx, err := w.BeginTX()
+		rtx, err := w.BeginTX(context.Background())
 
 		assert.NoError(t, err)
 		assert.NotNil(t, rtx)
@@ -147,9 +148,9 @@ func TestTxWrapper(t *testing.T) {
 			w := wrapTx(tx)
 
 			rows := &sql.Rows{}
-			tx.EXPECT().Query("test query", "arg1", 2).Return(rows, nil)
+			tx.EXPECT().QueryContext(context.Background(), "test query", "arg1", 2).Return(rows, nil)
 
-			res, err := w.Query("test query", "arg1", 2)
+			res, err := w.QueryContext(context.Background(), "test query", "arg1", 2)
 
 			assert.NoError(t, err)
 			assert.Equal(t, rows, res)
@@ -160,9 +161,9 @@ func TestTxWrapper(t *testing.T) {
 			tx := NewMockstdTx(ctrl)
 			w := wrapTx(tx)
 
-			tx.EXPECT().Query("test query", "arg1", 2).Return(nil, assert.AnError)
+			tx.EXPECT().QueryContext(context.Background(), "test query", "arg1", 2).Return(nil, assert.AnError)
 
-			_, err := w.Query("test query", "arg1", 2)
+			_, err := w.QueryContext(context.Background(), "test query", "arg1", 2)
 
 			assert.Equal(t, assert.AnError, err)
 			ctrl.Finish()
@@ -176,9 +177,9 @@ func TestTxWrapper(t *testing.T) {
 			w := wrapTx(tx)
 			sqlRes := NewMockResult(ctrl)
 
-			tx.EXPECT().Exec("test query", "arg1", 2).Return(sqlRes, nil)
+			tx.EXPECT().ExecContext(context.Background(), "test query", "arg1", 2).Return(sqlRes, nil)
 
-			res, err := w.Exec("test query", "arg1", 2)
+			res, err := w.ExecContext(context.Background(), "test query", "arg1", 2)
 
 			assert.NoError(t, err)
 			assert.Equal(t, sqlRes, res)
@@ -189,9 +190,9 @@ func TestTxWrapper(t *testing.T) {
 			tx := NewMockstdTx(ctrl)
 			w := wrapTx(tx)
 
-			tx.EXPECT().Exec("test query", "arg1", 2).Return(nil, assert.AnError)
+			tx.EXPECT().ExecContext(context.Background(), "test query", "arg1", 2).Return(nil, assert.AnError)
 
-			_, err := w.Exec("test query", "arg1", 2)
+			_, err := w.ExecContext(context.Background(), "test query", "arg1", 2)
 
 			assert.Equal(t, assert.AnError, err)
 			ctrl.Finish()
@@ -205,7 +206,7 @@ func TestRecursiveTXWrapper(t *testing.T) {
 		tx := NewMockTX(ctrl)
 		w := wrapTxWrapper(tx)
 
-		rtx, err := w.BeginTX()
+		rtx, err := w.BeginTX(context.Background())
 
 		assert.NoError(t, err)
 		assert.NotNil(t, rtx)
@@ -221,9 +222,9 @@ func TestRecursiveTXWrapper(t *testing.T) {
 			w := wrapTxWrapper(tx)
 
 			rows := &sql.Rows{}
-			tx.EXPECT().Query("test query", "arg1", 2).Return(rows, nil)
+			tx.EXPECT().QueryContext(context.Background(), "test query", "arg1", 2).Return(rows, nil)
 
-			res, err := w.Query("test query", "arg1", 2)
+			res, err := w.QueryContext(context.Background(), "test query", "arg1", 2)
 
 			assert.NoError(t, err)
 			assert.Equal(t, rows, res)
@@ -234,9 +235,9 @@ func TestRecursiveTXWrapper(t *testing.T) {
 			tx := NewMockTX(ctrl)
 			w := wrapTxWrapper(tx)
 
-			tx.EXPECT().Query("test query", "arg1", 2).Return(nil, assert.AnError)
+			tx.EXPECT().QueryContext(context.Background(), "test query", "arg1", 2).Return(nil, assert.AnError)
 
-			_, err := w.Query("test query", "arg1", 2)
+			_, err := w.QueryContext(context.Background(), "test query", "arg1", 2)
 
 			assert.Equal(t, assert.AnError, err)
 			ctrl.Finish()
@@ -250,9 +251,9 @@ func TestRecursiveTXWrapper(t *testing.T) {
 			w := wrapTxWrapper(tx)
 			sqlRes := NewMockResult(ctrl)
 
-			tx.EXPECT().Exec("test query", "arg1", 2).Return(sqlRes, nil)
+			tx.EXPECT().ExecContext(context.Background(), "test query", "arg1", 2).Return(sqlRes, nil)
 
-			res, err := w.Exec("test query", "arg1", 2)
+			res, err := w.ExecContext(context.Background(), "test query", "arg1", 2)
 
 			assert.NoError(t, err)
 			assert.Equal(t, sqlRes, res)
@@ -263,9 +264,9 @@ func TestRecursiveTXWrapper(t *testing.T) {
 			tx := NewMockTX(ctrl)
 			w := wrapTxWrapper(tx)
 
-			tx.EXPECT().Exec("test query", "arg1", 2).Return(nil, assert.AnError)
+			tx.EXPECT().ExecContext(context.Background(), "test query", "arg1", 2).Return(nil, assert.AnError)
 
-			_, err := w.Exec("test query", "arg1", 2)
+			_, err := w.ExecContext(context.Background(), "test query", "arg1", 2)
 
 			assert.Equal(t, assert.AnError, err)
 			ctrl.Finish()
@@ -279,14 +280,14 @@ func TestNestedTransactions(t *testing.T) {
 		stdDB := NewMockstdDB(ctrl)
 
 		fakeTx := &sql.Tx{}
-		stdDB.EXPECT().Begin().Return(fakeTx, nil)
+		stdDB.EXPECT().BeginTx(context.Background(), nil).Return(fakeTx, nil)
 
 		db := WrapDB(stdDB)
-		tx, err := db.BeginTX()
+		tx, err := db.BeginTX(context.Background())
 		assert.NoError(t, err)
-		rtx, err := tx.BeginTX()
+		rtx, err := tx.BeginTX(context.Background())
 		assert.NoError(t, err)
-		rtx2, err := rtx.BeginTX()
+		rtx2, err := rtx.BeginTX(context.Background())
 		assert.NoError(t, err)
 
 		err = rtx2.Commit()
@@ -302,14 +303,14 @@ func TestNestedTransactions(t *testing.T) {
 		stdDB := NewMockstdDB(ctrl)
 
 		fakeTx := &sql.Tx{}
-		stdDB.EXPECT().Begin().Return(fakeTx, nil)
+		stdDB.EXPECT().BeginTx(context.Background(), nil).Return(fakeTx, nil)
 
 		db := WrapDB(stdDB)
-		tx, err := db.BeginTX()
+		tx, err := db.BeginTX(context.Background())
 		assert.NoError(t, err)
-		rtx, err := tx.BeginTX()
+		rtx, err := tx.BeginTX(context.Background())
 		assert.NoError(t, err)
-		rtx2, err := rtx.BeginTX()
+		rtx2, err := rtx.BeginTX(context.Background())
 		assert.NoError(t, err)
 
 		err = rtx2.Rollback()
@@ -326,14 +327,14 @@ func TestNestedTransactions(t *testing.T) {
 			stdDB := NewMockstdDB(ctrl)
 
 			fakeTx := &sql.Tx{}
-			stdDB.EXPECT().Begin().Return(fakeTx, nil)
+			stdDB.EXPECT().BeginTx(context.Background(), nil).Return(fakeTx, nil)
 
 			db := WrapDB(stdDB)
-			tx, err := db.BeginTX()
+			tx, err := db.BeginTX(context.Background())
 			assert.NoError(t, err)
-			rtx, err := tx.BeginTX()
+			rtx, err := tx.BeginTX(context.Background())
 			assert.NoError(t, err)
-			rtx2, err := rtx.BeginTX()
+			rtx2, err := rtx.BeginTX(context.Background())
 			assert.NoError(t, err)
 
 			err = rtx2.Rollback()
@@ -348,14 +349,14 @@ func TestNestedTransactions(t *testing.T) {
 			stdDB := NewMockstdDB(ctrl)
 
 			fakeTx := &sql.Tx{}
-			stdDB.EXPECT().Begin().Return(fakeTx, nil)
+			stdDB.EXPECT().BeginTx(context.Background(), nil).Return(fakeTx, nil)
 
 			db := WrapDB(stdDB)
-			tx, err := db.BeginTX()
+			tx, err := db.BeginTX(context.Background())
 			assert.NoError(t, err)
-			rtx, err := tx.BeginTX()
+			rtx, err := tx.BeginTX(context.Background())
 			assert.NoError(t, err)
-			rtx2, err := rtx.BeginTX()
+			rtx2, err := rtx.BeginTX(context.Background())
 			assert.NoError(t, err)
 
 			err = rtx2.Rollback()
@@ -372,14 +373,14 @@ func TestNestedTransactions(t *testing.T) {
 			stdDB := NewMockstdDB(ctrl)
 
 			fakeTx := &sql.Tx{}
-			stdDB.EXPECT().Begin().Return(fakeTx, nil)
+			stdDB.EXPECT().BeginTx(context.Background(), nil).Return(fakeTx, nil)
 
 			db := WrapDB(stdDB)
-			tx, err := db.BeginTX()
+			tx, err := db.BeginTX(context.Background())
 			assert.NoError(t, err)
-			rtx, err := tx.BeginTX()
+			rtx, err := tx.BeginTX(context.Background())
 			assert.NoError(t, err)
-			rtx2, err := rtx.BeginTX()
+			rtx2, err := rtx.BeginTX(context.Background())
 			assert.NoError(t, err)
 
 			err = rtx2.Commit()
@@ -400,14 +401,14 @@ func TestNestedTransactions(t *testing.T) {
 				stdDB := NewMockstdDB(ctrl)
 
 				fakeTx := &sql.Tx{}
-				stdDB.EXPECT().Begin().Return(fakeTx, nil)
+				stdDB.EXPECT().BeginTx(context.Background(), nil).Return(fakeTx, nil)
 
 				db := WrapDB(stdDB)
-				tx, err := db.BeginTX()
+				tx, err := db.BeginTX(context.Background())
 				assert.NoError(t, err)
-				rtx, err := tx.BeginTX()
+				rtx, err := tx.BeginTX(context.Background())
 				assert.NoError(t, err)
-				rtx2, err := rtx.BeginTX()
+				rtx2, err := rtx.BeginTX(context.Background())
 				assert.NoError(t, err)
 
 				err = rtx2.Commit()
@@ -424,14 +425,14 @@ func TestNestedTransactions(t *testing.T) {
 				stdDB := NewMockstdDB(ctrl)
 
 				fakeTx := &sql.Tx{}
-				stdDB.EXPECT().Begin().Return(fakeTx, nil)
+				stdDB.EXPECT().BeginTx(context.Background(), nil).Return(fakeTx, nil)
 
 				db := WrapDB(stdDB)
-				tx, err := db.BeginTX()
+				tx, err := db.BeginTX(context.Background())
 				assert.NoError(t, err)
-				rtx, err := tx.BeginTX()
+				rtx, err := tx.BeginTX(context.Background())
 				assert.NoError(t, err)
-				rtx2, err := rtx.BeginTX()
+				rtx2, err := rtx.BeginTX(context.Background())
 				assert.NoError(t, err)
 
 				err = rtx2.Commit()
@@ -453,14 +454,14 @@ func TestNestedTransactions(t *testing.T) {
 				stdDB := NewMockstdDB(ctrl)
 
 				fakeTx := &sql.Tx{}
-				stdDB.EXPECT().Begin().Return(fakeTx, nil)
+				stdDB.EXPECT().BeginTx(context.Background(), nil).Return(fakeTx, nil)
 
 				db := WrapDB(stdDB)
-				tx, err := db.BeginTX()
+				tx, err := db.BeginTX(context.Background())
 				assert.NoError(t, err)
-				rtx, err := tx.BeginTX()
+				rtx, err := tx.BeginTX(context.Background())
 				assert.NoError(t, err)
-				rtx2, err := rtx.BeginTX()
+				rtx2, err := rtx.BeginTX(context.Background())
 				assert.NoError(t, err)
 
 				err = rtx2.Rollback()
@@ -477,14 +478,14 @@ func TestNestedTransactions(t *testing.T) {
 				stdDB := NewMockstdDB(ctrl)
 
 				fakeTx := &sql.Tx{}
-				stdDB.EXPECT().Begin().Return(fakeTx, nil)
+				stdDB.EXPECT().BeginTx(context.Background(), nil).Return(fakeTx, nil)
 
 				db := WrapDB(stdDB)
-				tx, err := db.BeginTX()
+				tx, err := db.BeginTX(context.Background())
 				assert.NoError(t, err)
-				rtx, err := tx.BeginTX()
+				rtx, err := tx.BeginTX(context.Background())
 				assert.NoError(t, err)
-				rtx2, err := rtx.BeginTX()
+				rtx2, err := rtx.BeginTX(context.Background())
 				assert.NoError(t, err)
 
 				err = rtx2.Rollback()
@@ -508,14 +509,14 @@ func TestNestedTransactions(t *testing.T) {
 				stdDB := NewMockstdDB(ctrl)
 
 				fakeTx := &sql.Tx{}
-				stdDB.EXPECT().Begin().Return(fakeTx, nil)
+				stdDB.EXPECT().BeginTx(context.Background(), nil).Return(fakeTx, nil)
 
 				db := WrapDB(stdDB)
-				tx, err := db.BeginTX()
+				tx, err := db.BeginTX(context.Background())
 				assert.NoError(t, err)
-				rtx, err := tx.BeginTX()
+				rtx, err := tx.BeginTX(context.Background())
 				assert.NoError(t, err)
-				_, err = rtx.BeginTX()
+				_, err = rtx.BeginTX(context.Background())
 				assert.NoError(t, err)
 
 				err = rtx.Commit()
@@ -528,12 +529,12 @@ func TestNestedTransactions(t *testing.T) {
 				stdDB := NewMockstdDB(ctrl)
 
 				fakeTx := &sql.Tx{}
-				stdDB.EXPECT().Begin().Return(fakeTx, nil)
+				stdDB.EXPECT().BeginTx(context.Background(), nil).Return(fakeTx, nil)
 
 				db := WrapDB(stdDB)
-				tx, err := db.BeginTX()
+				tx, err := db.BeginTX(context.Background())
 				assert.NoError(t, err)
-				_, err = tx.BeginTX()
+				_, err = tx.BeginTX(context.Background())
 				assert.NoError(t, err)
 
 				err = tx.Commit()
@@ -548,14 +549,14 @@ func TestNestedTransactions(t *testing.T) {
 				stdDB := NewMockstdDB(ctrl)
 
 				fakeTx := &sql.Tx{}
-				stdDB.EXPECT().Begin().Return(fakeTx, nil)
+				stdDB.EXPECT().BeginTx(context.Background(), nil).Return(fakeTx, nil)
 
 				db := WrapDB(stdDB)
-				tx, err := db.BeginTX()
+				tx, err := db.BeginTX(context.Background())
 				assert.NoError(t, err)
-				rtx, err := tx.BeginTX()
+				rtx, err := tx.BeginTX(context.Background())
 				assert.NoError(t, err)
-				_, err = rtx.BeginTX()
+				_, err = rtx.BeginTX(context.Background())
 				assert.NoError(t, err)
 
 				err = rtx.Rollback()
@@ -568,12 +569,12 @@ func TestNestedTransactions(t *testing.T) {
 				stdDB := NewMockstdDB(ctrl)
 
 				fakeTx := &sql.Tx{}
-				stdDB.EXPECT().Begin().Return(fakeTx, nil)
+				stdDB.EXPECT().BeginTx(context.Background(), nil).Return(fakeTx, nil)
 
 				db := WrapDB(stdDB)
-				tx, err := db.BeginTX()
+				tx, err := db.BeginTX(context.Background())
 				assert.NoError(t, err)
-				_, err = tx.BeginTX()
+				_, err = tx.BeginTX(context.Background())
 				assert.NoError(t, err)
 
 				err = tx.Rollback()
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ExecError is returned by the goto and hack commands when the execution of
+// a migration fails. The fields other than Err are optional and zero if
+// unknown.
+type ExecError struct {
+	Migration string
+	Forward   bool
+	// Path is the path of the migration file.
+	Path string
+	// Statement is the SQL statement that failed and StatementLine is the
+	// 1-based line number of its first line in the migration file.
+	Statement     string
+	StatementLine int
+	// Line and Column are the 1-based location of the error in the
+	// migration file. If the DB doesn't report the position of the error
+	// then they point to the beginning of Statement.
+	Line   int
+	Column int
+	Err    error
+}
+
+func (o *ExecError) Error() string {
+	s := "error"
+	if o.Migration != "" {
+		s = fmt.Sprintf("error in %s migration %s", direction(o.Forward), o.Migration)
+	}
+	if loc := o.Location(); loc != "" {
+		s += " at " + loc
+	}
+	return s + ": " + o.Err.Error()
+}
+
+func (o *ExecError) Unwrap() error {
+	return o.Err
+}
+
+// Location returns the location of the error in "path:line:column" format.
+// The unknown parts are omitted. It returns "line N" if Path is empty.
+func (o *ExecError) Location() string {
+	var pos string
+	if o.Line != 0 {
+		pos = fmt.Sprint(o.Line)
+		if o.Column != 0 {
+			pos += fmt.Sprintf(":%d", o.Column)
+		}
+	}
+	switch {
+	case o.Path == "" && pos == "":
+		return ""
+	case o.Path == "":
+		return "line " + pos
+	case pos == "":
+		return o.Path
+	default:
+		return o.Path + ":" + pos
+	}
+}
+
+// StatementExcerpt returns the lines of the failed statement prefixed with
+// their line numbers. The line of the error is marked with '>'.
+// It returns an empty string if Statement is unknown.
+func (o *ExecError) StatementExcerpt() string {
+	if o.Statement == "" {
+		return ""
+	}
+	lines := strings.Split(o.Statement, "\n")
+	first := o.StatementLine
+	if first == 0 {
+		first = 1
+	}
+	width := len(fmt.Sprint(first + len(lines) - 1))
+
+	var sb strings.Builder
+	for i, line := range lines {
+		marker := " "
+		if first+i == o.Line {
+			marker = ">"
+		}
+		fmt.Fprintf(&sb, "%s %*d | %s\n", marker, width, first+i, line)
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// wrapExecError adds the name and direction of the migration to err.
+func wrapExecError(migration string, forward bool, err error) error {
+	var ee *ExecError
+	if errors.As(err, &ee) {
+		if ee.Migration == "" {
+			ee.Migration = migration
+			ee.Forward = forward
+		}
+		return err
+	}
+	return &ExecError{
+		Migration: migration,
+		Forward:   forward,
+		Err:       err,
+	}
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestExecError_Error(t *testing.T) {
+	tests := []*struct {
+		name   string
+		err    *ExecError
+		output string
+	}{
+		{
+			"only Err",
+			&ExecError{Err: assert.AnError},
+			"error: " + assert.AnError.Error(),
+		},
+		{
+			"migration",
+			&ExecError{Migration: "0001_a.sql", Forward: true, Err: assert.AnError},
+			"error in forward migration 0001_a.sql: " + assert.AnError.Error(),
+		},
+		{
+			"line without path",
+			&ExecError{Migration: "0001_a.sql", Line: 3, Err: assert.AnError},
+			"error in backward migration 0001_a.sql at line 3: " + assert.AnError.Error(),
+		},
+		{
+			"path, line and column",
+			&ExecError{Migration: "0001_a.sql", Forward: true, Path: "m/0001_a.sql", Line: 3, Column: 7, Err: assert.AnError},
+			"error in forward migration 0001_a.sql at m/0001_a.sql:3:7: " + assert.AnError.Error(),
+		},
+		{
+			"path without line",
+			&ExecError{Path: "m/0001_a.sql", Err: assert.AnError},
+			"error at m/0001_a.sql: " + assert.AnError.Error(),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.output, test.err.Error())
+		})
+	}
+}
+
+func TestExecError_StatementExcerpt(t *testing.T) {
+	t.Run("no statement", func(t *testing.T) {
+		err := &ExecError{Err: assert.AnError}
+		assert.Equal(t, "", err.StatementExcerpt())
+	})
+
+	t.Run("error line", func(t *testing.T) {
+		err := &ExecError{
+			Statement:     "SELECT\n  x\nFROM t",
+			StatementLine: 9,
+			Line:          10,
+			Err:           assert.AnError,
+		}
+		assert.Equal(t, "   9 | SELECT\n> 10 |   x\n  11 | FROM t", err.StatementExcerpt())
+	})
+}
+
+func TestWrapExecError(t *testing.T) {
+	t.Run("plain error", func(t *testing.T) {
+		err := wrapExecError("0001_a.sql", true, assert.AnError)
+		assert.Equal(t, &ExecError{Migration: "0001_a.sql", Forward: true, Err: assert.AnError}, err)
+	})
+
+	t.Run("wrapped ExecError", func(t *testing.T) {
+		ee := &ExecError{Path: "0001_a.sql", Line: 2, Err: assert.AnError}
+		err := wrapExecError("0001_a.sql", false, fmt.Errorf("timed out: %w", ee))
+
+		var target *ExecError
+		assert.True(t, errors.As(err, &target))
+		assert.Equal(t, &ExecError{Migration: "0001_a.sql", Path: "0001_a.sql", Line: 2, Err: assert.AnError}, target)
+	})
+}
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"database/sql/driver"
+	"strings"
+	"time"
+)
+
+// Results of HistoryEntry.
+const (
+	HistoryOK     = "ok"
+	HistoryFailed = "failed"
+)
+
+// Commands of HistoryEntry.
+const (
+	HistoryGoto     = "goto"
+	HistoryRedo     = "redo"
+	HistoryHack     = "hack"
+	HistoryBaseline = "baseline"
+)
+
+// HistoryEntry is a row of the append-only history table. Unlike the
+// migrations table the history table keeps the records of the backward
+// migrated and failed migrations.
+type HistoryEntry struct {
+	// ID is assigned by the DB. It is zero in the input of
+	// MigrationDB.RecordHistory.
+	ID        int64
+	Time      time.Time
+	Migration string
+	// Direction is either "forward" or "backward".
+	Direction string
+	// Command is one of HistoryGoto, HistoryRedo, HistoryHack or
+	// HistoryBaseline.
+	Command string
+	// Result is either HistoryOK or HistoryFailed.
+	Result string
+	// Error is the error message if Result is HistoryFailed.
+	Error string
+	// Checksum is the checksum of the forward step of forward migrations.
+	Checksum string
+	// Duration is the execution time of the migration. Like in case of
+	// MigrationRecord it can be set after MigrationDB.RecordHistory returns.
+	// Use DurationMillisArg to read it.
+	Duration time.Duration
+	ApplyInfo
+}
+
+// DurationMillisArg returns a query argument that evaluates to Duration
+// in milliseconds at the time the query is executed.
+func (o *HistoryEntry) DurationMillisArg() driver.Valuer {
+	return durationMillisArg{&o.Duration}
+}
+
+// HistoryFilter selects entries from the history. Zero fields don't filter.
+type HistoryFilter struct {
+	Migration string
+	// Since is inclusive.
+	Since time.Time
+	// Until is exclusive.
+	Until time.Time
+	// OnlyFailed selects only the entries with HistoryFailed result.
+	OnlyFailed bool
+	// Limit keeps only the newest Limit entries.
+	Limit int
+}
+
+// SQLCondition returns the WHERE clause of the filter (including the WHERE
+// keyword) and its arguments. The result is an empty string if the filter
+// doesn't filter. Placeholder returns the placeholder of the 1-based nth
+// argument (e.g.: "$1" or "?"). Limit isn't included.
+func (o *HistoryFilter) SQLCondition(placeholder func(n int) string) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, condition+placeholder(len(args)))
+	}
+	if o.Migration != "" {
+		add("name = ", o.Migration)
+	}
+	if !o.Since.IsZero() {
+		add("time >= ", o.Since.UTC())
+	}
+	if !o.Until.IsZero() {
+		add("time < ", o.Until.UTC())
+	}
+	if o.OnlyFailed {
+		add("result = ", HistoryFailed)
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// ReverseHistory reverses the order of entries in place. The drivers query
+// the history in reverse chronological order to apply HistoryFilter.Limit.
+func ReverseHistory(entries []*HistoryEntry) {
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+}
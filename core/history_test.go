@@ -0,0 +1,51 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHistoryFilter_SQLCondition(t *testing.T) {
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	local := time.FixedZone("local", 3600)
+	placeholder := func(n int) string { return "$" + strconv.Itoa(n) }
+
+	tests := []*struct {
+		name      string
+		filter    HistoryFilter
+		condition string
+		args      []interface{}
+	}{
+		{"no filter", HistoryFilter{}, "", nil},
+		{"limit only", HistoryFilter{Limit: 2}, "", nil},
+		{"migration", HistoryFilter{Migration: "2_b"}, " WHERE name = $1", []interface{}{"2_b"}},
+		{"since", HistoryFilter{Since: t0.In(local)}, " WHERE time >= $1", []interface{}{t0}},
+		{"until", HistoryFilter{Until: t0}, " WHERE time < $1", []interface{}{t0}},
+		{"only failed", HistoryFilter{OnlyFailed: true}, " WHERE result = $1", []interface{}{HistoryFailed}},
+		{
+			"all",
+			HistoryFilter{Migration: "1_a", Since: t0, Until: t0.Add(time.Hour), OnlyFailed: true, Limit: 1},
+			" WHERE name = $1 AND time >= $2 AND time < $3 AND result = $4",
+			[]interface{}{"1_a", t0, t0.Add(time.Hour), HistoryFailed},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			condition, args := test.filter.SQLCondition(placeholder)
+			assert.Equal(t, test.condition, condition)
+			assert.Equal(t, test.args, args)
+		})
+	}
+}
+
+func TestReverseHistory(t *testing.T) {
+	e1 := &HistoryEntry{ID: 1}
+	e2 := &HistoryEntry{ID: 2}
+	e3 := &HistoryEntry{ID: 3}
+	entries := []*HistoryEntry{e1, e2, e3}
+	ReverseHistory(entries)
+	assert.Equal(t, []*HistoryEntry{e3, e2, e1}, entries)
+}
@@ -0,0 +1,180 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pasztorpisti/migrate/template"
+	"strings"
+	"time"
+)
+
+// Hook is an SQL statement or a shell command that Migrator.Goto and
+// Migrator.Redo execute at a given point of the migration run.
+// Exactly one of SQL and Cmd has to be set.
+type Hook struct {
+	// SQL is executed through the DB of the Migrator outside of the
+	// transactions of the migrations. It is split into statements like
+	// the migrations.
+	SQL string
+	// Cmd is executed by the shell (see template.ExecCmdContext) with the
+	// MIGRATE_* environment variables described at Hooks. Its stdout is
+	// printed to the output of the Migrator.
+	Cmd string
+	// Timeout is the maximum execution time of the hook.
+	// Zero means no timeout.
+	Timeout time.Duration
+	// IgnoreError turns the failure of the hook into a warning.
+	// Otherwise the failure aborts the migration run.
+	IgnoreError bool
+}
+
+func (o *Hook) Validate() error {
+	if (o.SQL == "") == (o.Cmd == "") {
+		return errors.New("exactly one of sql and cmd has to be set")
+	}
+	if o.Timeout < 0 {
+		return fmt.Errorf("negative timeout: %v", o.Timeout)
+	}
+	return nil
+}
+
+// Hooks are executed by Migrator.Goto and Migrator.Redo. They aren't
+// executed if there is nothing to migrate.
+//
+// The environment of the Cmd hooks contains the following variables:
+//   - MIGRATE_HOOK: before_goto, after_goto, before_each, after_each or
+//     on_failure
+//   - MIGRATE_MIGRATION and MIGRATE_DIRECTION (forward or backward): the
+//     migration of the before_each, after_each and on_failure hooks
+//     (only if the failure is related to a migration in case of on_failure)
+//   - MIGRATE_ERROR: the error message in case of on_failure
+type Hooks struct {
+	// BeforeGoto hooks are executed after acquiring the migration lock
+	// before the first migration. Their failure aborts the run.
+	BeforeGoto []*Hook
+	// AfterGoto hooks are executed after the last migration if all
+	// migrations succeeded.
+	AfterGoto []*Hook
+	// BeforeEach hooks are executed before each migration. Their failure
+	// marks the migration as failed without executing it.
+	BeforeEach []*Hook
+	// AfterEach hooks are executed after each successful migration.
+	// Their failure skips the rest of the migrations.
+	AfterEach []*Hook
+	// OnFailure hooks are executed when a migration or another hook fails
+	// (including interruptions). They don't use the context of the run and
+	// their timeout defaults to 1 minute. Their failures are only warnings.
+	OnFailure []*Hook
+}
+
+func (o *Hooks) Validate() error {
+	for _, h := range []struct {
+		Name  string
+		Hooks []*Hook
+	}{
+		{hookBeforeGoto, o.BeforeGoto},
+		{hookAfterGoto, o.AfterGoto},
+		{hookBeforeEach, o.BeforeEach},
+		{hookAfterEach, o.AfterEach},
+		{hookOnFailure, o.OnFailure},
+	} {
+		for i, hook := range h.Hooks {
+			if err := hook.Validate(); err != nil {
+				return fmt.Errorf("invalid hooks.%s[%d]: %s", h.Name, i, err)
+			}
+		}
+	}
+	return nil
+}
+
+const (
+	hookBeforeGoto = "before_goto"
+	hookAfterGoto  = "after_goto"
+	hookBeforeEach = "before_each"
+	hookAfterEach  = "after_each"
+	hookOnFailure  = "on_failure"
+)
+
+// hookRunner executes the hooks of a migration run.
+type hookRunner struct {
+	ExecCtx ExecCtx
+	// Warnings collects the errors of the hooks with IgnoreError==true.
+	Warnings []string
+	// execCmd is template.ExecCmdContext. Tests can replace it.
+	execCmd func(ctx context.Context, command, dir string, env []string, inheritEnv bool) (string, error)
+}
+
+func newHookRunner(execCtx ExecCtx) *hookRunner {
+	return &hookRunner{
+		ExecCtx: execCtx,
+		execCmd: template.ExecCmdContext,
+	}
+}
+
+// Run executes the given hooks in order. Migration is the related
+// migration step or nil. It stops at the first hook that fails without
+// IgnoreError.
+func (o *hookRunner) Run(name string, hooks []*Hook, migration *MigrationStep, failure error) error {
+	env := []string{"MIGRATE_HOOK=" + name}
+	if migration != nil {
+		env = append(env,
+			"MIGRATE_MIGRATION="+migration.Name,
+			"MIGRATE_DIRECTION="+direction(migration.Forward),
+		)
+	}
+	if failure != nil {
+		env = append(env, "MIGRATE_ERROR="+failure.Error())
+	}
+
+	output := o.ExecCtx.Output
+	for i, hook := range hooks {
+		output.Print(fmt.Sprintf("%s hook #%d ... ", name, i+1))
+		stdout, err := o.run(name, hook, env)
+		if err != nil {
+			output.Println("FAILED")
+		} else {
+			output.Println("OK")
+		}
+		if stdout = strings.TrimRight(stdout, "\n"); stdout != "" {
+			output.Println(stdout)
+		}
+		if err != nil {
+			err = fmt.Errorf("%s hook #%d failed: %s", name, i+1, err)
+			if !hook.IgnoreError {
+				return err
+			}
+			o.Warnings = append(o.Warnings, err.Error())
+			output.Println("WARNING:", err)
+		}
+	}
+	return nil
+}
+
+// failureHookTimeout limits the execution time of the on_failure hooks that
+// don't have their own timeout.
+const failureHookTimeout = time.Minute
+
+func (o *hookRunner) run(name string, hook *Hook, env []string) (string, error) {
+	ctx := o.ExecCtx.Context
+	timeout := hook.Timeout
+	if name == hookOnFailure {
+		// The context of the run is already done if the failure is an
+		// interruption so the on_failure hooks get their own context.
+		ctx = context.Background()
+		if timeout == 0 {
+			timeout = failureHookTimeout
+		}
+	}
+	if timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if hook.Cmd != "" {
+		return o.execCmd(ctx, hook.Cmd, "", env, true)
+	}
+	execCtx := o.ExecCtx
+	execCtx.Context = ctx
+	return "", (&SQLExecStep{Query: hook.SQL}).Execute(execCtx)
+}
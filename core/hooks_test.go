@@ -0,0 +1,106 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestHook_Validate(t *testing.T) {
+	assert.NoError(t, (&Hook{SQL: "SELECT 1"}).Validate())
+	assert.NoError(t, (&Hook{Cmd: "echo"}).Validate())
+	assert.Error(t, (&Hook{}).Validate())
+	assert.Error(t, (&Hook{SQL: "SELECT 1", Cmd: "echo"}).Validate())
+	assert.Error(t, (&Hook{SQL: "SELECT 1", Timeout: -1}).Validate())
+}
+
+func TestHookRunner_Run(t *testing.T) {
+	var buf bytes.Buffer
+	runner := newHookRunner(ExecCtx{
+		Context: context.Background(),
+		Output:  NewPrinter(&buf),
+	})
+	var commands []string
+	var envs [][]string
+	runner.execCmd = func(ctx context.Context, command, dir string, env []string, inheritEnv bool) (string, error) {
+		assert.True(t, inheritEnv)
+		commands = append(commands, command)
+		envs = append(envs, env)
+		if command == "fail" {
+			return "", assert.AnError
+		}
+		return command + " output\n", nil
+	}
+
+	hooks := []*Hook{{Cmd: "a"}, {Cmd: "fail", IgnoreError: true}, {Cmd: "b"}}
+	migration := &MigrationStep{Name: "0001_a.sql", Forward: false}
+	err := runner.Run(hookOnFailure, hooks, migration, assert.AnError)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "fail", "b"}, commands)
+	assert.Equal(t, []string{
+		"MIGRATE_HOOK=on_failure",
+		"MIGRATE_MIGRATION=0001_a.sql",
+		"MIGRATE_DIRECTION=backward",
+		"MIGRATE_ERROR=" + assert.AnError.Error(),
+	}, envs[0])
+	assert.Equal(t, []string{"on_failure hook #2 failed: " + assert.AnError.Error()}, runner.Warnings)
+	assert.Equal(t, "on_failure hook #1 ... OK\na output\n"+
+		"on_failure hook #2 ... FAILED\nWARNING: on_failure hook #2 failed: "+assert.AnError.Error()+"\n"+
+		"on_failure hook #3 ... OK\nb output\n", buf.String())
+
+	err = runner.Run(hookBeforeGoto, []*Hook{{Cmd: "fail"}, {Cmd: "c"}}, nil, nil)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"MIGRATE_HOOK=before_goto"}, envs[len(envs)-1])
+	assert.NotContains(t, commands, "c")
+}
+
+func TestHookRunner_Run_OnFailureAfterCancel(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	db := NewMockDB(ctrl)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	runner := newHookRunner(ExecCtx{
+		Context: ctx,
+		DB:      db,
+		Output:  nullPrinter{},
+	})
+	runner.execCmd = func(ctx context.Context, command, dir string, env []string, inheritEnv bool) (string, error) {
+		return "", ctx.Err()
+	}
+
+	db.EXPECT().ExecContext(gomock.Any(), "SELECT 1").Do(func(ctx context.Context, query string, args ...interface{}) {
+		assert.NoError(t, ctx.Err())
+		_, ok := ctx.Deadline()
+		assert.True(t, ok)
+	})
+
+	err := runner.Run(hookOnFailure, []*Hook{{Cmd: "notify"}, {SQL: "SELECT 1"}}, nil, ErrInterrupted)
+	assert.NoError(t, err)
+	err = runner.Run(hookAfterGoto, []*Hook{{Cmd: "notify"}}, nil, nil)
+	assert.Equal(t, fmt.Sprintf("after_goto hook #1 failed: %s", context.Canceled), err.Error())
+}
+
+func TestHookRunner_Run_SQL(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	db := NewMockDB(ctrl)
+	runner := newHookRunner(ExecCtx{
+		Context: context.Background(),
+		DB:      db,
+		Output:  nullPrinter{},
+		Dialect: testDialect{},
+	})
+
+	gomock.InOrder(
+		db.EXPECT().ExecContext(context.Background(), "REFRESH MATERIALIZED VIEW a"),
+		db.EXPECT().ExecContext(context.Background(), "REFRESH MATERIALIZED VIEW b"),
+	)
+
+	err := runner.Run(hookAfterGoto, []*Hook{{SQL: "REFRESH MATERIALIZED VIEW a;\nREFRESH MATERIALIZED VIEW b;"}}, nil, nil)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,41 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const defaultLockTimeout = time.Minute
+
+// acquireLock calls MigrationDB.Lock and turns ErrLocked into an error
+// message that tells the user what to do about it.
+func acquireLock(ctx context.Context, db DB, mdb MigrationDB, timeout time.Duration) (unlock func() error, err error) {
+	unlock, err = mdb.Lock(ctx, db, timeout)
+	switch err {
+	case nil:
+		return unlock, nil
+	case ErrLocked:
+		return nil, fmt.Errorf("%s: couldn't acquire the migration lock within %v (see the lock_timeout config)", err, timeout)
+	default:
+		return nil, fmt.Errorf("error acquiring the migration lock: %s", err)
+	}
+}
+
+// upgradeTable upgrades the migrations table to the current schema.
+// It should be called only while holding the migration lock.
+func upgradeTable(ctx context.Context, db DB, mdb MigrationDB) error {
+	step, err := mdb.UpgradeTable()
+	if err != nil {
+		return err
+	}
+	err = step.Execute(ExecCtx{
+		Context: ctx,
+		DB:      db,
+		Output:  nullPrinter{},
+	})
+	if err != nil {
+		return fmt.Errorf("error upgrading the migrations table: %s", err)
+	}
+	return nil
+}
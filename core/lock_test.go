@@ -0,0 +1,51 @@
+package core
+
+import (
+	"context"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestAcquireLock(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		db := NewMockDB(ctrl)
+		mdb := NewMockMigrationDB(ctrl)
+
+		unlocked := false
+		mdb.EXPECT().Lock(context.Background(), db, time.Second).Return(func() error {
+			unlocked = true
+			return nil
+		}, nil)
+
+		unlock, err := acquireLock(context.Background(), db, mdb, time.Second)
+		assert.NoError(t, err)
+		assert.NoError(t, unlock())
+		assert.True(t, unlocked)
+		ctrl.Finish()
+	})
+	t.Run("Locked", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		db := NewMockDB(ctrl)
+		mdb := NewMockMigrationDB(ctrl)
+
+		mdb.EXPECT().Lock(context.Background(), db, time.Second).Return(nil, ErrLocked)
+
+		_, err := acquireLock(context.Background(), db, mdb, time.Second)
+		assert.EqualError(t, err, "another migration is in progress: couldn't acquire the migration lock within 1s (see the lock_timeout config)")
+		ctrl.Finish()
+	})
+	t.Run("Error", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		db := NewMockDB(ctrl)
+		mdb := NewMockMigrationDB(ctrl)
+
+		mdb.EXPECT().Lock(context.Background(), db, time.Second).Return(nil, assert.AnError)
+
+		_, err := acquireLock(context.Background(), db, mdb, time.Second)
+		assert.EqualError(t, err, "error acquiring the migration lock: "+assert.AnError.Error())
+		ctrl.Finish()
+	})
+}
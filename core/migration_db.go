@@ -1,7 +1,13 @@
 package core
 
 import (
+	"context"
+	"database/sql/driver"
 	"errors"
+	"fmt"
+	"github.com/pasztorpisti/migrate/sqlsplit"
+	"os"
+	"os/user"
 	"time"
 )
 
@@ -12,6 +18,33 @@ type DriverFactory interface {
 type Driver interface {
 	Open(dataSourceName string) (ClosableDB, error)
 	NewMigrationDB() (MigrationDB, error)
+	Dialect() Dialect
+}
+
+// Dialect contains the SQL dialect specific parts of a Driver.
+type Dialect interface {
+	// SplitStatements splits the SQL of a migration step into statements
+	// that are executed one by one.
+	SplitStatements(query string) ([]sqlsplit.Statement, error)
+	// ErrorPosition returns the 1-based line and column of the error
+	// in statement if err contains the position of the error.
+	// It returns zeros if the position isn't known. The column can be
+	// zero even if the line is known.
+	ErrorPosition(err error, statement string) (line, column int)
+}
+
+// SystemTransactionSeparator is an optional interface of MigrationDB.
+type SystemTransactionSeparator interface {
+	// SeparateSystemTransaction returns true if the SQL of a migration and
+	// the steps that update the migrations and history tables have to be
+	// executed in separate transactions. The DB can record the migration
+	// only after the transaction of the migration has been committed.
+	SeparateSystemTransaction() bool
+}
+
+func separateSystemTransaction(mdb MigrationDB) bool {
+	s, ok := mdb.(SystemTransactionSeparator)
+	return ok && s.SeparateSystemTransaction()
 }
 
 // ErrMigrationsTableAlreadyExists can be returned by the Step returned by
@@ -20,16 +53,134 @@ type Driver interface {
 // the table already exists if implementing the check isn't possible.
 var ErrMigrationsTableAlreadyExists = errors.New("the migrations table already exists")
 
+// ErrLocked is returned by MigrationDB.Lock when it can't acquire the
+// migration lock within the given timeout because another migrate
+// process is holding it.
+var ErrLocked = errors.New("another migration is in progress")
+
 type MigrationDB interface {
-	GetForwardMigrations(Querier) ([]*MigrationNameAndTime, error)
+	GetForwardMigrations(context.Context, Querier) ([]*MigrationNameAndTime, error)
 	CreateTable() (Step, error)
-	ForwardMigrate(migrationName string) (Step, error)
+	// UpgradeTable returns a step that upgrades a migrations table created
+	// by an older version of this tool to the current schema. The step has
+	// to be a no-op if the table is already up to date.
+	// CreateTable performs the upgrade too when the table already exists.
+	UpgradeTable() (Step, error)
+	// ForwardMigrate returns a step that records the migration as forward
+	// migrated. See MigrationRecord.
+	ForwardMigrate(record *MigrationRecord) (Step, error)
 	BackwardMigrate(migrationName string) (Step, error)
+
+	// RecordHistory returns a step that appends entry to the history table.
+	// CreateTable and UpgradeTable create the history table.
+	RecordHistory(entry *HistoryEntry) (Step, error)
+	// GetHistory returns the entries of the history table that match filter
+	// in chronological order. See HistoryFilter.SQLCondition.
+	GetHistory(ctx context.Context, q Querier, filter *HistoryFilter) ([]*HistoryEntry, error)
+
+	// StatementTimeout returns steps that set server-side timeouts
+	// (statement and lock wait timeouts) for the rest of the transaction
+	// they are executed in. The set step is executed at the beginning of the
+	// transaction of a migration and the reset step right after the
+	// migration's own step even if it fails. Both can be no-op steps if the DB doesn't
+	// support timeouts or if the set step affects only the transaction.
+	StatementTimeout(timeout time.Duration) (set, reset Step, err error)
+
+	// Lock acquires an exclusive DB-wide lock that prevents other migrate
+	// processes from reading and modifying the migrations table while we
+	// are working with it. It waits at most timeout for the lock and
+	// returns ErrLocked if the lock couldn't be acquired in time.
+	// A zero timeout means no waiting at all.
+	//
+	// The lock isn't re-entrant and it is held until the returned unlock
+	// function is called. Implementations can use the db parameter to
+	// start a dedicated transaction that pins a connection for the lifetime
+	// of the lock. It is valid to return a no-op unlock function if the DB
+	// doesn't support locking. Cancelling ctx aborts waiting for the lock but
+	// it doesn't release an already acquired lock.
+	Lock(ctx context.Context, db DB, timeout time.Duration) (unlock func() error, err error)
 }
 
 type MigrationNameAndTime struct {
 	Name string
 	Time time.Time
+	// Checksum is the checksum of the forward step at the time it was applied.
+	// Empty if the migration was applied by an older version of this tool
+	// that didn't record checksums.
+	Checksum string
+	// Duration and the fields of ApplyInfo are zero if the migration was
+	// applied by an older version of this tool that didn't record them.
+	Duration time.Duration
+	ApplyInfo
+}
+
+// ApplyInfo describes who applied a migration and where.
+type ApplyInfo struct {
+	// AppliedBy is the applied_by config value or the name of the OS user.
+	AppliedBy string
+	Hostname  string
+	// Version is the version of the migrate tool.
+	Version string
+}
+
+// MigrationRecord is the input of MigrationDB.ForwardMigrate.
+type MigrationRecord struct {
+	Name string
+	// Checksum is the checksum of the forward step.
+	// It can be empty if it isn't available.
+	Checksum string
+	// Duration is the execution time of the forward step. It is set after
+	// ForwardMigrate returns but before its step is executed so the step
+	// has to use DurationMillisArg instead of reading Duration directly.
+	Duration time.Duration
+	ApplyInfo
+}
+
+// DurationMillisArg returns a query argument that evaluates to Duration
+// in milliseconds at the time the query is executed.
+func (o *MigrationRecord) DurationMillisArg() driver.Valuer {
+	return durationMillisArg{&o.Duration}
+}
+
+type durationMillisArg struct {
+	duration *time.Duration
+}
+
+func (o durationMillisArg) Value() (driver.Value, error) {
+	return int64(*o.duration / time.Millisecond), nil
+}
+
+// Version is recorded in the migrations table as the version of
+// the migrate tool. The main package sets it.
+var Version = "dev"
+
+// newApplyInfo returns the ApplyInfo of the current process.
+// The name of the OS user is used if appliedBy is empty.
+func newApplyInfo(appliedBy string) ApplyInfo {
+	info := ApplyInfo{
+		AppliedBy: appliedBy,
+		Version:   Version,
+	}
+	if info.AppliedBy == "" {
+		if u, err := user.Current(); err == nil {
+			info.AppliedBy = u.Username
+		}
+	}
+	info.Hostname, _ = os.Hostname()
+	return info
+}
+
+// NewDriver creates a driver using the registered DriverFactory of name.
+func NewDriver(name string, params map[string]string) (Driver, error) {
+	driverFactory, ok := GetDriverFactory(name)
+	if !ok {
+		return nil, fmt.Errorf("invalid DB driver: %s", name)
+	}
+	driver, err := driverFactory.NewDriver(params)
+	if err != nil {
+		return nil, fmt.Errorf("error creating %q DB driver: %s", name, err)
+	}
+	return driver, nil
 }
 
 func GetDriverFactory(name string) (d DriverFactory, ok bool) {
@@ -4,6 +4,7 @@ import (
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestRegisterDriverFactory(t *testing.T) {
@@ -69,3 +70,14 @@ func TestGetDriverFactory(t *testing.T) {
 		assert.False(t, ok)
 	})
 }
+
+func TestMigrationRecord_DurationMillisArg(t *testing.T) {
+	record := &MigrationRecord{}
+	arg := record.DurationMillisArg()
+
+	// The argument has to reflect the changes made after its creation.
+	record.Duration = 1500 * time.Millisecond
+	v, err := arg.Value()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1500), v)
+}
@@ -8,6 +8,42 @@ type MigrationSource interface {
 	MigrationEntries() (MigrationEntries, error)
 }
 
+// MigrationSourceValidator is an optional interface of MigrationSource.
+// Validate checks the migrations without stopping at the first problem
+// and it doesn't need a DB connection.
+type MigrationSourceValidator interface {
+	Validate() []*ValidationProblem
+}
+
+// ConditionalMigrationSource is an optional interface of MigrationSource.
+// SetConditions sets the values that the conditional blocks of the
+// migrations are evaluated against (e.g.: "-- +migrate if db=dev,test").
+// The commands set the name of the config section as the "db" value along
+// with the tags of the config section. It has to be called before
+// MigrationEntries or Validate.
+type ConditionalMigrationSource interface {
+	SetConditions(conditions map[string]string)
+}
+
+// VariableMigrationSource is an optional interface of MigrationSource.
+// SetVars sets the values of the {var:name} template parameters of the
+// migrations. The commands set the vars of the config section. It has to
+// be called before MigrationEntries or Validate.
+type VariableMigrationSource interface {
+	SetVars(vars map[string]string)
+}
+
+// dbCondition is the name of the condition value that contains the name
+// of the config section.
+const dbCondition = "db"
+
+type ValidationProblem struct {
+	// Warning is true if the problem doesn't prevent the migrations from
+	// being loaded and applied.
+	Warning bool
+	Message string
+}
+
 type MigrationEntries interface {
 	NumMigrations() int
 	Name(index int) string
@@ -16,6 +52,43 @@ type MigrationEntries interface {
 	New(args []string) (name string, err error)
 }
 
+// RepeatableMigrations is an optional interface of MigrationEntries.
+// Repeatable migrations have only a forward step and they aren't part of the
+// ordered list of migrations: IndexForName doesn't find them. Plan schedules
+// them after the versioned migrations when the target is the latest
+// migration and their checksum differs from the one recorded in the
+// migrations table. They are never backward migrated.
+type RepeatableMigrations interface {
+	NumRepeatableMigrations() int
+	RepeatableName(index int) string
+	RepeatableStep(index int) (Step, error)
+	// IsRepeatableName returns true if name can belong to a repeatable
+	// migration even if its file has been deleted. The migrations table
+	// keeps the names of the deleted repeatable migrations.
+	IsRepeatableName(name string) bool
+}
+
+// repeatableNames returns the names of the repeatable migrations.
+func repeatableNames(migrations MigrationEntries) map[string]struct{} {
+	rm, ok := migrations.(RepeatableMigrations)
+	if !ok {
+		return nil
+	}
+	n := rm.NumRepeatableMigrations()
+	names := make(map[string]struct{}, n)
+	for i := 0; i < n; i++ {
+		names[rm.RepeatableName(i)] = struct{}{}
+	}
+	return names
+}
+
+// isOrphanRepeatable returns true if name is the name of a repeatable
+// migration that has been deleted from the migration source.
+func isOrphanRepeatable(migrations MigrationEntries, name string) bool {
+	rm, ok := migrations.(RepeatableMigrations)
+	return ok && rm.IsRepeatableName(name)
+}
+
 var GetMigrationSourceFactory = sourceRegistry.GetMigrationSourceFactory
 var RegisterMigrationSourceFactory = sourceRegistry.RegisterMigrationSourceFactory
 
@@ -0,0 +1,576 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Migrator is the library API of the status, plan, goto and hack commands.
+// It works with a DB opened by the caller and its methods return their
+// results instead of printing them. The Cmd* functions are built on it.
+// A Migrator isn't goroutine safe.
+type Migrator struct {
+	db         DB
+	mdb        MigrationDB
+	dialect    Dialect
+	migrations MigrationEntries
+	options    MigratorOptions
+}
+
+// MigratorOptions contains the settings that the CLI reads from the config
+// file. The zero value is valid.
+type MigratorOptions struct {
+	// AllowMigrationGaps allows Plan and Goto to work when an applied
+	// migration is newer than an unapplied one.
+	AllowMigrationGaps bool
+	// FailOnChecksumMismatch turns the checksum mismatch warnings of Plan
+	// and Goto into errors.
+	FailOnChecksumMismatch bool
+	// LockTimeout is the maximum time Goto and Hack wait for the migration
+	// lock. Zero means no waiting.
+	LockTimeout time.Duration
+	// StatementTimeout is the default execution timeout of the migrations.
+	// Zero means no timeout.
+	StatementTimeout time.Duration
+	// AppliedBy is recorded in the migrations table. The name of the OS
+	// user is recorded if it is empty.
+	AppliedBy string
+	// Output is optional. Goto prints the warnings and the progress of the
+	// migrations to it.
+	Output Printer
+	// Quiet omits the progress of the migrations from Output.
+	Quiet bool
+	// Hooks are executed by Goto and Redo.
+	Hooks Hooks
+}
+
+// NewMigrator creates a Migrator. Use WrapDB to turn a *sql.DB into a DB.
+// The driver has to match the DB. Its Open method isn't used. Options
+// is optional. The migrations are loaded from source only once.
+func NewMigrator(db DB, driver Driver, source MigrationSource, options *MigratorOptions) (*Migrator, error) {
+	mdb, err := driver.NewMigrationDB()
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := source.MigrationEntries()
+	if err != nil {
+		return nil, fmt.Errorf("error loading migrations: %s", err)
+	}
+	m := &Migrator{
+		db:         db,
+		mdb:        mdb,
+		dialect:    driver.Dialect(),
+		migrations: migrations,
+	}
+	if options != nil {
+		m.options = *options
+	}
+	if m.options.Output == nil {
+		m.options.Output = nullPrinter{}
+	}
+	return m, nil
+}
+
+// Status returns the state of the migrations.
+func (o *Migrator) Status(ctx context.Context) (*StatusReport, error) {
+	forwardMigrations, err := o.mdb.GetForwardMigrations(ctx, o.db)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &StatusReport{
+		Migrations:   make([]*MigrationStatus, 0, o.migrations.NumMigrations()),
+		InvalidNames: []string{},
+	}
+
+	repeatables := repeatableNames(o.migrations)
+	forwardMap := make(map[string]*MigrationNameAndTime, len(forwardMigrations))
+	for _, m := range forwardMigrations {
+		_, ok := o.migrations.IndexForName(m.Name)
+		if _, repeatable := repeatables[m.Name]; repeatable {
+			ok = true
+		}
+		if !ok {
+			report.InvalidNames = append(report.InvalidNames, m.Name)
+		} else {
+			forwardMap[m.Name] = m
+		}
+	}
+
+	mismatches, err := checksumMismatches(o.migrations, forwardMigrations)
+	if err != nil {
+		return nil, err
+	}
+	modified := make(map[string]struct{}, len(mismatches))
+	for _, name := range mismatches {
+		modified[name] = struct{}{}
+	}
+
+	newStatus := func(name string) *MigrationStatus {
+		ms := &MigrationStatus{
+			Name: name,
+		}
+		if m, ok := forwardMap[name]; ok {
+			t := m.Time
+			ms.Applied = true
+			ms.AppliedAt = &t
+			ms.DurationMillis = int64(m.Duration / time.Millisecond)
+			ms.AppliedBy = m.AppliedBy
+			ms.Hostname = m.Hostname
+			ms.Version = m.Version
+		}
+		_, ms.Modified = modified[name]
+		return ms
+	}
+
+	numMigrations := o.migrations.NumMigrations()
+	for i := 0; i < numMigrations; i++ {
+		report.Migrations = append(report.Migrations, newStatus(o.migrations.Name(i)))
+	}
+
+	if rm, ok := o.migrations.(RepeatableMigrations); ok {
+		for i, n := 0, rm.NumRepeatableMigrations(); i < n; i++ {
+			name := rm.RepeatableName(i)
+			ms := newStatus(name)
+			ms.Repeatable = true
+			if m, ok := forwardMap[name]; ok {
+				step, err := rm.RepeatableStep(i)
+				if err != nil {
+					return nil, fmt.Errorf("error loading repeatable migration %q: %s", name, err)
+				}
+				ms.Modified = m.Checksum != StepChecksum(step)
+			}
+			report.Migrations = append(report.Migrations, ms)
+		}
+	}
+	return report, nil
+}
+
+// MigrationPlan is returned by Migrator.Plan and Migrator.PlanRedo.
+type MigrationPlan struct {
+	// Steps contains *MigrationStep items.
+	Steps Steps
+	// Warnings contains the checksum mismatches found in the migrations
+	// table unless FailOnChecksumMismatch is set and the repeatable
+	// migrations whose file has been deleted.
+	Warnings []string
+}
+
+func (o *MigrationPlan) printNotes(output Printer) {
+	for _, w := range o.Warnings {
+		output.Println("WARNING:", w)
+	}
+	if len(o.Steps) == 0 {
+		output.Println("Nothing to migrate.")
+	}
+}
+
+// Plan returns the steps that Goto would execute. See PlanInput.Target
+// for the accepted target values.
+func (o *Migrator) Plan(ctx context.Context, target string) (*MigrationPlan, error) {
+	return o.plan(ctx, target, 0)
+}
+
+// PlanRedo returns the steps that Redo would execute.
+func (o *Migrator) PlanRedo(ctx context.Context, n int) (*MigrationPlan, error) {
+	return o.plan(ctx, "", n)
+}
+
+// Goto migrates the DB to target while holding the migration lock.
+// Cancelling ctx stops the migration: the step being executed is rolled
+// back (if it runs in a transaction) and the rest of the steps are skipped.
+// The returned report is nil if the plan couldn't be created.
+func (o *Migrator) Goto(ctx context.Context, target string) (*PlanReport, error) {
+	return o.execute(ctx, target, 0)
+}
+
+// Redo backward migrates the newest n applied migrations and then
+// forward migrates them again. See Goto.
+func (o *Migrator) Redo(ctx context.Context, n int) (*PlanReport, error) {
+	return o.execute(ctx, "", n)
+}
+
+func (o *Migrator) plan(ctx context.Context, target string, redo int) (*MigrationPlan, error) {
+	forwardMigrations, err := o.mdb.GetForwardMigrations(ctx, o.db)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := o.migrations
+	repeatables := repeatableNames(migrations)
+	appliedChecksums := make(map[string]string)
+	forwardMigrated := make([]bool, migrations.NumMigrations())
+	var orphanWarnings []string
+	for _, m := range forwardMigrations {
+		if _, ok := repeatables[m.Name]; ok {
+			appliedChecksums[m.Name] = m.Checksum
+			continue
+		}
+		index, ok := migrations.IndexForName(m.Name)
+		// We don't accept aliases as forward migrated names.
+		// This is why we check for (m.Name != migrations.Name(index)).
+		if !ok || m.Name != migrations.Name(index) {
+			if isOrphanRepeatable(migrations, m.Name) {
+				orphanWarnings = append(orphanWarnings, fmt.Sprintf("the file of the repeatable migration %q "+
+					"has been deleted (you can delete it from the migrations table)", m.Name))
+				continue
+			}
+			return nil, fmt.Errorf("can't find migration file for forward migrated item %q", m.Name)
+		}
+		forwardMigrated[index] = true
+	}
+
+	p := &MigrationPlan{}
+	p.Warnings, err = checkChecksums(migrations, forwardMigrations, o.options.FailOnChecksumMismatch)
+	if err != nil {
+		return nil, err
+	}
+	p.Warnings = append(orphanWarnings, p.Warnings...)
+
+	if !o.options.AllowMigrationGaps {
+		allowForwardMigrated := true
+		for _, fm := range forwardMigrated {
+			if fm {
+				if !allowForwardMigrated {
+					return nil, errMigrationGap
+				}
+			} else {
+				allowForwardMigrated = false
+			}
+		}
+	}
+
+	planInput := &PlanInput{
+		Migrations:       migrations,
+		ForwardMigrated:  forwardMigrated,
+		Target:           target,
+		MigrationDB:      o.mdb,
+		Timeout:          o.options.StatementTimeout,
+		ApplyInfo:        newApplyInfo(o.options.AppliedBy),
+		AppliedChecksums: appliedChecksums,
+	}
+	if redo != 0 {
+		planInput.Command = HistoryRedo
+		p.Steps, err = PlanRedo(planInput, redo)
+	} else {
+		p.Steps, err = Plan(planInput)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (o *Migrator) execute(ctx context.Context, target string, redo int) (_ *PlanReport, retErr error) {
+	unlock, err := acquireLock(ctx, o.db, o.mdb, o.options.LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := unlock(); err != nil && retErr == nil {
+			retErr = fmt.Errorf("error releasing the migration lock: %s", err)
+		}
+	}()
+
+	if err := upgradeTable(ctx, o.db, o.mdb); err != nil {
+		return nil, err
+	}
+
+	p, err := o.plan(ctx, target, redo)
+	if err != nil {
+		return nil, err
+	}
+
+	output := o.options.Output
+	p.printNotes(output)
+
+	execCtx := ExecCtx{
+		Context: ctx,
+		DB:      o.db,
+		Output:  output,
+		Dialect: o.dialect,
+	}
+	if o.options.Quiet {
+		execCtx.Output = nullPrinter{}
+	}
+
+	hooks := &o.options.Hooks
+	runner := newHookRunner(execCtx)
+	report := newPlanReport(p)
+	if len(p.Steps) != 0 {
+		err = runner.Run(hookBeforeGoto, hooks.BeforeGoto, nil, nil)
+	}
+	var failedMigration *MigrationStep
+	for _, step := range p.Steps {
+		if err != nil {
+			report.Steps = append(report.Steps, newStepReport(step, StepSkipped, nil))
+			continue
+		}
+		ms, _ := step.(*MigrationStep)
+		if ms != nil {
+			err = runner.Run(hookBeforeEach, hooks.BeforeEach, ms, nil)
+			if err != nil {
+				failedMigration = ms
+				report.Steps = append(report.Steps, newStepReport(step, StepFailed, err))
+				continue
+			}
+		}
+		err = executeWithTimeout(execCtx, step)
+		if err != nil {
+			failedMigration = ms
+			report.Steps = append(report.Steps, newStepReport(step, StepFailed, err))
+			if ms != nil && ms.History != nil {
+				if err := recordFailure(o.db, o.mdb, ms.History, err); err != nil {
+					report.Warnings = append(report.Warnings, err.Error())
+					output.Println("WARNING:", err)
+				}
+			}
+			continue
+		}
+		report.Steps = append(report.Steps, newStepReport(step, StepOK, nil))
+		if ms != nil {
+			err = runner.Run(hookAfterEach, hooks.AfterEach, ms, nil)
+			if err != nil {
+				failedMigration = ms
+			}
+		}
+	}
+	if err == nil && len(p.Steps) != 0 {
+		err = runner.Run(hookAfterGoto, hooks.AfterGoto, nil, nil)
+	}
+	if err != nil {
+		// The run has already failed so the errors of these hooks are
+		// only warnings.
+		if hookErr := runner.Run(hookOnFailure, hooks.OnFailure, failedMigration, err); hookErr != nil {
+			runner.Warnings = append(runner.Warnings, hookErr.Error())
+			output.Println("WARNING:", hookErr)
+		}
+	}
+	report.Warnings = append(report.Warnings, runner.Warnings...)
+
+	if err != nil && ctx.Err() != nil {
+		report.Interrupted = true
+		err = fmt.Errorf("%s: %w", ErrInterrupted, err)
+	}
+	return report, err
+}
+
+// HackInput is the input of Migrator.Hack.
+type HackInput struct {
+	Forward     bool
+	MigrationID string
+	// Force ignores the state of the migration in the migrations table.
+	Force bool
+	// UserOnly executes only the migration without updating the
+	// migrations table. SystemOnly does the opposite.
+	UserOnly   bool
+	SystemOnly bool
+}
+
+// HackResult is returned by Migrator.Hack.
+type HackResult struct {
+	// Migration is the full name of the hacked migration.
+	Migration string
+	// NothingToDo is true if Force is false and the migrations table says
+	// that the migration has already been migrated in the given direction.
+	// Nothing is executed in this case.
+	NothingToDo bool
+}
+
+// Hack forward or backward migrates a single migration regardless of the
+// state of the other migrations. Cancelling ctx rolls back the step being
+// executed if it runs in a transaction.
+func (o *Migrator) Hack(ctx context.Context, input *HackInput) (_ *HackResult, retErr error) {
+	if input.UserOnly && input.SystemOnly {
+		return nil, errors.New("the UserOnly and SystemOnly parameters are exclusive")
+	}
+
+	if input.MigrationID == Initial || input.MigrationID == Latest {
+		return nil, fmt.Errorf("hack doesn't accept %q or %q as the migration ID", Initial, Latest)
+	}
+
+	unlock, err := acquireLock(ctx, o.db, o.mdb, o.options.LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := unlock(); err != nil && retErr == nil {
+			retErr = fmt.Errorf("error releasing the migration lock: %s", err)
+		}
+	}()
+
+	if err := upgradeTable(ctx, o.db, o.mdb); err != nil {
+		return nil, err
+	}
+
+	forwardMigrations, err := o.mdb.GetForwardMigrations(ctx, o.db)
+	if err != nil {
+		return nil, err
+	}
+	forwardMap := make(map[string]struct{}, len(forwardMigrations))
+	for _, m := range forwardMigrations {
+		forwardMap[m.Name] = struct{}{}
+	}
+
+	// Preparing for the worst: when some of the IDs exist only in
+	// the migrations table but not in migration files and vice versa.
+
+	migrations := o.migrations
+	index, hasMigration := migrations.IndexForName(input.MigrationID)
+
+	name := input.MigrationID
+	if hasMigration {
+		name = migrations.Name(index)
+	}
+	_, hasForwardID := forwardMap[name]
+
+	if !hasMigration && !hasForwardID {
+		return nil, errors.New("invalid migration ID")
+	}
+
+	result := &HackResult{Migration: name}
+	if !input.Force && input.Forward == hasForwardID {
+		result.NothingToDo = true
+		return result, nil
+	}
+
+	// Dealing with user tables.
+
+	var userStep Step
+	if !input.SystemOnly {
+		if !hasMigration {
+			return nil, fmt.Errorf("there is no migrations file for %q", name)
+		}
+		forward, backward, err := migrations.Steps(index)
+		if err != nil {
+			return nil, fmt.Errorf("error loading migration file %q: %s", name, err)
+		}
+
+		if input.Forward {
+			userStep = forward
+		} else {
+			userStep = backward
+			if userStep == nil {
+				return nil, fmt.Errorf("migration %q doesn't have backward step", name)
+			}
+		}
+	}
+
+	// Dealing with the migrations table.
+
+	var systemStep Step
+	var history *HistoryEntry
+	if !input.UserOnly {
+		history = &HistoryEntry{
+			Time:      time.Now().UTC(),
+			Migration: name,
+			Direction: direction(input.Forward),
+			Command:   HistoryHack,
+			Result:    HistoryOK,
+			ApplyInfo: newApplyInfo(o.options.AppliedBy),
+		}
+		durations := []*time.Duration{&history.Duration}
+
+		if input.Forward {
+			if hasMigration {
+				forward, _, err := migrations.Steps(index)
+				if err != nil {
+					return nil, fmt.Errorf("error loading migration file %q: %s", name, err)
+				}
+				history.Checksum = StepChecksum(forward)
+			}
+			record := &MigrationRecord{
+				Name:      name,
+				Checksum:  history.Checksum,
+				ApplyInfo: history.ApplyInfo,
+			}
+			systemStep, err = o.mdb.ForwardMigrate(record)
+			durations = append(durations, &record.Duration)
+		} else {
+			systemStep, err = o.mdb.BackwardMigrate(name)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		historyStep, err := o.mdb.RecordHistory(history)
+		if err != nil {
+			return nil, err
+		}
+		systemStep = Steps{systemStep, historyStep}
+		if userStep != nil {
+			userStep = &timedStep{Step: userStep, Durations: durations}
+		}
+	}
+
+	var step Step
+	switch {
+	case userStep == nil:
+		step = TransactionIfAllowed{Steps{systemStep}}
+	case systemStep == nil:
+		step = userStep
+	default:
+		step = migrationTransaction(o.mdb, userStep, systemStep)
+	}
+
+	err = step.Execute(ExecCtx{
+		Context: ctx,
+		DB:      o.db,
+		Output:  o.options.Output,
+		Dialect: o.dialect,
+	})
+	if err != nil {
+		err = wrapExecError(name, input.Forward, err)
+		if history != nil {
+			if err := recordFailure(o.db, o.mdb, history, err); err != nil {
+				o.options.Output.Println("WARNING:", err)
+			}
+		}
+		if ctx.Err() != nil {
+			err = fmt.Errorf("%s: %w", ErrInterrupted, err)
+		}
+		return nil, err
+	}
+	return result, nil
+}
+
+// loadedMigrationSource is a MigrationSource of already loaded migrations.
+type loadedMigrationSource struct {
+	migrations MigrationEntries
+}
+
+func (o loadedMigrationSource) MigrationEntries() (MigrationEntries, error) {
+	return o.migrations, nil
+}
+
+// openMigrator opens the DB of a validated single-DB config and creates
+// a Migrator for it. The caller has to close the returned DB.
+func openMigrator(cfg *dbConfig, migrations MigrationEntries, options *MigratorOptions) (*Migrator, ClosableDB, error) {
+	driver, err := NewDriver(cfg.Driver, cfg.DriverParams)
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := driver.Open(cfg.DataSource)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	opts := *options
+	opts.AllowMigrationGaps = cfg.AllowMigrationGaps
+	opts.FailOnChecksumMismatch = cfg.FailOnChecksumMismatch
+	opts.LockTimeout = cfg.LockTimeout
+	if opts.StatementTimeout == 0 {
+		opts.StatementTimeout = cfg.StatementTimeout
+	}
+	opts.AppliedBy = cfg.AppliedBy
+	opts.Hooks = cfg.Hooks
+
+	m, err := NewMigrator(db, driver, loadedMigrationSource{migrations}, &opts)
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return m, db, nil
+}
@@ -0,0 +1,272 @@
+package core
+
+import (
+	"context"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func newTestMigrator(t *testing.T, ctrl *gomock.Controller, migrations MigrationEntries) (*Migrator, *MockDB, *MockMigrationDB) {
+	db := NewMockDB(ctrl)
+	mdb := NewMockMigrationDB(ctrl)
+	driver := NewMockDriver(ctrl)
+	driver.EXPECT().NewMigrationDB().Return(mdb, nil)
+	driver.EXPECT().Dialect().Return(nil)
+
+	m, err := NewMigrator(db, driver, loadedMigrationSource{migrations}, &MigratorOptions{LockTimeout: time.Second})
+	require.NoError(t, err)
+	return m, db, mdb
+}
+
+func TestMigrator_Status(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	migrations := fakeMigrationEntries{
+		{Query: "query a"},
+		{Query: "query b"},
+	}
+	m, db, mdb := newTestMigrator(t, ctrl, migrations)
+
+	appliedAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	mdb.EXPECT().GetForwardMigrations(context.Background(), db).Return([]*MigrationNameAndTime{
+		{Name: "a", Time: appliedAt, Checksum: checksumSQL("modified a"), ApplyInfo: ApplyInfo{AppliedBy: "ci"}},
+		{Name: "x", Time: appliedAt},
+	}, nil)
+
+	report, err := m.Status(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &StatusReport{
+		Migrations: []*MigrationStatus{
+			{Name: "a", Applied: true, AppliedAt: &appliedAt, Modified: true, AppliedBy: "ci"},
+			{Name: "b"},
+		},
+		InvalidNames: []string{"x"},
+	}, report)
+}
+
+func TestMigrator_Goto(t *testing.T) {
+	migrations := fakeMigrationEntries{
+		{Query: "query a", NoTransaction: true},
+		{Query: "query b", NoTransaction: true},
+		{Query: "query c", NoTransaction: true},
+	}
+
+	t.Run("success", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		m, db, mdb := newTestMigrator(t, ctrl, migrations)
+		unlocked := false
+
+		mdb.EXPECT().Lock(context.Background(), db, time.Second).Return(func() error {
+			unlocked = true
+			return nil
+		}, nil)
+		mdb.EXPECT().UpgradeTable().Return(Steps{}, nil)
+		mdb.EXPECT().GetForwardMigrations(context.Background(), db).Return([]*MigrationNameAndTime{
+			{Name: "a", Checksum: checksumSQL("query a")},
+		}, nil)
+		mdb.EXPECT().ForwardMigrate(gomock.Any()).Return(Steps{}, nil)
+		mdb.EXPECT().RecordHistory(gomock.Any()).Return(Steps{}, nil)
+		db.EXPECT().ExecContext(gomock.Any(), "query b")
+
+		report, err := m.Goto(context.Background(), "b")
+		require.NoError(t, err)
+		assert.True(t, unlocked)
+		assert.Equal(t, &PlanReport{
+			Steps: []*StepReport{
+				{Migration: "b", Direction: "forward", Result: StepOK},
+			},
+			Warnings: []string{},
+		}, report)
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		m, db, mdb := newTestMigrator(t, ctrl, migrations)
+
+		mdb.EXPECT().Lock(context.Background(), db, time.Second).Return(func() error { return nil }, nil)
+		mdb.EXPECT().UpgradeTable().Return(Steps{}, nil)
+		mdb.EXPECT().GetForwardMigrations(context.Background(), db).Return(nil, nil)
+		mdb.EXPECT().ForwardMigrate(gomock.Any()).Return(Steps{}, nil).Times(3)
+		// The last call records the failure.
+		mdb.EXPECT().RecordHistory(gomock.Any()).Return(Steps{}, nil).Times(4)
+		gomock.InOrder(
+			db.EXPECT().ExecContext(gomock.Any(), "query a"),
+			db.EXPECT().ExecContext(gomock.Any(), "query b").Return(nil, assert.AnError),
+		)
+
+		report, err := m.Goto(context.Background(), Latest)
+		assert.Error(t, err)
+		require.NotNil(t, report)
+		require.Len(t, report.Steps, 3)
+		assert.Equal(t, StepOK, report.Steps[0].Result)
+		assert.Equal(t, StepFailed, report.Steps[1].Result)
+		assert.Equal(t, StepSkipped, report.Steps[2].Result)
+	})
+
+	t.Run("lock error", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		m, db, mdb := newTestMigrator(t, ctrl, migrations)
+
+		mdb.EXPECT().Lock(context.Background(), db, time.Second).Return(nil, ErrLocked)
+
+		report, err := m.Goto(context.Background(), Latest)
+		assert.Error(t, err)
+		assert.Nil(t, report)
+	})
+}
+
+func TestMigrator_Plan_OrphanRepeatable(t *testing.T) {
+	migrations := repeatableMigrationEntries{fakeMigrationEntries{
+		{Query: "query a"},
+	}}
+	applied := []*MigrationNameAndTime{
+		{Name: "a", Checksum: checksumSQL("query a")},
+		{Name: "r1", Checksum: checksumSQL("query r1")},
+		{Name: "r2", Checksum: checksumSQL("query r2")},
+	}
+
+	t.Run("deleted repeatable", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		m, db, mdb := newTestMigrator(t, ctrl, migrations)
+		mdb.EXPECT().GetForwardMigrations(context.Background(), db).Return(
+			append(applied, &MigrationNameAndTime{Name: "r3"}), nil)
+
+		p, err := m.Plan(context.Background(), Latest)
+		require.NoError(t, err)
+		assert.Empty(t, p.Steps)
+		require.Len(t, p.Warnings, 1)
+		assert.Contains(t, p.Warnings[0], `"r3"`)
+	})
+
+	t.Run("unknown name", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		m, db, mdb := newTestMigrator(t, ctrl, migrations)
+		mdb.EXPECT().GetForwardMigrations(context.Background(), db).Return(
+			append(applied, &MigrationNameAndTime{Name: "x"}), nil)
+
+		_, err := m.Plan(context.Background(), Latest)
+		assert.Error(t, err)
+	})
+}
+
+func TestMigrator_Hack(t *testing.T) {
+	migrations := fakeMigrationEntries{
+		{Query: "query a"},
+		{Query: "query b"},
+	}
+
+	t.Run("nothing to do", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		m, db, mdb := newTestMigrator(t, ctrl, migrations)
+
+		mdb.EXPECT().Lock(context.Background(), db, time.Second).Return(func() error { return nil }, nil)
+		mdb.EXPECT().UpgradeTable().Return(Steps{}, nil)
+		mdb.EXPECT().GetForwardMigrations(context.Background(), db).Return([]*MigrationNameAndTime{{Name: "a"}}, nil)
+
+		result, err := m.Hack(context.Background(), &HackInput{Forward: true, MigrationID: "a"})
+		require.NoError(t, err)
+		assert.Equal(t, &HackResult{Migration: "a", NothingToDo: true}, result)
+	})
+
+	t.Run("invalid input", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		m, _, _ := newTestMigrator(t, ctrl, migrations)
+
+		_, err := m.Hack(context.Background(), &HackInput{MigrationID: "a", UserOnly: true, SystemOnly: true})
+		assert.Error(t, err)
+		_, err = m.Hack(context.Background(), &HackInput{MigrationID: Latest})
+		assert.Error(t, err)
+	})
+}
+
+func TestMigrator_Goto_Hooks(t *testing.T) {
+	migrations := fakeMigrationEntries{
+		{Query: "query a", NoTransaction: true},
+		{Query: "query b", NoTransaction: true},
+	}
+	hooks := Hooks{
+		BeforeGoto: []*Hook{{SQL: "before goto"}},
+		AfterGoto:  []*Hook{{SQL: "after goto"}},
+		BeforeEach: []*Hook{{SQL: "before each"}},
+		AfterEach:  []*Hook{{SQL: "after each", IgnoreError: true}},
+		OnFailure:  []*Hook{{SQL: "on failure"}},
+	}
+
+	t.Run("success", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		m, db, mdb := newTestMigrator(t, ctrl, migrations)
+		m.options.Hooks = hooks
+
+		mdb.EXPECT().Lock(context.Background(), db, time.Second).Return(func() error { return nil }, nil)
+		mdb.EXPECT().UpgradeTable().Return(Steps{}, nil)
+		mdb.EXPECT().GetForwardMigrations(context.Background(), db).Return(nil, nil)
+		mdb.EXPECT().ForwardMigrate(gomock.Any()).Return(Steps{}, nil).Times(2)
+		mdb.EXPECT().RecordHistory(gomock.Any()).Return(Steps{}, nil).Times(2)
+		gomock.InOrder(
+			db.EXPECT().ExecContext(gomock.Any(), "before goto"),
+			db.EXPECT().ExecContext(gomock.Any(), "before each"),
+			db.EXPECT().ExecContext(gomock.Any(), "query a"),
+			db.EXPECT().ExecContext(gomock.Any(), "after each").Return(nil, assert.AnError),
+			db.EXPECT().ExecContext(gomock.Any(), "before each"),
+			db.EXPECT().ExecContext(gomock.Any(), "query b"),
+			db.EXPECT().ExecContext(gomock.Any(), "after each"),
+			db.EXPECT().ExecContext(gomock.Any(), "after goto"),
+		)
+
+		report, err := m.Goto(context.Background(), Latest)
+		require.NoError(t, err)
+		require.Len(t, report.Steps, 2)
+		assert.Equal(t, StepOK, report.Steps[1].Result)
+		assert.Equal(t, []string{"after_each hook #1 failed: " + assert.AnError.Error()}, report.Warnings)
+	})
+
+	t.Run("before_each failure", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		m, db, mdb := newTestMigrator(t, ctrl, migrations)
+		m.options.Hooks = hooks
+
+		mdb.EXPECT().Lock(context.Background(), db, time.Second).Return(func() error { return nil }, nil)
+		mdb.EXPECT().UpgradeTable().Return(Steps{}, nil)
+		mdb.EXPECT().GetForwardMigrations(context.Background(), db).Return(nil, nil)
+		mdb.EXPECT().ForwardMigrate(gomock.Any()).Return(Steps{}, nil).Times(2)
+		mdb.EXPECT().RecordHistory(gomock.Any()).Return(Steps{}, nil).Times(2)
+		gomock.InOrder(
+			db.EXPECT().ExecContext(gomock.Any(), "before goto"),
+			db.EXPECT().ExecContext(gomock.Any(), "before each").Return(nil, assert.AnError),
+			db.EXPECT().ExecContext(gomock.Any(), "on failure"),
+		)
+
+		report, err := m.Goto(context.Background(), Latest)
+		assert.Error(t, err)
+		require.Len(t, report.Steps, 2)
+		assert.Equal(t, StepFailed, report.Steps[0].Result)
+		assert.Equal(t, StepSkipped, report.Steps[1].Result)
+	})
+
+	t.Run("nothing to do", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		m, db, mdb := newTestMigrator(t, ctrl, migrations)
+		m.options.Hooks = hooks
+
+		mdb.EXPECT().Lock(context.Background(), db, time.Second).Return(func() error { return nil }, nil)
+		mdb.EXPECT().UpgradeTable().Return(Steps{}, nil)
+		mdb.EXPECT().GetForwardMigrations(context.Background(), db).Return(nil, nil)
+
+		report, err := m.Goto(context.Background(), Initial)
+		require.NoError(t, err)
+		assert.Empty(t, report.Steps)
+	})
+}
@@ -5,6 +5,7 @@
 package core
 
 import (
+	context "context"
 	sql "database/sql"
 	gomock "github.com/golang/mock/gomock"
 	reflect "reflect"
@@ -33,22 +34,22 @@ func (m *MockQuerier) EXPECT() *MockQuerierMockRecorder {
 	return m.recorder
 }
 
-// Query mocks base method
-func (m *MockQuerier) Query(query string, args ...interface{}) (*sql.Rows, error) {
-	varargs := []interface{}{query}
+// QueryContext mocks base method
+func (m *MockQuerier) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	varargs := []interface{}{ctx, query}
 	for _, a := range args {
 		varargs = append(varargs, a)
 	}
-	ret := m.ctrl.Call(m, "Query", varargs...)
+	ret := m.ctrl.Call(m, "QueryContext", varargs...)
 	ret0, _ := ret[0].(*sql.Rows)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// Query indicates an expected call of Query
-func (mr *MockQuerierMockRecorder) Query(query interface{}, args ...interface{}) *gomock.Call {
-	varargs := append([]interface{}{query}, args...)
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Query", reflect.TypeOf((*MockQuerier)(nil).Query), varargs...)
+// QueryContext indicates an expected call of QueryContext
+func (mr *MockQuerierMockRecorder) QueryContext(ctx, query interface{}, args ...interface{}) *gomock.Call {
+	varargs := append([]interface{}{ctx, query}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryContext", reflect.TypeOf((*MockQuerier)(nil).QueryContext), varargs...)
 }
 
 // MockExecer is a mock of Execer interface
@@ -74,22 +75,22 @@ func (m *MockExecer) EXPECT() *MockExecerMockRecorder {
 	return m.recorder
 }
 
-// Exec mocks base method
-func (m *MockExecer) Exec(query string, args ...interface{}) (sql.Result, error) {
-	varargs := []interface{}{query}
+// ExecContext mocks base method
+func (m *MockExecer) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	varargs := []interface{}{ctx, query}
 	for _, a := range args {
 		varargs = append(varargs, a)
 	}
-	ret := m.ctrl.Call(m, "Exec", varargs...)
+	ret := m.ctrl.Call(m, "ExecContext", varargs...)
 	ret0, _ := ret[0].(sql.Result)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// Exec indicates an expected call of Exec
-func (mr *MockExecerMockRecorder) Exec(query interface{}, args ...interface{}) *gomock.Call {
-	varargs := append([]interface{}{query}, args...)
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Exec", reflect.TypeOf((*MockExecer)(nil).Exec), varargs...)
+// ExecContext indicates an expected call of ExecContext
+func (mr *MockExecerMockRecorder) ExecContext(ctx, query interface{}, args ...interface{}) *gomock.Call {
+	varargs := append([]interface{}{ctx, query}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExecContext", reflect.TypeOf((*MockExecer)(nil).ExecContext), varargs...)
 }
 
 // MockDB is a mock of DB interface
@@ -115,53 +116,53 @@ func (m *MockDB) EXPECT() *MockDBMockRecorder {
 	return m.recorder
 }
 
-// Query mocks base method
-func (m *MockDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
-	varargs := []interface{}{query}
+// QueryContext mocks base method
+func (m *MockDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	varargs := []interface{}{ctx, query}
 	for _, a := range args {
 		varargs = append(varargs, a)
 	}
-	ret := m.ctrl.Call(m, "Query", varargs...)
+	ret := m.ctrl.Call(m, "QueryContext", varargs...)
 	ret0, _ := ret[0].(*sql.Rows)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// Query indicates an expected call of Query
-func (mr *MockDBMockRecorder) Query(query interface{}, args ...interface{}) *gomock.Call {
-	varargs := append([]interface{}{query}, args...)
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Query", reflect.TypeOf((*MockDB)(nil).Query), varargs...)
+// QueryContext indicates an expected call of QueryContext
+func (mr *MockDBMockRecorder) QueryContext(ctx, query interface{}, args ...interface{}) *gomock.Call {
+	varargs := append([]interface{}{ctx, query}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryContext", reflect.TypeOf((*MockDB)(nil).QueryContext), varargs...)
 }
 
-// Exec mocks base method
-func (m *MockDB) Exec(query string, args ...interface{}) (sql.Result, error) {
-	varargs := []interface{}{query}
+// ExecContext mocks base method
+func (m *MockDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	varargs := []interface{}{ctx, query}
 	for _, a := range args {
 		varargs = append(varargs, a)
 	}
-	ret := m.ctrl.Call(m, "Exec", varargs...)
+	ret := m.ctrl.Call(m, "ExecContext", varargs...)
 	ret0, _ := ret[0].(sql.Result)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// Exec indicates an expected call of Exec
-func (mr *MockDBMockRecorder) Exec(query interface{}, args ...interface{}) *gomock.Call {
-	varargs := append([]interface{}{query}, args...)
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Exec", reflect.TypeOf((*MockDB)(nil).Exec), varargs...)
+// ExecContext indicates an expected call of ExecContext
+func (mr *MockDBMockRecorder) ExecContext(ctx, query interface{}, args ...interface{}) *gomock.Call {
+	varargs := append([]interface{}{ctx, query}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExecContext", reflect.TypeOf((*MockDB)(nil).ExecContext), varargs...)
 }
 
 // BeginTX mocks base method
-func (m *MockDB) BeginTX() (TX, error) {
-	ret := m.ctrl.Call(m, "BeginTX")
+func (m *MockDB) BeginTX(ctx context.Context) (TX, error) {
+	ret := m.ctrl.Call(m, "BeginTX", ctx)
 	ret0, _ := ret[0].(TX)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // BeginTX indicates an expected call of BeginTX
-func (mr *MockDBMockRecorder) BeginTX() *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BeginTX", reflect.TypeOf((*MockDB)(nil).BeginTX))
+func (mr *MockDBMockRecorder) BeginTX(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BeginTX", reflect.TypeOf((*MockDB)(nil).BeginTX), ctx)
 }
 
 // MockClosableDB is a mock of ClosableDB interface
@@ -187,53 +188,53 @@ func (m *MockClosableDB) EXPECT() *MockClosableDBMockRecorder {
 	return m.recorder
 }
 
-// Query mocks base method
-func (m *MockClosableDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
-	varargs := []interface{}{query}
+// QueryContext mocks base method
+func (m *MockClosableDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	varargs := []interface{}{ctx, query}
 	for _, a := range args {
 		varargs = append(varargs, a)
 	}
-	ret := m.ctrl.Call(m, "Query", varargs...)
+	ret := m.ctrl.Call(m, "QueryContext", varargs...)
 	ret0, _ := ret[0].(*sql.Rows)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// Query indicates an expected call of Query
-func (mr *MockClosableDBMockRecorder) Query(query interface{}, args ...interface{}) *gomock.Call {
-	varargs := append([]interface{}{query}, args...)
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Query", reflect.TypeOf((*MockClosableDB)(nil).Query), varargs...)
+// QueryContext indicates an expected call of QueryContext
+func (mr *MockClosableDBMockRecorder) QueryContext(ctx, query interface{}, args ...interface{}) *gomock.Call {
+	varargs := append([]interface{}{ctx, query}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryContext", reflect.TypeOf((*MockClosableDB)(nil).QueryContext), varargs...)
 }
 
-// Exec mocks base method
-func (m *MockClosableDB) Exec(query string, args ...interface{}) (sql.Result, error) {
-	varargs := []interface{}{query}
+// ExecContext mocks base method
+func (m *MockClosableDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	varargs := []interface{}{ctx, query}
 	for _, a := range args {
 		varargs = append(varargs, a)
 	}
-	ret := m.ctrl.Call(m, "Exec", varargs...)
+	ret := m.ctrl.Call(m, "ExecContext", varargs...)
 	ret0, _ := ret[0].(sql.Result)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// Exec indicates an expected call of Exec
-func (mr *MockClosableDBMockRecorder) Exec(query interface{}, args ...interface{}) *gomock.Call {
-	varargs := append([]interface{}{query}, args...)
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Exec", reflect.TypeOf((*MockClosableDB)(nil).Exec), varargs...)
+// ExecContext indicates an expected call of ExecContext
+func (mr *MockClosableDBMockRecorder) ExecContext(ctx, query interface{}, args ...interface{}) *gomock.Call {
+	varargs := append([]interface{}{ctx, query}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExecContext", reflect.TypeOf((*MockClosableDB)(nil).ExecContext), varargs...)
 }
 
 // BeginTX mocks base method
-func (m *MockClosableDB) BeginTX() (TX, error) {
-	ret := m.ctrl.Call(m, "BeginTX")
+func (m *MockClosableDB) BeginTX(ctx context.Context) (TX, error) {
+	ret := m.ctrl.Call(m, "BeginTX", ctx)
 	ret0, _ := ret[0].(TX)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // BeginTX indicates an expected call of BeginTX
-func (mr *MockClosableDBMockRecorder) BeginTX() *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BeginTX", reflect.TypeOf((*MockClosableDB)(nil).BeginTX))
+func (mr *MockClosableDBMockRecorder) BeginTX(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BeginTX", reflect.TypeOf((*MockClosableDB)(nil).BeginTX), ctx)
 }
 
 // Close mocks base method
@@ -271,53 +272,53 @@ func (m *MockTX) EXPECT() *MockTXMockRecorder {
 	return m.recorder
 }
 
-// Query mocks base method
-func (m *MockTX) Query(query string, args ...interface{}) (*sql.Rows, error) {
-	varargs := []interface{}{query}
+// QueryContext mocks base method
+func (m *MockTX) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	varargs := []interface{}{ctx, query}
 	for _, a := range args {
 		varargs = append(varargs, a)
 	}
-	ret := m.ctrl.Call(m, "Query", varargs...)
+	ret := m.ctrl.Call(m, "QueryContext", varargs...)
 	ret0, _ := ret[0].(*sql.Rows)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// Query indicates an expected call of Query
-func (mr *MockTXMockRecorder) Query(query interface{}, args ...interface{}) *gomock.Call {
-	varargs := append([]interface{}{query}, args...)
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Query", reflect.TypeOf((*MockTX)(nil).Query), varargs...)
+// QueryContext indicates an expected call of QueryContext
+func (mr *MockTXMockRecorder) QueryContext(ctx, query interface{}, args ...interface{}) *gomock.Call {
+	varargs := append([]interface{}{ctx, query}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryContext", reflect.TypeOf((*MockTX)(nil).QueryContext), varargs...)
 }
 
-// Exec mocks base method
-func (m *MockTX) Exec(query string, args ...interface{}) (sql.Result, error) {
-	varargs := []interface{}{query}
+// ExecContext mocks base method
+func (m *MockTX) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	varargs := []interface{}{ctx, query}
 	for _, a := range args {
 		varargs = append(varargs, a)
 	}
-	ret := m.ctrl.Call(m, "Exec", varargs...)
+	ret := m.ctrl.Call(m, "ExecContext", varargs...)
 	ret0, _ := ret[0].(sql.Result)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// Exec indicates an expected call of Exec
-func (mr *MockTXMockRecorder) Exec(query interface{}, args ...interface{}) *gomock.Call {
-	varargs := append([]interface{}{query}, args...)
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Exec", reflect.TypeOf((*MockTX)(nil).Exec), varargs...)
+// ExecContext indicates an expected call of ExecContext
+func (mr *MockTXMockRecorder) ExecContext(ctx, query interface{}, args ...interface{}) *gomock.Call {
+	varargs := append([]interface{}{ctx, query}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExecContext", reflect.TypeOf((*MockTX)(nil).ExecContext), varargs...)
 }
 
 // BeginTX mocks base method
-func (m *MockTX) BeginTX() (TX, error) {
-	ret := m.ctrl.Call(m, "BeginTX")
+func (m *MockTX) BeginTX(ctx context.Context) (TX, error) {
+	ret := m.ctrl.Call(m, "BeginTX", ctx)
 	ret0, _ := ret[0].(TX)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // BeginTX indicates an expected call of BeginTX
-func (mr *MockTXMockRecorder) BeginTX() *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BeginTX", reflect.TypeOf((*MockTX)(nil).BeginTX))
+func (mr *MockTXMockRecorder) BeginTX(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BeginTX", reflect.TypeOf((*MockTX)(nil).BeginTX), ctx)
 }
 
 // Commit mocks base method
@@ -414,53 +415,53 @@ func (m *MockstdDB) EXPECT() *MockstdDBMockRecorder {
 	return m.recorder
 }
 
-// Query mocks base method
-func (m *MockstdDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
-	varargs := []interface{}{query}
+// QueryContext mocks base method
+func (m *MockstdDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	varargs := []interface{}{ctx, query}
 	for _, a := range args {
 		varargs = append(varargs, a)
 	}
-	ret := m.ctrl.Call(m, "Query", varargs...)
+	ret := m.ctrl.Call(m, "QueryContext", varargs...)
 	ret0, _ := ret[0].(*sql.Rows)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// Query indicates an expected call of Query
-func (mr *MockstdDBMockRecorder) Query(query interface{}, args ...interface{}) *gomock.Call {
-	varargs := append([]interface{}{query}, args...)
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Query", reflect.TypeOf((*MockstdDB)(nil).Query), varargs...)
+// QueryContext indicates an expected call of QueryContext
+func (mr *MockstdDBMockRecorder) QueryContext(ctx, query interface{}, args ...interface{}) *gomock.Call {
+	varargs := append([]interface{}{ctx, query}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryContext", reflect.TypeOf((*MockstdDB)(nil).QueryContext), varargs...)
 }
 
-// Exec mocks base method
-func (m *MockstdDB) Exec(query string, args ...interface{}) (sql.Result, error) {
-	varargs := []interface{}{query}
+// ExecContext mocks base method
+func (m *MockstdDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	varargs := []interface{}{ctx, query}
 	for _, a := range args {
 		varargs = append(varargs, a)
 	}
-	ret := m.ctrl.Call(m, "Exec", varargs...)
+	ret := m.ctrl.Call(m, "ExecContext", varargs...)
 	ret0, _ := ret[0].(sql.Result)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// Exec indicates an expected call of Exec
-func (mr *MockstdDBMockRecorder) Exec(query interface{}, args ...interface{}) *gomock.Call {
-	varargs := append([]interface{}{query}, args...)
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Exec", reflect.TypeOf((*MockstdDB)(nil).Exec), varargs...)
+// ExecContext indicates an expected call of ExecContext
+func (mr *MockstdDBMockRecorder) ExecContext(ctx, query interface{}, args ...interface{}) *gomock.Call {
+	varargs := append([]interface{}{ctx, query}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExecContext", reflect.TypeOf((*MockstdDB)(nil).ExecContext), varargs...)
 }
 
-// Begin mocks base method
-func (m *MockstdDB) Begin() (*sql.Tx, error) {
-	ret := m.ctrl.Call(m, "Begin")
+// BeginTx mocks base method
+func (m *MockstdDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	ret := m.ctrl.Call(m, "BeginTx", ctx, opts)
 	ret0, _ := ret[0].(*sql.Tx)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// Begin indicates an expected call of Begin
-func (mr *MockstdDBMockRecorder) Begin() *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Begin", reflect.TypeOf((*MockstdDB)(nil).Begin))
+// BeginTx indicates an expected call of BeginTx
+func (mr *MockstdDBMockRecorder) BeginTx(ctx, opts interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BeginTx", reflect.TypeOf((*MockstdDB)(nil).BeginTx), ctx, opts)
 }
 
 // Close mocks base method
@@ -498,40 +499,40 @@ func (m *MockstdTx) EXPECT() *MockstdTxMockRecorder {
 	return m.recorder
 }
 
-// Query mocks base method
-func (m *MockstdTx) Query(query string, args ...interface{}) (*sql.Rows, error) {
-	varargs := []interface{}{query}
+// QueryContext mocks base method
+func (m *MockstdTx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	varargs := []interface{}{ctx, query}
 	for _, a := range args {
 		varargs = append(varargs, a)
 	}
-	ret := m.ctrl.Call(m, "Query", varargs...)
+	ret := m.ctrl.Call(m, "QueryContext", varargs...)
 	ret0, _ := ret[0].(*sql.Rows)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// Query indicates an expected call of Query
-func (mr *MockstdTxMockRecorder) Query(query interface{}, args ...interface{}) *gomock.Call {
-	varargs := append([]interface{}{query}, args...)
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Query", reflect.TypeOf((*MockstdTx)(nil).Query), varargs...)
+// QueryContext indicates an expected call of QueryContext
+func (mr *MockstdTxMockRecorder) QueryContext(ctx, query interface{}, args ...interface{}) *gomock.Call {
+	varargs := append([]interface{}{ctx, query}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryContext", reflect.TypeOf((*MockstdTx)(nil).QueryContext), varargs...)
 }
 
-// Exec mocks base method
-func (m *MockstdTx) Exec(query string, args ...interface{}) (sql.Result, error) {
-	varargs := []interface{}{query}
+// ExecContext mocks base method
+func (m *MockstdTx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	varargs := []interface{}{ctx, query}
 	for _, a := range args {
 		varargs = append(varargs, a)
 	}
-	ret := m.ctrl.Call(m, "Exec", varargs...)
+	ret := m.ctrl.Call(m, "ExecContext", varargs...)
 	ret0, _ := ret[0].(sql.Result)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// Exec indicates an expected call of Exec
-func (mr *MockstdTxMockRecorder) Exec(query interface{}, args ...interface{}) *gomock.Call {
-	varargs := append([]interface{}{query}, args...)
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Exec", reflect.TypeOf((*MockstdTx)(nil).Exec), varargs...)
+// ExecContext indicates an expected call of ExecContext
+func (mr *MockstdTxMockRecorder) ExecContext(ctx, query interface{}, args ...interface{}) *gomock.Call {
+	varargs := append([]interface{}{ctx, query}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExecContext", reflect.TypeOf((*MockstdTx)(nil).ExecContext), varargs...)
 }
 
 // Commit mocks base method
@@ -5,8 +5,10 @@
 package core
 
 import (
+	context "context"
 	gomock "github.com/golang/mock/gomock"
 	reflect "reflect"
+	time "time"
 )
 
 // MockDriverFactory is a mock of DriverFactory interface
@@ -94,6 +96,18 @@ func (mr *MockDriverMockRecorder) NewMigrationDB() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewMigrationDB", reflect.TypeOf((*MockDriver)(nil).NewMigrationDB))
 }
 
+// Dialect mocks base method
+func (m *MockDriver) Dialect() Dialect {
+	ret := m.ctrl.Call(m, "Dialect")
+	ret0, _ := ret[0].(Dialect)
+	return ret0
+}
+
+// Dialect indicates an expected call of Dialect
+func (mr *MockDriverMockRecorder) Dialect() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Dialect", reflect.TypeOf((*MockDriver)(nil).Dialect))
+}
+
 // MockMigrationDB is a mock of MigrationDB interface
 type MockMigrationDB struct {
 	ctrl     *gomock.Controller
@@ -118,16 +132,16 @@ func (m *MockMigrationDB) EXPECT() *MockMigrationDBMockRecorder {
 }
 
 // GetForwardMigrations mocks base method
-func (m *MockMigrationDB) GetForwardMigrations(arg0 Querier) ([]*MigrationNameAndTime, error) {
-	ret := m.ctrl.Call(m, "GetForwardMigrations", arg0)
+func (m *MockMigrationDB) GetForwardMigrations(arg0 context.Context, arg1 Querier) ([]*MigrationNameAndTime, error) {
+	ret := m.ctrl.Call(m, "GetForwardMigrations", arg0, arg1)
 	ret0, _ := ret[0].([]*MigrationNameAndTime)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetForwardMigrations indicates an expected call of GetForwardMigrations
-func (mr *MockMigrationDBMockRecorder) GetForwardMigrations(arg0 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetForwardMigrations", reflect.TypeOf((*MockMigrationDB)(nil).GetForwardMigrations), arg0)
+func (mr *MockMigrationDBMockRecorder) GetForwardMigrations(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetForwardMigrations", reflect.TypeOf((*MockMigrationDB)(nil).GetForwardMigrations), arg0, arg1)
 }
 
 // CreateTable mocks base method
@@ -143,17 +157,30 @@ func (mr *MockMigrationDBMockRecorder) CreateTable() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateTable", reflect.TypeOf((*MockMigrationDB)(nil).CreateTable))
 }
 
+// UpgradeTable mocks base method
+func (m *MockMigrationDB) UpgradeTable() (Step, error) {
+	ret := m.ctrl.Call(m, "UpgradeTable")
+	ret0, _ := ret[0].(Step)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpgradeTable indicates an expected call of UpgradeTable
+func (mr *MockMigrationDBMockRecorder) UpgradeTable() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpgradeTable", reflect.TypeOf((*MockMigrationDB)(nil).UpgradeTable))
+}
+
 // ForwardMigrate mocks base method
-func (m *MockMigrationDB) ForwardMigrate(migrationName string) (Step, error) {
-	ret := m.ctrl.Call(m, "ForwardMigrate", migrationName)
+func (m *MockMigrationDB) ForwardMigrate(record *MigrationRecord) (Step, error) {
+	ret := m.ctrl.Call(m, "ForwardMigrate", record)
 	ret0, _ := ret[0].(Step)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // ForwardMigrate indicates an expected call of ForwardMigrate
-func (mr *MockMigrationDBMockRecorder) ForwardMigrate(migrationName interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ForwardMigrate", reflect.TypeOf((*MockMigrationDB)(nil).ForwardMigrate), migrationName)
+func (mr *MockMigrationDBMockRecorder) ForwardMigrate(record interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ForwardMigrate", reflect.TypeOf((*MockMigrationDB)(nil).ForwardMigrate), record)
 }
 
 // BackwardMigrate mocks base method
@@ -168,3 +195,56 @@ func (m *MockMigrationDB) BackwardMigrate(migrationName string) (Step, error) {
 func (mr *MockMigrationDBMockRecorder) BackwardMigrate(migrationName interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BackwardMigrate", reflect.TypeOf((*MockMigrationDB)(nil).BackwardMigrate), migrationName)
 }
+
+// RecordHistory mocks base method
+func (m *MockMigrationDB) RecordHistory(entry *HistoryEntry) (Step, error) {
+	ret := m.ctrl.Call(m, "RecordHistory", entry)
+	ret0, _ := ret[0].(Step)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RecordHistory indicates an expected call of RecordHistory
+func (mr *MockMigrationDBMockRecorder) RecordHistory(entry interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecordHistory", reflect.TypeOf((*MockMigrationDB)(nil).RecordHistory), entry)
+}
+
+// GetHistory mocks base method
+func (m *MockMigrationDB) GetHistory(ctx context.Context, q Querier, filter *HistoryFilter) ([]*HistoryEntry, error) {
+	ret := m.ctrl.Call(m, "GetHistory", ctx, q, filter)
+	ret0, _ := ret[0].([]*HistoryEntry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetHistory indicates an expected call of GetHistory
+func (mr *MockMigrationDBMockRecorder) GetHistory(ctx, q, filter interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHistory", reflect.TypeOf((*MockMigrationDB)(nil).GetHistory), ctx, q, filter)
+}
+
+// StatementTimeout mocks base method
+func (m *MockMigrationDB) StatementTimeout(timeout time.Duration) (Step, Step, error) {
+	ret := m.ctrl.Call(m, "StatementTimeout", timeout)
+	ret0, _ := ret[0].(Step)
+	ret1, _ := ret[1].(Step)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// StatementTimeout indicates an expected call of StatementTimeout
+func (mr *MockMigrationDBMockRecorder) StatementTimeout(timeout interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StatementTimeout", reflect.TypeOf((*MockMigrationDB)(nil).StatementTimeout), timeout)
+}
+
+// Lock mocks base method
+func (m *MockMigrationDB) Lock(ctx context.Context, db DB, timeout time.Duration) (func() error, error) {
+	ret := m.ctrl.Call(m, "Lock", ctx, db, timeout)
+	ret0, _ := ret[0].(func() error)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Lock indicates an expected call of Lock
+func (mr *MockMigrationDBMockRecorder) Lock(ctx, db, timeout interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Lock", reflect.TypeOf((*MockMigrationDB)(nil).Lock), ctx, db, timeout)
+}
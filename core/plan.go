@@ -1,10 +1,20 @@
 package core
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
 
 const (
 	Initial = "initial"
 	Latest  = "latest"
+	// Previous is the same as the relative target "-1".
+	Previous = "previous"
+	// Next is the same as the relative target "+1".
+	Next = "next"
 )
 
 type PlanInput struct {
@@ -13,30 +23,74 @@ type PlanInput struct {
 	ForwardMigrated []bool
 	// Target is either the full name of a migration (file) or only it's prefix
 	// that is a non-negative integer.
-	// It can also be one of the following constants: Initial, Latest
+	// It can also be one of the following constants: Initial, Latest,
+	// Previous, Next or a relative target like "-1" or "+2" that moves
+	// backward or forward by the given number of migrations from the
+	// newest forward migrated one.
 	Target      string
 	MigrationDB MigrationDB
+	// Timeout is the default execution timeout of the migrations.
+	// It can be overridden by the steps that implement ExecTimeouter.
+	// Zero means no timeout.
+	Timeout time.Duration
+	// ApplyInfo is recorded in the migrations table along with the
+	// forward migrated migrations.
+	ApplyInfo ApplyInfo
+	// Command is recorded in the history table. Default: HistoryGoto
+	Command string
+	// AppliedChecksums contains the checksums of the applied repeatable
+	// migrations by name. See RepeatableMigrations.
+	AppliedChecksums map[string]string
+}
+
+func (o *PlanInput) newHistoryEntry(name string, forward bool) *HistoryEntry {
+	command := o.Command
+	if command == "" {
+		command = HistoryGoto
+	}
+	return &HistoryEntry{
+		Time:      time.Now().UTC(),
+		Migration: name,
+		Direction: direction(forward),
+		Command:   command,
+		Result:    HistoryOK,
+		ApplyInfo: o.ApplyInfo,
+	}
 }
 
+// MigrationStep is an item of the Steps returned by Plan. It forward or
+// backward migrates a single migration.
+type MigrationStep struct {
+	StepTitleAndResult
+	Name    string
+	Forward bool
+	// Timeout is the maximum execution time of the step. Zero means no timeout.
+	Timeout time.Duration
+	// History is the entry recorded in the history table when the step
+	// succeeds. The goto command records a copy of it with HistoryFailed
+	// result when the step fails.
+	History *HistoryEntry
+}
+
+// Execute adds the name and the direction of the migration to
+// the returned *ExecError.
+func (o *MigrationStep) Execute(ctx ExecCtx) error {
+	if err := o.StepTitleAndResult.Execute(ctx); err != nil {
+		return wrapExecError(o.Name, o.Forward, err)
+	}
+	return nil
+}
+
+// Plan returns a list of *MigrationStep items.
 func Plan(input *PlanInput) (Steps, error) {
 	numMigrations := input.Migrations.NumMigrations()
 	if len(input.ForwardMigrated) != numMigrations {
 		panic("len(forwardMigrated) != numMigrations")
 	}
 
-	// Finding the index of the target migration in the sorted item list.
-	var targetIdx int
-	switch input.Target {
-	case Initial:
-		targetIdx = -1
-	case Latest:
-		targetIdx = numMigrations - 1
-	default:
-		if i, ok := input.Migrations.IndexForName(input.Target); ok {
-			targetIdx = i
-		} else {
-			return nil, fmt.Errorf("invalid target migration: %v", input.Target)
-		}
+	targetIdx, err := resolveTarget(input.Migrations, input.ForwardMigrated, input.Target)
+	if err != nil {
+		return nil, err
 	}
 
 	var steps Steps
@@ -46,56 +100,264 @@ func Plan(input *PlanInput) (Steps, error) {
 		if !input.ForwardMigrated[i] {
 			continue
 		}
-		name := input.Migrations.Name(i)
-		_, backwardStep, err := input.Migrations.Steps(i)
+		step, err := newBackwardStep(input, i)
 		if err != nil {
-			return nil, fmt.Errorf("error loading backward step for migration %q", name)
+			return nil, err
 		}
-		if backwardStep == nil {
-			return nil, fmt.Errorf("%q doesn't have a backward step", name)
+		steps = append(steps, step)
+	}
+
+	// Forward-migrating items that are older than or equal to the target item.
+	for i := 0; i <= targetIdx; i++ {
+		if input.ForwardMigrated[i] {
+			continue
 		}
 
-		updateSystemStep, err := input.MigrationDB.BackwardMigrate(name)
+		step, err := newVersionedForwardStep(input, i)
 		if err != nil {
 			return nil, err
 		}
+		steps = append(steps, step)
+	}
 
-		s := Steps{
-			backwardStep,
-			updateSystemStep,
+	// Applying the new and modified repeatable migrations after the
+	// versioned ones if the target is the latest migration.
+	if rm, ok := input.Migrations.(RepeatableMigrations); ok && input.Target != Initial && targetIdx == numMigrations-1 {
+		for i, n := 0, rm.NumRepeatableMigrations(); i < n; i++ {
+			name := rm.RepeatableName(i)
+			forwardStep, err := rm.RepeatableStep(i)
+			if err != nil {
+				return nil, fmt.Errorf("error loading repeatable migration %q: %s", name, err)
+			}
+			if checksum, ok := input.AppliedChecksums[name]; ok && checksum == StepChecksum(forwardStep) {
+				continue
+			}
+			step, err := newForwardStep(input, name, forwardStep)
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, step)
 		}
-		steps = append(steps, &StepTitleAndResult{
-			Step:  TransactionIfAllowed{s},
+	}
+
+	return steps, nil
+}
+
+// newBackwardStep returns a *MigrationStep that backward migrates the
+// migration with index i and records it in the migrations and history tables.
+func newBackwardStep(input *PlanInput, i int) (*MigrationStep, error) {
+	name := input.Migrations.Name(i)
+	_, backwardStep, err := input.Migrations.Steps(i)
+	if err != nil {
+		return nil, fmt.Errorf("error loading backward step for migration %q", name)
+	}
+	if backwardStep == nil {
+		return nil, fmt.Errorf("%q doesn't have a backward step", name)
+	}
+
+	updateSystemStep, err := input.MigrationDB.BackwardMigrate(name)
+	if err != nil {
+		return nil, err
+	}
+	history := input.newHistoryEntry(name, false)
+	historyStep, err := input.MigrationDB.RecordHistory(history)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, s, err := withTimeout(input, backwardStep)
+	if err != nil {
+		return nil, err
+	}
+	return &MigrationStep{
+		StepTitleAndResult: StepTitleAndResult{
+			Step: migrationTransaction(input.MigrationDB,
+				&timedStep{Step: s, Durations: []*time.Duration{&history.Duration}},
+				Steps{updateSystemStep, historyStep},
+			),
 			Title: "backward-migrate " + name,
-		})
+		},
+		Name:    name,
+		Forward: false,
+		Timeout: timeout,
+		History: history,
+	}, nil
+}
+
+// newVersionedForwardStep is newForwardStep for the migration with index i.
+func newVersionedForwardStep(input *PlanInput, i int) (*MigrationStep, error) {
+	name := input.Migrations.Name(i)
+	forwardStep, _, err := input.Migrations.Steps(i)
+	if err != nil {
+		return nil, fmt.Errorf("error loading forward step for migration %q", name)
 	}
+	return newForwardStep(input, name, forwardStep)
+}
 
-	// Forward-migrating items that are older than or equal to the target item.
-	for i := 0; i <= targetIdx; i++ {
+// newForwardStep returns a *MigrationStep that forward migrates name
+// and records it in the migrations and history tables.
+func newForwardStep(input *PlanInput, name string, forwardStep Step) (*MigrationStep, error) {
+	record := &MigrationRecord{
+		Name:      name,
+		Checksum:  StepChecksum(forwardStep),
+		ApplyInfo: input.ApplyInfo,
+	}
+	updateSystemStep, err := input.MigrationDB.ForwardMigrate(record)
+	if err != nil {
+		return nil, err
+	}
+	history := input.newHistoryEntry(name, true)
+	history.Checksum = record.Checksum
+	historyStep, err := input.MigrationDB.RecordHistory(history)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, s, err := withTimeout(input, forwardStep)
+	if err != nil {
+		return nil, err
+	}
+	return &MigrationStep{
+		StepTitleAndResult: StepTitleAndResult{
+			Step: migrationTransaction(input.MigrationDB,
+				&timedStep{Step: s, Durations: []*time.Duration{&record.Duration, &history.Duration}},
+				Steps{updateSystemStep, historyStep},
+			),
+			Title: "forward-migrate " + name,
+		},
+		Name:    name,
+		Forward: true,
+		Timeout: timeout,
+		History: history,
+	}, nil
+}
+
+// migrationTransaction returns a step that executes the userStep of a
+// migration and the systemStep that records it in a single transaction
+// if allowed. See SystemTransactionSeparator.
+func migrationTransaction(mdb MigrationDB, userStep, systemStep Step) Step {
+	if separateSystemTransaction(mdb) {
+		return Steps{
+			TransactionIfAllowed{Steps{userStep}},
+			TransactionIfAllowed{Steps{systemStep}},
+		}
+	}
+	return TransactionIfAllowed{Steps{userStep, systemStep}}
+}
+
+// PlanRedo returns a plan that backward migrates the newest n forward
+// migrated migrations and then forward migrates them again. The migrations
+// that haven't been forward migrated (gaps) are left untouched.
+// The Target field of input is ignored.
+func PlanRedo(input *PlanInput, n int) (Steps, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("the number of migrations to redo has to be positive: %d", n)
+	}
+	if len(input.ForwardMigrated) != input.Migrations.NumMigrations() {
+		panic("len(forwardMigrated) != numMigrations")
+	}
+
+	// The indexes of the newest n forward migrated migrations in reverse order.
+	var indexes []int
+	for i := len(input.ForwardMigrated) - 1; i >= 0 && len(indexes) < n; i-- {
 		if input.ForwardMigrated[i] {
-			continue
+			indexes = append(indexes, i)
 		}
+	}
+	if len(indexes) == 0 {
+		return nil, errors.New("there are no forward migrated migrations to redo")
+	}
+	if len(indexes) < n {
+		return nil, fmt.Errorf("can't redo %d migrations because only %d are forward migrated", n, len(indexes))
+	}
 
-		name := input.Migrations.Name(i)
-		forwardStep, _, err := input.Migrations.Steps(i)
+	var steps Steps
+	for _, i := range indexes {
+		step, err := newBackwardStep(input, i)
 		if err != nil {
-			return nil, fmt.Errorf("error loading forward step for migration %q", name)
+			return nil, err
 		}
-
-		updateSystemStep, err := input.MigrationDB.ForwardMigrate(name)
+		steps = append(steps, step)
+	}
+	for j := len(indexes) - 1; j >= 0; j-- {
+		step, err := newVersionedForwardStep(input, indexes[j])
 		if err != nil {
 			return nil, err
 		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
 
-		s := Steps{
-			forwardStep,
-			updateSystemStep,
+// withTimeout returns the timeout of the given user step and wraps the step
+// with the server-side timeout steps of the MigrationDB. The server-side
+// timeouts are set only inside transactions because they would stay in
+// effect for the rest of the DB session otherwise. The reset step is
+// executed even if the step fails.
+func withTimeout(input *PlanInput, step Step) (time.Duration, Steps, error) {
+	timeout := StepExecTimeout(step)
+	if timeout == 0 {
+		timeout = input.Timeout
+	}
+	if timeout == 0 || !step.AllowsTransaction() {
+		return timeout, Steps{step}, nil
+	}
+	set, reset, err := input.MigrationDB.StatementTimeout(timeout)
+	if err != nil {
+		return 0, nil, err
+	}
+	return timeout, Steps{set, &finallyStep{Step: step, Finally: reset}}, nil
+}
+
+// resolveTarget returns the index of the target migration in the sorted
+// migration list. The result is -1 if the target is Initial.
+func resolveTarget(migrations MigrationEntries, forwardMigrated []bool, target string) (int, error) {
+	numMigrations := migrations.NumMigrations()
+	switch target {
+	case Initial:
+		return -1, nil
+	case Latest:
+		return numMigrations - 1, nil
+	case Previous:
+		target = "-1"
+	case Next:
+		target = "+1"
+	}
+
+	if i, ok := migrations.IndexForName(target); ok {
+		return i, nil
+	}
+
+	if !strings.HasPrefix(target, "-") && !strings.HasPrefix(target, "+") {
+		return 0, fmt.Errorf("invalid target migration: %v", target)
+	}
+	n, err := strconv.Atoi(target[1:])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid relative target migration: %v", target)
+	}
+
+	// The newest forward migrated item is the current position.
+	current := newestForwardMigrated(forwardMigrated)
+
+	if target[0] == '-' {
+		if current-n < -1 {
+			return 0, fmt.Errorf("relative target %s is before the initial state", target)
 		}
-		steps = append(steps, &StepTitleAndResult{
-			Step:  TransactionIfAllowed{s},
-			Title: "forward-migrate " + name,
-		})
+		return current - n, nil
 	}
+	if current+n >= numMigrations {
+		return 0, fmt.Errorf("relative target %s is beyond the latest migration", target)
+	}
+	return current + n, nil
+}
 
-	return steps, nil
+// newestForwardMigrated returns the index of the newest forward migrated
+// item or -1 if there is none.
+func newestForwardMigrated(forwardMigrated []bool) int {
+	for i := len(forwardMigrated) - 1; i >= 0; i-- {
+		if forwardMigrated[i] {
+			return i
+		}
+	}
+	return -1
 }
@@ -0,0 +1,336 @@
+package core
+
+import (
+	"context"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithTimeout(t *testing.T) {
+	t.Run("no timeout", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		mdb := NewMockMigrationDB(ctrl)
+		step := &SQLExecStep{Query: "q"}
+
+		timeout, steps, err := withTimeout(&PlanInput{MigrationDB: mdb}, step)
+		require.NoError(t, err)
+		assert.Equal(t, time.Duration(0), timeout)
+		assert.Equal(t, Steps{step}, steps)
+	})
+
+	t.Run("default timeout", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		mdb := NewMockMigrationDB(ctrl)
+		set := NewMockStep(ctrl)
+		reset := NewMockStep(ctrl)
+		step := &SQLExecStep{Query: "q"}
+
+		mdb.EXPECT().StatementTimeout(time.Minute).Return(set, reset, nil)
+
+		timeout, steps, err := withTimeout(&PlanInput{MigrationDB: mdb, Timeout: time.Minute}, step)
+		require.NoError(t, err)
+		assert.Equal(t, time.Minute, timeout)
+		assert.Equal(t, Steps{set, &finallyStep{Step: step, Finally: reset}}, steps)
+	})
+
+	t.Run("step timeout overrides default", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		mdb := NewMockMigrationDB(ctrl)
+		set := NewMockStep(ctrl)
+		reset := NewMockStep(ctrl)
+		step := &SQLExecStep{Query: "q", Timeout: time.Second}
+
+		mdb.EXPECT().StatementTimeout(time.Second).Return(set, reset, nil)
+
+		timeout, steps, err := withTimeout(&PlanInput{MigrationDB: mdb, Timeout: time.Minute}, step)
+		require.NoError(t, err)
+		assert.Equal(t, time.Second, timeout)
+		assert.Equal(t, Steps{set, &finallyStep{Step: step, Finally: reset}}, steps)
+	})
+
+	t.Run("reset after failed step", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		mdb := NewMockMigrationDB(ctrl)
+		db := NewMockDB(ctrl)
+		set := &SQLExecStep{Query: "SET LOCK_TIMEOUT 1000;", IsSystem: true}
+		reset := &SQLExecStep{Query: "SET LOCK_TIMEOUT -1;", IsSystem: true}
+		step := &SQLExecStep{Query: "q"}
+
+		mdb.EXPECT().StatementTimeout(time.Second).Return(set, reset, nil)
+		gomock.InOrder(
+			db.EXPECT().ExecContext(gomock.Any(), "SET LOCK_TIMEOUT 1000;"),
+			db.EXPECT().ExecContext(gomock.Any(), "q").Return(nil, assert.AnError),
+			db.EXPECT().ExecContext(gomock.Any(), "SET LOCK_TIMEOUT -1;"),
+		)
+
+		_, steps, err := withTimeout(&PlanInput{MigrationDB: mdb, Timeout: time.Second}, step)
+		require.NoError(t, err)
+		err = steps.Execute(ExecCtx{Context: context.Background(), DB: db})
+		assert.Equal(t, assert.AnError, err)
+	})
+
+	t.Run("notransaction step has only client side timeout", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		mdb := NewMockMigrationDB(ctrl)
+		step := &SQLExecStep{Query: "q", NoTransaction: true}
+
+		timeout, steps, err := withTimeout(&PlanInput{MigrationDB: mdb, Timeout: time.Minute}, step)
+		require.NoError(t, err)
+		assert.Equal(t, time.Minute, timeout)
+		assert.Equal(t, Steps{step}, steps)
+	})
+}
+
+type separatingMigrationDB struct {
+	MigrationDB
+}
+
+func (separatingMigrationDB) SeparateSystemTransaction() bool {
+	return true
+}
+
+func TestMigrationTransaction(t *testing.T) {
+	userStep := &SQLExecStep{Query: "user"}
+	systemStep := &SQLExecStep{Query: "system", IsSystem: true}
+
+	t.Run("single transaction", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		mdb := NewMockMigrationDB(ctrl)
+
+		step := migrationTransaction(mdb, userStep, systemStep)
+		assert.Equal(t, TransactionIfAllowed{Steps{userStep, systemStep}}, step)
+	})
+
+	t.Run("separate system transaction", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		mdb := separatingMigrationDB{NewMockMigrationDB(ctrl)}
+
+		step := migrationTransaction(mdb, userStep, systemStep)
+		assert.Equal(t, Steps{
+			TransactionIfAllowed{Steps{userStep}},
+			TransactionIfAllowed{Steps{systemStep}},
+		}, step)
+	})
+}
+
+func TestResolveTarget(t *testing.T) {
+	migrations := fakeMigrationEntries{
+		{Query: "query a"},
+		{Query: "query b"},
+		{Query: "query c"},
+		{Query: "query d"},
+	}
+	forwardMigrated := []bool{true, true, false, false}
+
+	for _, tc := range []struct {
+		target string
+		index  int
+	}{
+		{Initial, -1},
+		{Latest, 3},
+		{"c", 2},
+		{Previous, 0},
+		{Next, 2},
+		{"-1", 0},
+		{"-2", -1},
+		{"+1", 2},
+		{"+2", 3},
+	} {
+		t.Run(tc.target, func(t *testing.T) {
+			index, err := resolveTarget(migrations, forwardMigrated, tc.target)
+			require.NoError(t, err)
+			assert.Equal(t, tc.index, index)
+		})
+	}
+
+	for _, target := range []string{"x", "-3", "+3", "+0", "-", "+a"} {
+		t.Run("invalid "+target, func(t *testing.T) {
+			_, err := resolveTarget(migrations, forwardMigrated, target)
+			assert.Error(t, err)
+		})
+	}
+
+	t.Run("nothing forward migrated", func(t *testing.T) {
+		index, err := resolveTarget(migrations, make([]bool, 4), Next)
+		require.NoError(t, err)
+		assert.Equal(t, 0, index)
+
+		_, err = resolveTarget(migrations, make([]bool, 4), Previous)
+		assert.Error(t, err)
+	})
+}
+
+// reversibleMigrationEntries has a backward step for each migration.
+type reversibleMigrationEntries struct {
+	fakeMigrationEntries
+}
+
+func (o reversibleMigrationEntries) Steps(index int) (forward, backward Step, err error) {
+	return o.fakeMigrationEntries[index], &SQLExecStep{Query: "undo"}, nil
+}
+
+func TestPlanRedo(t *testing.T) {
+	migrations := reversibleMigrationEntries{fakeMigrationEntries{
+		{Query: "query a"},
+		{Query: "query b"},
+		{Query: "query c"},
+	}}
+
+	newInput := func(ctrl *gomock.Controller, forwardMigrated []bool) *PlanInput {
+		mdb := NewMockMigrationDB(ctrl)
+		mdb.EXPECT().ForwardMigrate(gomock.Any()).Return(Steps{}, nil).AnyTimes()
+		mdb.EXPECT().BackwardMigrate(gomock.Any()).Return(Steps{}, nil).AnyTimes()
+		mdb.EXPECT().RecordHistory(gomock.Any()).Return(Steps{}, nil).AnyTimes()
+		return &PlanInput{
+			Migrations:      migrations,
+			ForwardMigrated: forwardMigrated,
+			MigrationDB:     mdb,
+		}
+	}
+	titles := func(steps Steps) []string {
+		var res []string
+		for _, s := range steps {
+			res = append(res, s.(*MigrationStep).Title)
+		}
+		return res
+	}
+
+	t.Run("n=1", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		steps, err := PlanRedo(newInput(ctrl, []bool{true, true, false}), 1)
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			"backward-migrate b",
+			"forward-migrate b",
+		}, titles(steps))
+	})
+
+	t.Run("n=2", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		steps, err := PlanRedo(newInput(ctrl, []bool{true, true, true}), 2)
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			"backward-migrate c",
+			"backward-migrate b",
+			"forward-migrate b",
+			"forward-migrate c",
+		}, titles(steps))
+	})
+
+	t.Run("gap", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		// b has never been forward migrated so it is left untouched.
+		steps, err := PlanRedo(newInput(ctrl, []bool{true, false, true}), 2)
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			"backward-migrate c",
+			"backward-migrate a",
+			"forward-migrate a",
+			"forward-migrate c",
+		}, titles(steps))
+	})
+
+	t.Run("invalid n", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		_, err := PlanRedo(newInput(ctrl, []bool{true, true, false}), 3)
+		assert.Error(t, err)
+		_, err = PlanRedo(newInput(ctrl, []bool{true, true, false}), 0)
+		assert.Error(t, err)
+	})
+
+	t.Run("nothing forward migrated", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		_, err := PlanRedo(newInput(ctrl, []bool{false, false, false}), 1)
+		assert.Error(t, err)
+	})
+}
+
+// repeatableMigrationEntries has the repeatable migrations r1 and r2.
+type repeatableMigrationEntries struct {
+	fakeMigrationEntries
+}
+
+func (o repeatableMigrationEntries) NumRepeatableMigrations() int {
+	return 2
+}
+
+func (o repeatableMigrationEntries) RepeatableName(index int) string {
+	return "r" + string(rune('1'+index))
+}
+
+func (o repeatableMigrationEntries) RepeatableStep(index int) (Step, error) {
+	return &SQLExecStep{Query: "query " + o.RepeatableName(index)}, nil
+}
+
+func (o repeatableMigrationEntries) IsRepeatableName(name string) bool {
+	return strings.HasPrefix(name, "r")
+}
+
+func TestPlan_Repeatable(t *testing.T) {
+	migrations := repeatableMigrationEntries{fakeMigrationEntries{
+		{Query: "query a"},
+		{Query: "query b"},
+	}}
+
+	plan := func(ctrl *gomock.Controller, target string, appliedChecksums map[string]string) []string {
+		mdb := NewMockMigrationDB(ctrl)
+		mdb.EXPECT().ForwardMigrate(gomock.Any()).Return(Steps{}, nil).AnyTimes()
+		mdb.EXPECT().RecordHistory(gomock.Any()).Return(Steps{}, nil).AnyTimes()
+		steps, err := Plan(&PlanInput{
+			Migrations:       migrations,
+			ForwardMigrated:  []bool{true, false},
+			Target:           target,
+			MigrationDB:      mdb,
+			AppliedChecksums: appliedChecksums,
+		})
+		require.NoError(t, err)
+		var titles []string
+		for _, s := range steps {
+			titles = append(titles, s.(*MigrationStep).Title)
+		}
+		return titles
+	}
+
+	t.Run("new and modified", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		titles := plan(ctrl, Latest, map[string]string{"r1": checksumSQL("old query r1")})
+		assert.Equal(t, []string{
+			"forward-migrate b",
+			"forward-migrate r1",
+			"forward-migrate r2",
+		}, titles)
+	})
+
+	t.Run("unmodified", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		titles := plan(ctrl, Latest, map[string]string{
+			"r1": checksumSQL("query r1"),
+			"r2": checksumSQL("query r2"),
+		})
+		assert.Equal(t, []string{"forward-migrate b"}, titles)
+	})
+
+	t.Run("target isn't the latest", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		assert.Empty(t, plan(ctrl, "a", nil))
+	})
+}
@@ -0,0 +1,200 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Output formats of the status, plan and goto commands.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+func validateFormat(format string) error {
+	switch format {
+	case "", FormatText, FormatJSON:
+		return nil
+	default:
+		return fmt.Errorf("invalid output format %q - it has to be %s or %s", format, FormatText, FormatJSON)
+	}
+}
+
+// StatusReport is the machine-readable output of the status command.
+type StatusReport struct {
+	Migrations []*MigrationStatus `json:"migrations"`
+	// InvalidNames contains the names found in the migrations table
+	// without corresponding migrations.
+	InvalidNames []string `json:"invalid_names"`
+}
+
+type MigrationStatus struct {
+	Name    string `json:"name"`
+	Applied bool   `json:"applied"`
+	// AppliedAt is the time recorded in the migrations table.
+	// It is nil if Applied==false.
+	AppliedAt *time.Time `json:"applied_at"`
+	// Modified is true if the migration has been modified since it was applied.
+	// Modified repeatable migrations are applied again by the goto command.
+	Modified bool `json:"modified"`
+	// Repeatable is true in case of repeatable migrations.
+	// See RepeatableMigrations.
+	Repeatable bool `json:"repeatable,omitempty"`
+	// The following fields are empty if the migration isn't applied or if
+	// it was applied by an older version of this tool that didn't record them.
+	DurationMillis int64  `json:"duration_ms,omitempty"`
+	AppliedBy      string `json:"applied_by,omitempty"`
+	Hostname       string `json:"hostname,omitempty"`
+	Version        string `json:"version,omitempty"`
+}
+
+// Results of StepReport.
+const (
+	StepPlanned = "planned"
+	StepOK      = "ok"
+	StepFailed  = "failed"
+	// StepSkipped is the result of the steps that haven't been executed
+	// because one of the previous steps failed.
+	StepSkipped = "skipped"
+)
+
+// PlanReport is the machine-readable output of the plan and goto commands.
+type PlanReport struct {
+	Steps    []*StepReport `json:"steps"`
+	Warnings []string      `json:"warnings"`
+	// Interrupted is true if the goto command has been stopped by
+	// SIGINT or SIGTERM.
+	Interrupted bool `json:"interrupted"`
+}
+
+func newPlanReport(p *MigrationPlan) *PlanReport {
+	r := &PlanReport{
+		Steps:    make([]*StepReport, 0, len(p.Steps)),
+		Warnings: p.Warnings,
+	}
+	if r.Warnings == nil {
+		r.Warnings = []string{}
+	}
+	return r
+}
+
+type StepReport struct {
+	Migration string `json:"migration"`
+	// Direction is either "forward" or "backward".
+	Direction string `json:"direction"`
+	// Result is StepPlanned in case of the plan command and one of
+	// StepOK, StepFailed or StepSkipped in case of the goto command.
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+	// Path, Line, Column and Statement are filled if the step failed
+	// and the location of the error is known. See ExecError.
+	Path      string `json:"path,omitempty"`
+	Line      int    `json:"line,omitempty"`
+	Column    int    `json:"column,omitempty"`
+	Statement string `json:"statement,omitempty"`
+	// SQL is filled only by the plan command when it prints SQL.
+	SQL string `json:"sql,omitempty"`
+}
+
+func newStepReport(step Step, result string, err error) *StepReport {
+	r := &StepReport{
+		Result: result,
+	}
+	if ms, ok := step.(*MigrationStep); ok {
+		r.Migration = ms.Name
+		r.Direction = direction(ms.Forward)
+	}
+	if err != nil {
+		r.Error = err.Error()
+		var ee *ExecError
+		if errors.As(err, &ee) {
+			r.Path = ee.Path
+			r.Line = ee.Line
+			r.Column = ee.Column
+			r.Statement = ee.Statement
+		}
+	}
+	return r
+}
+
+// TargetsReport is the machine-readable output of the status, plan and
+// goto commands when the config section has targets.
+type TargetsReport struct {
+	Targets []*TargetReport `json:"targets"`
+	Summary TargetsSummary  `json:"summary"`
+}
+
+type TargetReport struct {
+	Target string `json:"target"`
+	// Result is one of StepOK, StepFailed or StepSkipped.
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+	// Report is the *StatusReport or *PlanReport of the target.
+	// It is missing if the command failed before creating it.
+	Report interface{} `json:"report,omitempty"`
+}
+
+type TargetsSummary struct {
+	Total   int `json:"total"`
+	OK      int `json:"ok"`
+	Failed  int `json:"failed"`
+	Skipped int `json:"skipped"`
+}
+
+// HistoryReport is the machine-readable output of the history command.
+type HistoryReport struct {
+	Entries []*HistoryItem `json:"entries"`
+}
+
+type HistoryItem struct {
+	ID        int64     `json:"id"`
+	Time      time.Time `json:"time"`
+	Migration string    `json:"migration"`
+	// Direction is either "forward" or "backward".
+	Direction string `json:"direction"`
+	// Command is goto, redo, hack or baseline.
+	Command string `json:"command"`
+	// Result is either "ok" or "failed".
+	Result         string `json:"result"`
+	Error          string `json:"error,omitempty"`
+	Checksum       string `json:"checksum,omitempty"`
+	DurationMillis int64  `json:"duration_ms"`
+	AppliedBy      string `json:"applied_by,omitempty"`
+	Hostname       string `json:"hostname,omitempty"`
+	Version        string `json:"version,omitempty"`
+}
+
+func newHistoryItem(e *HistoryEntry) *HistoryItem {
+	return &HistoryItem{
+		ID:             e.ID,
+		Time:           e.Time,
+		Migration:      e.Migration,
+		Direction:      e.Direction,
+		Command:        e.Command,
+		Result:         e.Result,
+		Error:          e.Error,
+		Checksum:       e.Checksum,
+		DurationMillis: int64(e.Duration / time.Millisecond),
+		AppliedBy:      e.AppliedBy,
+		Hostname:       e.Hostname,
+		Version:        e.Version,
+	}
+}
+
+func direction(forward bool) string {
+	if forward {
+		return "forward"
+	}
+	return "backward"
+}
+
+func printJSON(output Printer, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	output.Println(string(b))
+	return nil
+}
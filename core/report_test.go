@@ -0,0 +1,35 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestValidateFormat(t *testing.T) {
+	assert.NoError(t, validateFormat(""))
+	assert.NoError(t, validateFormat(FormatText))
+	assert.NoError(t, validateFormat(FormatJSON))
+	assert.Error(t, validateFormat("xml"))
+}
+
+func TestNewStepReport(t *testing.T) {
+	t.Run("MigrationStep", func(t *testing.T) {
+		step := &MigrationStep{
+			Name:    "0001_initial.sql",
+			Forward: false,
+		}
+		r := newStepReport(step, StepFailed, assert.AnError)
+		assert.Equal(t, &StepReport{
+			Migration: "0001_initial.sql",
+			Direction: "backward",
+			Result:    StepFailed,
+			Error:     assert.AnError.Error(),
+		}, r)
+	})
+	t.Run("Other Step", func(t *testing.T) {
+		r := newStepReport(Steps{}, StepOK, nil)
+		assert.Equal(t, &StepReport{
+			Result: StepOK,
+		}, r)
+	})
+}
@@ -1,8 +1,13 @@
 package core
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"github.com/pasztorpisti/migrate/sqlsplit"
 	"strings"
+	"time"
 )
 
 type Step interface {
@@ -12,8 +17,26 @@ type Step interface {
 }
 
 type ExecCtx struct {
-	DB     DB
-	Output Printer
+	// Context has to be passed to the DB operations performed by the step.
+	// The migrate tool cancels it on SIGINT and SIGTERM.
+	Context context.Context
+	DB      DB
+	Output  Printer
+	// Dialect is optional. If it is set then SQLExecStep executes the
+	// statements of its query one by one.
+	Dialect Dialect
+}
+
+// contextOrBackground returns ctx or context.Background() if ctx is nil.
+// The Context fields of the Cmd*Input structs are optional. Cancelling the
+// context aborts the command. If a migration step is being executed then it
+// is rolled back (if it runs in a transaction) and the rest of the steps are
+// skipped.
+func contextOrBackground(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
 }
 
 type PrintCtx struct {
@@ -22,30 +45,121 @@ type PrintCtx struct {
 	PrintSystemSQL bool
 }
 
+// ExecTimeouter is implemented by steps that can have their own execution
+// timeout that overrides the default timeout of the goto command.
+type ExecTimeouter interface {
+	// ExecTimeout returns zero if the step doesn't have its own timeout.
+	ExecTimeout() time.Duration
+}
+
+// StepExecTimeout returns the timeout of step if it implements ExecTimeouter,
+// otherwise zero.
+func StepExecTimeout(step Step) time.Duration {
+	if t, ok := step.(ExecTimeouter); ok {
+		return t.ExecTimeout()
+	}
+	return 0
+}
+
 type SQLExecStep struct {
-	Query         string
+	Query string
+	// RawQuery is the text of the migration file that Query has been
+	// generated from (e.g.: by excluding conditional blocks). The checksum
+	// is calculated from RawQuery if it isn't empty.
+	RawQuery      string
 	Args          []interface{}
 	NoTransaction bool
-	IsSystem      bool
+	// NoSplit executes Query with a single DB call even if the ExecCtx
+	// has a Dialect.
+	NoSplit  bool
+	IsSystem bool
+	// Timeout is optional. See ExecTimeouter.
+	Timeout time.Duration
+	// Path is the path of the migration file that contains Query.
+	// Empty if unknown.
+	Path string
+	// Line is the 1-based line number of the first line of Query in the
+	// migration file. Zero if unknown.
+	Line int
 }
 
 func (o *SQLExecStep) Execute(ctx ExecCtx) error {
-	_, err := ctx.DB.Exec(o.Query, o.Args...)
-	return err
+	// System queries and queries with args are always single statements.
+	if ctx.Dialect == nil || o.NoSplit || o.IsSystem || len(o.Args) != 0 {
+		_, err := ctx.DB.ExecContext(ctx.Context, o.Query, o.Args...)
+		return err
+	}
+
+	statements, err := ctx.Dialect.SplitStatements(o.Query)
+	if err != nil {
+		ee := &ExecError{Path: o.Path, Err: err}
+		if se, ok := err.(*sqlsplit.SyntaxError); ok {
+			ee.Line = o.fileLine(se.Line)
+			ee.Err = errors.New(se.Message)
+		}
+		ee.Err = fmt.Errorf("error splitting SQL into statements: %s", ee.Err)
+		return ee
+	}
+	for _, stmt := range statements {
+		if _, err := ctx.DB.ExecContext(ctx.Context, stmt.SQL); err != nil {
+			ee := &ExecError{
+				Path:          o.Path,
+				Statement:     stmt.SQL,
+				StatementLine: o.fileLine(stmt.Line),
+				Line:          o.fileLine(stmt.Line),
+				Column:        stmt.Column,
+				Err:           err,
+			}
+			if line, column := ctx.Dialect.ErrorPosition(err, stmt.SQL); line != 0 {
+				ee.Line = o.fileLine(stmt.Line + line - 1)
+				ee.Column = column
+				if line == 1 && column != 0 {
+					ee.Column += stmt.Column - 1
+				}
+			}
+			return ee
+		}
+	}
+	return nil
+}
+
+// fileLine converts a line number relative to Query to a line number in
+// the migration file.
+func (o *SQLExecStep) fileLine(line int) int {
+	if o.Line == 0 {
+		return line
+	}
+	return o.Line + line - 1
 }
 
 func (o *SQLExecStep) AllowsTransaction() bool {
 	return !o.NoTransaction
 }
 
-func (o *SQLExecStep) Print(ctx PrintCtx) {
+func (o *SQLExecStep) ExecTimeout() time.Duration {
+	return o.Timeout
+}
+
+func (o *SQLExecStep) Checksum() string {
+	if o.RawQuery != "" {
+		return checksumSQL(o.RawQuery)
+	}
+	return checksumSQL(o.Query)
+}
+
+// printed returns true if Print prints the query with the given ctx.
+func (o *SQLExecStep) printed(ctx PrintCtx) bool {
 	if o.Query == "" {
-		return
+		return false
 	}
-	if !o.IsSystem && !ctx.PrintSQL {
-		return
+	if o.IsSystem {
+		return ctx.PrintSystemSQL
 	}
-	if o.IsSystem && !ctx.PrintSystemSQL {
+	return ctx.PrintSQL
+}
+
+func (o *SQLExecStep) Print(ctx PrintCtx) {
+	if !o.printed(ctx) {
 		return
 	}
 	ctx.Output.Println(strings.TrimSpace(o.Query))
@@ -55,12 +169,99 @@ func (o *SQLExecStep) Print(ctx PrintCtx) {
 	ctx.Output.Println()
 }
 
+// FuncStep executes a Go function. When AllowsTransaction returns true
+// ctx.DB is the transaction of the migration.
+type FuncStep struct {
+	// Name describes the function in the output of the plan command.
+	Name          string
+	Func          func(ctx ExecCtx) error
+	NoTransaction bool
+	// Timeout is optional. See ExecTimeouter.
+	Timeout time.Duration
+}
+
+func (o *FuncStep) Execute(ctx ExecCtx) error {
+	return o.Func(ctx)
+}
+
+func (o *FuncStep) ExecTimeout() time.Duration {
+	return o.Timeout
+}
+
+func (o *FuncStep) AllowsTransaction() bool {
+	return !o.NoTransaction
+}
+
+func (o *FuncStep) Print(ctx PrintCtx) {
+	if !ctx.PrintSQL {
+		return
+	}
+	ctx.Output.Printf("-- Go function: %s\n", o.Name)
+	ctx.Output.Println()
+}
+
+// SQLExecUnlessExistsStep executes Step only if ExistsQuery returns zero.
+// ExistsQuery has to return a single integer, typically a COUNT(*).
+// It is useful for DDL that doesn't support "IF NOT EXISTS" in some databases.
+type SQLExecUnlessExistsStep struct {
+	ExistsQuery string
+	ExistsArgs  []interface{}
+	Step        *SQLExecStep
+}
+
+// Exists executes ExistsQuery and reports whether it returned non-zero.
+func (o *SQLExecUnlessExistsStep) Exists(ctx context.Context, q Querier) (bool, error) {
+	rows, err := q.QueryContext(ctx, o.ExistsQuery, o.ExistsArgs...)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	var n int64
+	if rows.Next() {
+		if err := rows.Scan(&n); err != nil {
+			return false, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	return n != 0, nil
+}
+
+func (o *SQLExecUnlessExistsStep) Execute(ctx ExecCtx) error {
+	exists, err := o.Exists(ctx.Context, ctx.DB)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	return o.Step.Execute(ctx)
+}
+
+func (o *SQLExecUnlessExistsStep) AllowsTransaction() bool {
+	return o.Step.AllowsTransaction()
+}
+
+// Print prints Step with a comment that shows the ExistsQuery guard
+// because the plan can't tell whether Step will be executed.
+func (o *SQLExecUnlessExistsStep) Print(ctx PrintCtx) {
+	if !o.Step.printed(ctx) {
+		return
+	}
+	ctx.Output.Println("-- Executed only if this query returns zero:", o.ExistsQuery)
+	if len(o.ExistsArgs) != 0 {
+		ctx.Output.Println("-- QueryArgs:", o.ExistsArgs)
+	}
+	o.Step.Print(ctx)
+}
+
 type Steps []Step
 
 func (o Steps) Execute(ctx ExecCtx) error {
 	for _, step := range o {
 		if err := step.Execute(ctx); err != nil {
-			// TODO: return error with context
 			return err
 		}
 	}
@@ -92,7 +293,7 @@ func (o TransactionIfAllowed) Execute(ctx ExecCtx) (retErr error) {
 	}
 
 	if o.AllowsTransaction() {
-		tx, err := ctx.DB.BeginTX()
+		tx, err := ctx.DB.BeginTX(ctx.Context)
 		if err != nil {
 			return err
 		}
@@ -103,7 +304,9 @@ func (o TransactionIfAllowed) Execute(ctx ExecCtx) (retErr error) {
 			}
 			if retErr != nil {
 				err := tx.Rollback()
-				if err != nil {
+				// database/sql rolls back the transaction by itself
+				// when the context gets cancelled.
+				if err != nil && !(err == sql.ErrTxDone && ctx.Context.Err() != nil) {
 					ctx.Output.Println("Rollback error:", err)
 				}
 				return
@@ -132,6 +335,56 @@ func (o TransactionIfAllowed) Print(ctx PrintCtx) {
 	}
 }
 
+// timedStep stores the execution time of Step to the items of Durations.
+type timedStep struct {
+	Step
+	Durations []*time.Duration
+}
+
+func (o *timedStep) Execute(ctx ExecCtx) error {
+	start := time.Now()
+	err := o.Step.Execute(ctx)
+	d := time.Since(start)
+	for _, p := range o.Durations {
+		*p = d
+	}
+	return err
+}
+
+// finallyTimeout limits the execution time of the Finally step of a
+// finallyStep when the context of the execution is already done.
+const finallyTimeout = 10 * time.Second
+
+// finallyStep executes Finally after Step even if Step fails. The error of
+// Step takes precedence over the error of Finally. Finally gets a fresh
+// context if the context of the execution has been cancelled.
+type finallyStep struct {
+	Step
+	Finally Step
+}
+
+func (o *finallyStep) Execute(ctx ExecCtx) error {
+	err := o.Step.Execute(ctx)
+	if ctx.Context.Err() != nil {
+		var cancel context.CancelFunc
+		ctx.Context, cancel = context.WithTimeout(context.Background(), finallyTimeout)
+		defer cancel()
+	}
+	if finallyErr := o.Finally.Execute(ctx); err == nil {
+		err = finallyErr
+	}
+	return err
+}
+
+func (o *finallyStep) AllowsTransaction() bool {
+	return o.Step.AllowsTransaction() && o.Finally.AllowsTransaction()
+}
+
+func (o *finallyStep) Print(ctx PrintCtx) {
+	o.Step.Print(ctx)
+	o.Finally.Print(ctx)
+}
+
 type StepTitleAndResult struct {
 	Step
 	Title string
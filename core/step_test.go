@@ -1,9 +1,15 @@
 package core
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
 	"github.com/golang/mock/gomock"
+	"github.com/pasztorpisti/migrate/sqlsplit"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestSQLExecStep_AllowsTransaction(t *testing.T) {
@@ -33,11 +39,12 @@ func TestSQLExecStep_Execute(t *testing.T) {
 			IsSystem: false,
 		}
 		ctx := ExecCtx{
-			DB:     db,
-			Output: printer,
+			Context: context.Background(),
+			DB:      db,
+			Output:  printer,
 		}
 
-		db.EXPECT().Exec("fake query", []interface{}{"str", 42})
+		db.EXPECT().ExecContext(context.Background(), "fake query", []interface{}{"str", 42})
 
 		err := step.Execute(ctx)
 		assert.NoError(t, err)
@@ -54,18 +61,133 @@ func TestSQLExecStep_Execute(t *testing.T) {
 			IsSystem: false,
 		}
 		ctx := ExecCtx{
-			DB:     db,
-			Output: printer,
+			Context: context.Background(),
+			DB:      db,
+			Output:  printer,
 		}
 
-		db.EXPECT().Exec("fake query", []interface{}{"str", 42}).Return(nil, assert.AnError)
+		db.EXPECT().ExecContext(context.Background(), "fake query", []interface{}{"str", 42}).Return(nil, assert.AnError)
 
 		err := step.Execute(ctx)
 		assert.Equal(t, assert.AnError, err)
 		ctrl.Finish()
 	})
+	t.Run("Dialect splits statements", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		db := NewMockDB(ctrl)
+
+		step := &SQLExecStep{
+			Query: "SELECT 1;\n\nSELECT ';';\nSELECT 3;",
+			Path:  "migrations/0001_a.sql",
+			Line:  10,
+		}
+		ctx := ExecCtx{
+			Context: context.Background(),
+			DB:      db,
+			Dialect: testDialect{},
+		}
+
+		gomock.InOrder(
+			db.EXPECT().ExecContext(context.Background(), "SELECT 1"),
+			db.EXPECT().ExecContext(context.Background(), "SELECT ';'").Return(nil, assert.AnError),
+		)
+
+		err := step.Execute(ctx)
+		assert.Equal(t, &ExecError{
+			Path:          "migrations/0001_a.sql",
+			Statement:     "SELECT ';'",
+			StatementLine: 12,
+			Line:          12,
+			Column:        1,
+			Err:           assert.AnError,
+		}, err)
+		ctrl.Finish()
+	})
+	t.Run("NoSplit", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		db := NewMockDB(ctrl)
+
+		query := "SELECT 1;\nSELECT 2;"
+		step := &SQLExecStep{Query: query, NoSplit: true}
+		ctx := ExecCtx{
+			Context: context.Background(),
+			DB:      db,
+			Dialect: testDialect{},
+		}
+
+		db.EXPECT().ExecContext(context.Background(), query)
+
+		err := step.Execute(ctx)
+		assert.NoError(t, err)
+		ctrl.Finish()
+	})
+	t.Run("Dialect error position", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		db := NewMockDB(ctrl)
+
+		step := &SQLExecStep{
+			Query: "SELECT 1; SELECT\n  x;",
+			Path:  "migrations/0001_a.sql",
+			Line:  10,
+		}
+		ctx := ExecCtx{
+			Context: context.Background(),
+			DB:      db,
+			Dialect: testDialect{},
+		}
+
+		gomock.InOrder(
+			db.EXPECT().ExecContext(context.Background(), "SELECT 1"),
+			db.EXPECT().ExecContext(context.Background(), "SELECT\n  x").Return(nil, errTestPosition),
+		)
+
+		err := step.Execute(ctx)
+		assert.Equal(t, &ExecError{
+			Path:          "migrations/0001_a.sql",
+			Statement:     "SELECT\n  x",
+			StatementLine: 10,
+			Line:          11,
+			Column:        3,
+			Err:           errTestPosition,
+		}, err)
+		ctrl.Finish()
+	})
+	t.Run("Dialect syntax error", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		db := NewMockDB(ctrl)
+
+		step := &SQLExecStep{
+			Query: "SELECT 1;\nSELECT 'a;",
+			Line:  10,
+		}
+		ctx := ExecCtx{
+			Context: context.Background(),
+			DB:      db,
+			Dialect: testDialect{},
+		}
+
+		err := step.Execute(ctx)
+		assert.EqualError(t, err, "error at line 11: error splitting SQL into statements: unterminated string literal")
+		ctrl.Finish()
+	})
+}
+
+type testDialect struct{}
+
+func (testDialect) SplitStatements(query string) ([]sqlsplit.Statement, error) {
+	return sqlsplit.Split(query, sqlsplit.Postgres)
 }
 
+// ErrorPosition reports the 2nd line and 3rd column for errTestPosition.
+func (testDialect) ErrorPosition(err error, statement string) (line, column int) {
+	if err == errTestPosition {
+		return 2, 3
+	}
+	return 0, 0
+}
+
+var errTestPosition = errors.New("test error with position")
+
 func TestSQLExecStep_Print(t *testing.T) {
 	t.Run("IsSystem=false PrintSQL=false", func(t *testing.T) {
 		ctrl := gomock.NewController(t)
@@ -142,6 +264,30 @@ func TestSQLExecStep_Print(t *testing.T) {
 	})
 }
 
+func TestSQLExecUnlessExistsStep_Print(t *testing.T) {
+	step := &SQLExecUnlessExistsStep{
+		ExistsQuery: "SELECT COUNT(*) FROM t WHERE c = ?",
+		ExistsArgs:  []interface{}{"col"},
+		Step: &SQLExecStep{
+			Query:    "ALTER TABLE t ADD COLUMN col INT;",
+			IsSystem: true,
+		},
+	}
+
+	t.Run("PrintSystemSQL=false", func(t *testing.T) {
+		var buf bytes.Buffer
+		step.Print(PrintCtx{Output: NewPrinter(&buf), PrintSQL: true})
+		assert.Empty(t, buf.String())
+	})
+	t.Run("PrintSystemSQL=true", func(t *testing.T) {
+		var buf bytes.Buffer
+		step.Print(PrintCtx{Output: NewPrinter(&buf), PrintSystemSQL: true})
+		assert.Equal(t, "-- Executed only if this query returns zero: SELECT COUNT(*) FROM t WHERE c = ?\n"+
+			"-- QueryArgs: [col]\n"+
+			"ALTER TABLE t ADD COLUMN col INT;\n\n", buf.String())
+	})
+}
+
 func TestSteps_AllowsTransaction(t *testing.T) {
 	t.Run("NumSteps=0", func(t *testing.T) {
 		steps := Steps{}
@@ -224,8 +370,9 @@ func TestSteps_Execute(t *testing.T) {
 
 			steps := Steps{}
 			ctx := ExecCtx{
-				DB:     db,
-				Output: printer,
+				Context: context.Background(),
+				DB:      db,
+				Output:  printer,
 			}
 			err := steps.Execute(ctx)
 			assert.NoError(t, err)
@@ -241,8 +388,9 @@ func TestSteps_Execute(t *testing.T) {
 				step0,
 			}
 			ctx := ExecCtx{
-				DB:     db,
-				Output: printer,
+				Context: context.Background(),
+				DB:      db,
+				Output:  printer,
 			}
 
 			step0.EXPECT().Execute(ctx)
@@ -263,8 +411,9 @@ func TestSteps_Execute(t *testing.T) {
 				step1,
 			}
 			ctx := ExecCtx{
-				DB:     db,
-				Output: printer,
+				Context: context.Background(),
+				DB:      db,
+				Output:  printer,
 			}
 
 			gomock.InOrder(
@@ -290,8 +439,9 @@ func TestSteps_Execute(t *testing.T) {
 				step2,
 			}
 			ctx := ExecCtx{
-				DB:     db,
-				Output: printer,
+				Context: context.Background(),
+				DB:      db,
+				Output:  printer,
 			}
 
 			gomock.InOrder(
@@ -320,8 +470,9 @@ func TestSteps_Execute(t *testing.T) {
 				step2,
 			}
 			ctx := ExecCtx{
-				DB:     db,
-				Output: printer,
+				Context: context.Background(),
+				DB:      db,
+				Output:  printer,
 			}
 
 			step0.EXPECT().Execute(ctx).Return(assert.AnError)
@@ -344,8 +495,9 @@ func TestSteps_Execute(t *testing.T) {
 				step2,
 			}
 			ctx := ExecCtx{
-				DB:     db,
-				Output: printer,
+				Context: context.Background(),
+				DB:      db,
+				Output:  printer,
 			}
 
 			gomock.InOrder(
@@ -371,8 +523,9 @@ func TestSteps_Execute(t *testing.T) {
 				step2,
 			}
 			ctx := ExecCtx{
-				DB:     db,
-				Output: printer,
+				Context: context.Background(),
+				DB:      db,
+				Output:  printer,
 			}
 
 			gomock.InOrder(
@@ -442,8 +595,9 @@ func TestTransactionIfAllowed_Execute(t *testing.T) {
 
 			steps := TransactionIfAllowed{Steps{}}
 			ctx := ExecCtx{
-				DB:     db,
-				Output: printer,
+				Context: context.Background(),
+				DB:      db,
+				Output:  printer,
 			}
 			err := steps.Execute(ctx)
 			assert.NoError(t, err)
@@ -459,8 +613,9 @@ func TestTransactionIfAllowed_Execute(t *testing.T) {
 				step0,
 			}}
 			ctx := ExecCtx{
-				DB:     db,
-				Output: printer,
+				Context: context.Background(),
+				DB:      db,
+				Output:  printer,
 			}
 
 			gomock.InOrder(
@@ -483,16 +638,18 @@ func TestTransactionIfAllowed_Execute(t *testing.T) {
 				step0,
 			}}
 			ctx := ExecCtx{
-				DB:     db,
-				Output: printer,
+				Context: context.Background(),
+				DB:      db,
+				Output:  printer,
 			}
 			ctx2 := ExecCtx{
-				DB:     tx,
-				Output: printer,
+				Context: context.Background(),
+				DB:      tx,
+				Output:  printer,
 			}
 			gomock.InOrder(
 				step0.EXPECT().AllowsTransaction().Return(true),
-				db.EXPECT().BeginTX().Return(tx, nil),
+				db.EXPECT().BeginTX(context.Background()).Return(tx, nil),
 				step0.EXPECT().Execute(ctx2),
 				tx.EXPECT().Commit(),
 			)
@@ -513,8 +670,9 @@ func TestTransactionIfAllowed_Execute(t *testing.T) {
 				step1,
 			}}
 			ctx := ExecCtx{
-				DB:     db,
-				Output: printer,
+				Context: context.Background(),
+				DB:      db,
+				Output:  printer,
 			}
 
 			c0 := step0.EXPECT().AllowsTransaction().Return(true)
@@ -542,18 +700,20 @@ func TestTransactionIfAllowed_Execute(t *testing.T) {
 				step1,
 			}}
 			ctx := ExecCtx{
-				DB:     db,
-				Output: printer,
+				Context: context.Background(),
+				DB:      db,
+				Output:  printer,
 			}
 			ctx2 := ExecCtx{
-				DB:     tx,
-				Output: printer,
+				Context: context.Background(),
+				DB:      tx,
+				Output:  printer,
 			}
 
 			c0 := step0.EXPECT().AllowsTransaction().Return(true)
 			c1 := step1.EXPECT().AllowsTransaction().Return(true)
 			gomock.InOrder(
-				db.EXPECT().BeginTX().Return(tx, nil).After(c0).After(c1),
+				db.EXPECT().BeginTX(context.Background()).Return(tx, nil).After(c0).After(c1),
 				step0.EXPECT().Execute(ctx2),
 				step1.EXPECT().Execute(ctx2),
 				tx.EXPECT().Commit(),
@@ -577,8 +737,9 @@ func TestTransactionIfAllowed_Execute(t *testing.T) {
 				step1,
 			}}
 			ctx := ExecCtx{
-				DB:     db,
-				Output: printer,
+				Context: context.Background(),
+				DB:      db,
+				Output:  printer,
 			}
 
 			c0 := step0.EXPECT().AllowsTransaction().Return(true)
@@ -601,8 +762,9 @@ func TestTransactionIfAllowed_Execute(t *testing.T) {
 				step1,
 			}}
 			ctx := ExecCtx{
-				DB:     db,
-				Output: printer,
+				Context: context.Background(),
+				DB:      db,
+				Output:  printer,
 			}
 
 			c0 := step0.EXPECT().AllowsTransaction().Return(true)
@@ -629,18 +791,20 @@ func TestTransactionIfAllowed_Execute(t *testing.T) {
 				step1,
 			}}
 			ctx := ExecCtx{
-				DB:     db,
-				Output: printer,
+				Context: context.Background(),
+				DB:      db,
+				Output:  printer,
 			}
 			ctx2 := ExecCtx{
-				DB:     tx,
-				Output: printer,
+				Context: context.Background(),
+				DB:      tx,
+				Output:  printer,
 			}
 
 			c0 := step0.EXPECT().AllowsTransaction().Return(true)
 			c1 := step1.EXPECT().AllowsTransaction().Return(true)
 			gomock.InOrder(
-				db.EXPECT().BeginTX().Return(tx, nil).After(c0).After(c1),
+				db.EXPECT().BeginTX(context.Background()).Return(tx, nil).After(c0).After(c1),
 				step0.EXPECT().Execute(ctx2).Return(assert.AnError),
 				tx.EXPECT().Rollback(),
 			)
@@ -662,18 +826,20 @@ func TestTransactionIfAllowed_Execute(t *testing.T) {
 				step1,
 			}}
 			ctx := ExecCtx{
-				DB:     db,
-				Output: printer,
+				Context: context.Background(),
+				DB:      db,
+				Output:  printer,
 			}
 			ctx2 := ExecCtx{
-				DB:     tx,
-				Output: printer,
+				Context: context.Background(),
+				DB:      tx,
+				Output:  printer,
 			}
 
 			c0 := step0.EXPECT().AllowsTransaction().Return(true)
 			c1 := step1.EXPECT().AllowsTransaction().Return(true)
 			gomock.InOrder(
-				db.EXPECT().BeginTX().Return(tx, nil).After(c0).After(c1),
+				db.EXPECT().BeginTX(context.Background()).Return(tx, nil).After(c0).After(c1),
 				step0.EXPECT().Execute(ctx2),
 				step1.EXPECT().Execute(ctx2).Return(assert.AnError),
 				tx.EXPECT().Rollback(),
@@ -683,6 +849,43 @@ func TestTransactionIfAllowed_Execute(t *testing.T) {
 			assert.Equal(t, assert.AnError, err)
 			ctrl.Finish()
 		})
+		t.Run("Context cancelled", func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			db := NewMockDB(ctrl)
+			tx := NewMockTX(ctrl)
+			printer := NewMockPrinter(ctrl)
+			step0 := NewMockStep(ctrl)
+
+			cctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			steps := TransactionIfAllowed{Steps{
+				step0,
+			}}
+			ctx := ExecCtx{
+				Context: cctx,
+				DB:      db,
+				Output:  printer,
+			}
+			ctx2 := ExecCtx{
+				Context: cctx,
+				DB:      tx,
+				Output:  printer,
+			}
+
+			// The printer doesn't expect a "Rollback error" message because
+			// database/sql has already rolled back the transaction.
+			c0 := step0.EXPECT().AllowsTransaction().Return(true)
+			gomock.InOrder(
+				db.EXPECT().BeginTX(cctx).Return(tx, nil).After(c0),
+				step0.EXPECT().Execute(ctx2).Return(context.Canceled),
+				tx.EXPECT().Rollback().Return(sql.ErrTxDone),
+			)
+
+			err := steps.Execute(ctx)
+			assert.Equal(t, context.Canceled, err)
+			ctrl.Finish()
+		})
 	})
 }
 
@@ -726,3 +929,68 @@ func TestStepTitleAndResult(t *testing.T) {
 		ctrl.Finish()
 	})
 }
+
+func TestTimedStep(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	step := NewMockStep(ctrl)
+	ctx := ExecCtx{Context: context.Background()}
+
+	step.EXPECT().Execute(ctx).Do(func(ExecCtx) { time.Sleep(10 * time.Millisecond) }).Return(assert.AnError)
+
+	var d1, d2 time.Duration
+	err := (&timedStep{Step: step, Durations: []*time.Duration{&d1, &d2}}).Execute(ctx)
+	assert.Equal(t, assert.AnError, err)
+	assert.True(t, d1 >= 10*time.Millisecond, "duration: %v", d1)
+	assert.Equal(t, d1, d2)
+}
+
+func TestFinallyStep(t *testing.T) {
+	t.Run("step fails", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		step := NewMockStep(ctrl)
+		finally := NewMockStep(ctrl)
+		ctx := ExecCtx{Context: context.Background()}
+
+		gomock.InOrder(
+			step.EXPECT().Execute(ctx).Return(assert.AnError),
+			finally.EXPECT().Execute(ctx).Return(errors.New("finally error")),
+		)
+
+		err := (&finallyStep{Step: step, Finally: finally}).Execute(ctx)
+		assert.Equal(t, assert.AnError, err)
+	})
+
+	t.Run("finally fails", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		step := NewMockStep(ctrl)
+		finally := NewMockStep(ctrl)
+		ctx := ExecCtx{Context: context.Background()}
+
+		step.EXPECT().Execute(ctx)
+		finally.EXPECT().Execute(ctx).Return(assert.AnError)
+
+		err := (&finallyStep{Step: step, Finally: finally}).Execute(ctx)
+		assert.Equal(t, assert.AnError, err)
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		step := NewMockStep(ctrl)
+		finally := NewMockStep(ctrl)
+		cancelledCtx, cancel := context.WithCancel(context.Background())
+		cancel()
+		ctx := ExecCtx{Context: cancelledCtx}
+
+		step.EXPECT().Execute(ctx).Return(context.Canceled)
+		finally.EXPECT().Execute(gomock.Any()).Do(func(ctx ExecCtx) {
+			assert.NoError(t, ctx.Context.Err())
+		})
+
+		err := (&finallyStep{Step: step, Finally: finally}).Execute(ctx)
+		assert.Equal(t, context.Canceled, err)
+	})
+}
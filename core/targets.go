@@ -0,0 +1,319 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pasztorpisti/migrate/template"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// targetParam is the template parameter of the data_source and the
+// placeholder in the db params that is replaced with the name of the target.
+const targetParam = "target"
+
+// targetsConfig is the targets section of a config. The status, plan and
+// goto commands are executed on each target of the section. A target is
+// usually a schema or a database that is referenced by the data_source or
+// the db params through the {target} placeholder.
+type targetsConfig struct {
+	// List and Query are exclusive.
+	List []string
+	// Query returns the names of the targets in its only column.
+	Query string
+	// DataSource is the data source of the connection that executes Query.
+	// Default: the db.data_source if it doesn't contain {target}.
+	DataSource string
+	// Parallelism is the maximum number of targets processed concurrently.
+	// Zero is the same as 1.
+	Parallelism int
+}
+
+func (o *targetsConfig) Validate() error {
+	if (len(o.List) == 0) == (o.Query == "") {
+		return errors.New("targets has to have exactly one of the list and query fields")
+	}
+	if o.Parallelism < 0 {
+		return fmt.Errorf("negative targets.parallelism: %d", o.Parallelism)
+	}
+	seen := make(map[string]bool, len(o.List))
+	for _, t := range o.List {
+		if t == "" {
+			return errors.New("empty name in targets.list")
+		}
+		if seen[t] {
+			return fmt.Errorf("duplicate name in targets.list: %q", t)
+		}
+		seen[t] = true
+	}
+
+	dsn, err := performSubstitution(o.DataSource)
+	if err != nil {
+		return fmt.Errorf("error substituting template parameters to targets.data_source %q: %s", o.DataSource, err)
+	}
+	o.DataSource = dsn
+	return nil
+}
+
+// forTarget returns the config of a single target of a validated config.
+func (o *dbConfig) forTarget(target string) (*dbConfig, error) {
+	cfg := *o
+	cfg.Targets = nil
+
+	dsn, _, err := substituteTarget(o.DataSource, target)
+	if err != nil {
+		return nil, fmt.Errorf("error substituting template parameters to db.data_source %q: %s", o.DataSource, err)
+	}
+	cfg.DataSource = dsn
+
+	cfg.DriverParams = make(map[string]string, len(o.DriverParams))
+	for k, v := range o.DriverParams {
+		cfg.DriverParams[k] = strings.Replace(v, "{"+targetParam+"}", target, -1)
+	}
+	return &cfg, nil
+}
+
+// substituteTarget replaces the {target} parameters in the escaped template
+// string s and returns the unescaped result. Used is true if s contains
+// at least one {target} parameter.
+func substituteTarget(s, target string) (result string, used bool, err error) {
+	sections, err := template.Parse(s)
+	if err != nil {
+		return "", false, err
+	}
+	var buf bytes.Buffer
+	for _, section := range sections {
+		switch {
+		case !section.IsParameter():
+			buf.WriteString(section.String)
+		case len(section.Parameter) == 1 && section.Parameter[0] == targetParam:
+			buf.WriteString(target)
+			used = true
+		default:
+			return "", false, fmt.Errorf("unknown template instruction: %q", section.Parameter[0])
+		}
+	}
+	return buf.String(), used, nil
+}
+
+// listTargets returns the targets.list or the result of the targets.query.
+func listTargets(ctx context.Context, cfg *dbConfig) ([]string, error) {
+	if cfg.Targets.Query == "" {
+		return cfg.Targets.List, nil
+	}
+
+	// The target specific parts of the db config are empty in the
+	// connection of the query.
+	queryCfg, err := cfg.forTarget("")
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Targets.DataSource != "" {
+		queryCfg.DataSource = cfg.Targets.DataSource
+	} else if _, used, _ := substituteTarget(cfg.DataSource, ""); used {
+		return nil, errors.New("targets.query requires targets.data_source because db.data_source contains {target}")
+	}
+
+	driverFactory, ok := GetDriverFactory(queryCfg.Driver)
+	if !ok {
+		return nil, fmt.Errorf("invalid DB driver: %s", queryCfg.Driver)
+	}
+	driver, err := driverFactory.NewDriver(queryCfg.DriverParams)
+	if err != nil {
+		return nil, fmt.Errorf("error creating %q DB driver: %s", queryCfg.Driver, err)
+	}
+	db, err := driver.Open(queryCfg.DataSource)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, cfg.Targets.Query)
+	if err != nil {
+		return nil, fmt.Errorf("error executing targets.query: %s", err)
+	}
+	defer rows.Close()
+
+	var targets []string
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err != nil {
+			return nil, fmt.Errorf("error scanning the result of targets.query: %s", err)
+		}
+		targets = append(targets, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row error during the scanning of the result of targets.query: %s", err)
+	}
+	return targets, nil
+}
+
+// newMigrationSource creates the migration source of cfg and sets its
+// conditions and vars if it is a ConditionalMigrationSource or a
+// VariableMigrationSource.
+func newMigrationSource(cfg *dbConfig, configFile string) (MigrationSource, error) {
+	sourceFactory, ok := GetMigrationSourceFactory(cfg.MigrationSourceType)
+	if !ok {
+		return nil, fmt.Errorf("unknown migration_source type in config: %s", cfg.MigrationSourceType)
+	}
+	source, err := sourceFactory.NewMigrationSource(filepath.Dir(configFile), cfg.MigrationSourceParams)
+	if err != nil {
+		return nil, fmt.Errorf("error creating migration source: %s", err)
+	}
+	if cs, ok := source.(ConditionalMigrationSource); ok {
+		conditions := make(map[string]string, len(cfg.Tags)+1)
+		for k, v := range cfg.Tags {
+			conditions[k] = v
+		}
+		conditions[dbCondition] = cfg.Name
+		cs.SetConditions(conditions)
+	}
+	if vs, ok := source.(VariableMigrationSource); ok {
+		vs.SetVars(cfg.Vars)
+	}
+	return source, nil
+}
+
+// loadMigrations loads the migrations from the migration source of cfg.
+func loadMigrations(cfg *dbConfig, configFile string) (MigrationEntries, error) {
+	source, err := newMigrationSource(cfg, configFile)
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := source.MigrationEntries()
+	if err != nil {
+		return nil, fmt.Errorf("error loading migrations: %s", err)
+	}
+	return migrations, nil
+}
+
+// targetFunc executes a command on a single target. The text output of the
+// command goes to output. The returned report is the JSON output of the
+// command. It can be nil if the command fails early.
+type targetFunc func(ctx context.Context, cfg *dbConfig, output Printer) (report interface{}, err error)
+
+// runOnTargets executes fn on the targets of cfg using at most
+// targets.parallelism goroutines. The text output of the targets isn't
+// interleaved: the output of a target is printed when it finishes unless
+// the targets are processed one by one. A summary is printed at the end.
+func runOnTargets(ctx context.Context, cfg *dbConfig, output Printer, format string, fn targetFunc) error {
+	targets, err := listTargets(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	jsonFormat := format == FormatJSON
+
+	parallelism := cfg.Targets.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	if parallelism > len(targets) {
+		parallelism = len(targets)
+	}
+	stream := parallelism == 1 && !jsonFormat
+
+	report := &TargetsReport{
+		Targets: make([]*TargetReport, len(targets)),
+	}
+	var mu sync.Mutex
+	runTarget := func(i int) {
+		r := &TargetReport{Target: targets[i]}
+		report.Targets[i] = r
+		if ctx.Err() != nil {
+			r.Result = StepSkipped
+			return
+		}
+
+		var buf bytes.Buffer
+		var out Printer = nullPrinter{}
+		if stream {
+			output.Printf("==> %s\n", r.Target)
+			out = output
+		} else if !jsonFormat {
+			out = NewPrinter(&buf)
+		}
+
+		tcfg, err := cfg.forTarget(r.Target)
+		if err == nil {
+			r.Report, err = fn(ctx, tcfg, out)
+		}
+		if err != nil {
+			r.Result = StepFailed
+			r.Error = err.Error()
+			out.Println("ERROR:", err)
+		} else {
+			r.Result = StepOK
+		}
+
+		if !stream && !jsonFormat {
+			mu.Lock()
+			output.Printf("==> %s\n", r.Target)
+			output.Print(buf.String())
+			mu.Unlock()
+		}
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				runTarget(i)
+			}
+		}()
+	}
+	for i := range targets {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	report.Summary.Total = len(targets)
+	for _, r := range report.Targets {
+		switch r.Result {
+		case StepOK:
+			report.Summary.OK++
+		case StepFailed:
+			report.Summary.Failed++
+		case StepSkipped:
+			report.Summary.Skipped++
+		}
+	}
+
+	if jsonFormat {
+		if err := printJSON(output, report); err != nil {
+			return err
+		}
+	} else {
+		printTargetsSummary(output, report)
+	}
+
+	s := report.Summary
+	switch {
+	case s.Failed == 0 && s.Skipped == 0:
+		return nil
+	case ctx.Err() != nil:
+		return fmt.Errorf("%s: %d of %d targets failed, %d skipped", ErrInterrupted, s.Failed, s.Total, s.Skipped)
+	default:
+		return fmt.Errorf("%d of %d targets failed", s.Failed, s.Total)
+	}
+}
+
+func printTargetsSummary(output Printer, report *TargetsReport) {
+	s := report.Summary
+	if s.Total == 0 {
+		output.Println("There are no targets.")
+		return
+	}
+	output.Printf("Summary: %d targets, %d ok, %d failed, %d skipped\n", s.Total, s.OK, s.Failed, s.Skipped)
+	for _, r := range report.Targets {
+		if r.Result == StepFailed {
+			output.Printf("  %s: %s\n", r.Target, r.Error)
+		}
+	}
+}
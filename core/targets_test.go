@@ -0,0 +1,137 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestTargetsConfig_Validate(t *testing.T) {
+	tests := []*struct {
+		name  string
+		cfg   targetsConfig
+		valid bool
+	}{
+		{"list", targetsConfig{List: []string{"a", "b"}}, true},
+		{"query", targetsConfig{Query: "SELECT 1", Parallelism: 4}, true},
+		{"list and query", targetsConfig{List: []string{"a"}, Query: "SELECT 1"}, false},
+		{"neither list nor query", targetsConfig{}, false},
+		{"negative parallelism", targetsConfig{List: []string{"a"}, Parallelism: -1}, false},
+		{"empty name", targetsConfig{List: []string{"a", ""}}, false},
+		{"duplicate name", targetsConfig{List: []string{"a", "b", "a"}}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.cfg.Validate()
+			if test.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestSubstituteTarget(t *testing.T) {
+	tests := []*struct {
+		input  string
+		output string
+		used   bool
+	}{
+		{"", "", false},
+		{"file:db.sqlite", "file:db.sqlite", false},
+		{"file:{target}.sqlite", "file:t1.sqlite", true},
+		{`dbname={target} options=\{x\}`, "dbname=t1 options={x}", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			output, used, err := substituteTarget(test.input, "t1")
+			require.NoError(t, err)
+			assert.Equal(t, test.output, output)
+			assert.Equal(t, test.used, used)
+		})
+	}
+
+	t.Run("unknown parameter", func(t *testing.T) {
+		_, _, err := substituteTarget("{tenant}", "t1")
+		assert.Error(t, err)
+	})
+}
+
+func TestDBConfig_ForTarget(t *testing.T) {
+	cfg := &dbConfig{
+		Driver:       "postgres",
+		DriverParams: map[string]string{"migrations_schema": "{target}", "migrations_table": "migrations"},
+		DataSource:   "postgres://localhost/{target}",
+		Targets:      &targetsConfig{List: []string{"t1"}},
+	}
+	tcfg, err := cfg.forTarget("t1")
+	require.NoError(t, err)
+	assert.Nil(t, tcfg.Targets)
+	assert.Equal(t, "postgres://localhost/t1", tcfg.DataSource)
+	assert.Equal(t, map[string]string{"migrations_schema": "t1", "migrations_table": "migrations"}, tcfg.DriverParams)
+	// The original config is left untouched.
+	assert.Equal(t, "{target}", cfg.DriverParams["migrations_schema"])
+}
+
+func TestRunOnTargets(t *testing.T) {
+	cfg := &dbConfig{
+		DataSource: "{target}",
+		Targets:    &targetsConfig{List: []string{"t1", "t2", "t3"}, Parallelism: 2},
+	}
+	fn := func(ctx context.Context, cfg *dbConfig, output Printer) (interface{}, error) {
+		output.Println("output of", cfg.DataSource)
+		if cfg.DataSource == "t2" {
+			return nil, errors.New("t2 failed")
+		}
+		return cfg.DataSource + " report", nil
+	}
+
+	t.Run("text", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := runOnTargets(context.Background(), cfg, NewPrinter(&buf), FormatText, fn)
+		assert.EqualError(t, err, "1 of 3 targets failed")
+
+		output := buf.String()
+		for _, s := range []string{
+			"==> t1\noutput of t1\n",
+			"==> t2\noutput of t2\nERROR: t2 failed\n",
+			"==> t3\noutput of t3\n",
+		} {
+			assert.Contains(t, output, s)
+		}
+		assert.True(t, strings.HasSuffix(output, "Summary: 3 targets, 2 ok, 1 failed, 0 skipped\n  t2: t2 failed\n"), output)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := runOnTargets(context.Background(), cfg, NewPrinter(&buf), FormatJSON, fn)
+		assert.Error(t, err)
+
+		var report TargetsReport
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &report))
+		assert.Equal(t, TargetsSummary{Total: 3, OK: 2, Failed: 1}, report.Summary)
+		assert.Equal(t, []*TargetReport{
+			{Target: "t1", Result: StepOK, Report: "t1 report"},
+			{Target: "t2", Result: StepFailed, Error: "t2 failed"},
+			{Target: "t3", Result: StepOK, Report: "t3 report"},
+		}, report.Targets)
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		var buf bytes.Buffer
+		err := runOnTargets(ctx, cfg, NewPrinter(&buf), FormatText, fn)
+		require.Error(t, err)
+		assert.True(t, strings.HasPrefix(err.Error(), ErrInterrupted.Error()))
+		assert.Equal(t, "Summary: 3 targets, 0 ok, 0 failed, 3 skipped\n", buf.String())
+	})
+}
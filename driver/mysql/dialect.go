@@ -0,0 +1,55 @@
+package mysql
+
+import (
+	"errors"
+	"github.com/go-sql-driver/mysql"
+	"github.com/pasztorpisti/migrate/sqlsplit"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type dialect struct{}
+
+func (dialect) SplitStatements(query string) ([]sqlsplit.Statement, error) {
+	return sqlsplit.Split(query, sqlsplit.MySQL)
+}
+
+// Syntax errors end with "near '<the rest of the statement>' at line N".
+// The rest of the statement is truncated by the server.
+var nearAtLineRegex = regexp.MustCompile(`(?s)near '(.*)' at line (\d+)$`)
+
+// ErrorPosition extracts the line number from the message of syntax errors.
+// The column is found by searching the quoted part of the statement
+// in that line.
+func (dialect) ErrorPosition(err error, statement string) (line, column int) {
+	var myErr *mysql.MySQLError
+	if !errors.As(err, &myErr) {
+		return 0, 0
+	}
+	m := nearAtLineRegex.FindStringSubmatch(myErr.Message)
+	if m == nil {
+		return 0, 0
+	}
+	line, err = strconv.Atoi(m[2])
+	if err != nil || line < 1 {
+		return 0, 0
+	}
+
+	lines := strings.SplitAfter(statement, "\n")
+	if line > len(lines) {
+		return line, 0
+	}
+	near := m[1]
+	if i := strings.IndexByte(near, '\n'); i >= 0 {
+		near = near[:i]
+	}
+	if near == "" {
+		return line, 0
+	}
+	if i := strings.Index(lines[line-1], near); i >= 0 {
+		column = utf8.RuneCountInString(lines[line-1][:i]) + 1
+	}
+	return line, column
+}
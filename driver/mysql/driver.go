@@ -27,17 +27,24 @@ func (o driverFactory) NewDriver(params map[string]string) (core.Driver, error)
 		tableName = "migrations"
 	}
 
+	historyTableName, ok := takeParam("history_table")
+	if !ok || historyTableName == "" {
+		historyTableName = tableName + "_history"
+	}
+
 	if len(params) != 0 {
 		return nil, fmt.Errorf("unrecognised driver params: %q", params)
 	}
 
 	return &driver{
-		TableName: tableName,
+		TableName:        tableName,
+		HistoryTableName: historyTableName,
 	}, nil
 }
 
 type driver struct {
-	TableName string
+	TableName        string
+	HistoryTableName string
 }
 
 func (*driver) Open(dataSourceName string) (core.ClosableDB, error) {
@@ -45,6 +52,8 @@ func (*driver) Open(dataSourceName string) (core.ClosableDB, error) {
 	if err != nil {
 		return nil, err
 	}
+	// The migrations are split into statements but the ones with the
+	// nosplit flag are sent to the DB in one piece.
 	cfg.MultiStatements = true
 	cfg.ParseTime = true
 
@@ -56,5 +65,9 @@ func (*driver) Open(dataSourceName string) (core.ClosableDB, error) {
 }
 
 func (o *driver) NewMigrationDB() (core.MigrationDB, error) {
-	return newMigrationDB(o.TableName)
+	return newMigrationDB(o.TableName, o.HistoryTableName)
+}
+
+func (*driver) Dialect() core.Dialect {
+	return dialect{}
 }
@@ -1,6 +1,9 @@
 package mysql
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/pasztorpisti/migrate/core"
 	"strings"
@@ -9,21 +12,43 @@ import (
 
 type migrationDB struct {
 	tableName string
+	// rawTableName is the unquoted tableName.
+	rawTableName     string
+	historyTableName string
 }
 
-func newMigrationDB(tableName string) (core.MigrationDB, error) {
+func newMigrationDB(tableName, historyTableName string) (core.MigrationDB, error) {
 	// Table names can't be interpolated in SQL statements so we
 	// escape them manually and format them to the query strings.
-	if strings.ContainsRune(tableName, '`') {
-		return nil, fmt.Errorf("table name contains the forbidden backtick character: %q", tableName)
+	for _, name := range []string{tableName, historyTableName} {
+		if strings.ContainsRune(name, '`') {
+			return nil, fmt.Errorf("table name contains the forbidden backtick character: %q", name)
+		}
 	}
 	return &migrationDB{
-		tableName: "`" + tableName + "`",
+		tableName:        "`" + tableName + "`",
+		rawTableName:     tableName,
+		historyTableName: "`" + historyTableName + "`",
 	}, nil
 }
 
-func (o *migrationDB) GetForwardMigrations(q core.Querier) ([]*core.MigrationNameAndTime, error) {
-	rows, err := q.Query(`SELECT name, time FROM ` + o.tableName)
+func (o *migrationDB) GetForwardMigrations(ctx context.Context, q core.Querier) ([]*core.MigrationNameAndTime, error) {
+	// Tables created by older versions of this tool don't have the
+	// upgradeColumns until the first goto or hack command upgrades them.
+	columns, err := o.existingColumns(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("error inspecting the migrations table: %s", err)
+	}
+	selectList := []string{"name", "time"}
+	for _, c := range upgradeColumns {
+		if columns[c.Name] {
+			selectList = append(selectList, c.Name)
+		} else {
+			selectList = append(selectList, c.Default)
+		}
+	}
+
+	rows, err := q.QueryContext(ctx, `SELECT `+strings.Join(selectList, ", ")+` FROM `+o.tableName)
 	if err != nil {
 		return nil, fmt.Errorf("error querying froward migrated steps: %s", err)
 	}
@@ -32,9 +57,11 @@ func (o *migrationDB) GetForwardMigrations(q core.Querier) ([]*core.MigrationNam
 	var res []*core.MigrationNameAndTime
 	for rows.Next() {
 		var item core.MigrationNameAndTime
-		if err := rows.Scan(&item.Name, &item.Time); err != nil {
+		var durationMillis int64
+		if err := rows.Scan(&item.Name, &item.Time, &item.Checksum, &durationMillis, &item.AppliedBy, &item.Hostname, &item.Version); err != nil {
 			return nil, fmt.Errorf("error scanning forward migrations: %s", err)
 		}
+		item.Duration = time.Duration(durationMillis) * time.Millisecond
 		res = append(res, &item)
 	}
 	if err := rows.Err(); err != nil {
@@ -43,28 +70,92 @@ func (o *migrationDB) GetForwardMigrations(q core.Querier) ([]*core.MigrationNam
 	return res, nil
 }
 
+func (o *migrationDB) existingColumns(ctx context.Context, q core.Querier) (map[string]bool, error) {
+	rows, err := q.QueryContext(ctx, `SELECT COLUMN_NAME FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?`, o.rawTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
 const createTableQuery = `
 CREATE TABLE IF NOT EXISTS %s (
 	name VARCHAR(255) NOT NULL,
 	time DATETIME NOT NULL,
+	checksum VARCHAR(64) NOT NULL DEFAULT '',
+	duration_ms BIGINT NOT NULL DEFAULT 0,
+	applied_by VARCHAR(255) NOT NULL DEFAULT '',
+	hostname VARCHAR(255) NOT NULL DEFAULT '',
+	version VARCHAR(64) NOT NULL DEFAULT '',
 	PRIMARY KEY (name)
 );
 `
 
+// upgradeColumns are the columns that were added to the migrations table
+// after its first version. Default is used in place of the missing ones.
+var upgradeColumns = []struct {
+	Name, Definition, Default string
+}{
+	{"checksum", "VARCHAR(64) NOT NULL DEFAULT ''", "''"},
+	{"duration_ms", "BIGINT NOT NULL DEFAULT 0", "0"},
+	{"applied_by", "VARCHAR(255) NOT NULL DEFAULT ''", "''"},
+	{"hostname", "VARCHAR(255) NOT NULL DEFAULT ''", "''"},
+	{"version", "VARCHAR(64) NOT NULL DEFAULT ''", "''"},
+}
+
 func (o *migrationDB) CreateTable() (core.Step, error) {
-	return &core.SQLExecStep{
-		Query:    fmt.Sprintf(createTableQuery, o.tableName),
-		IsSystem: true,
+	return core.Steps{
+		&core.SQLExecStep{
+			Query:    fmt.Sprintf(createTableQuery, o.tableName),
+			IsSystem: true,
+		},
+		o.addColumnSteps(),
+		o.createHistoryTableStep(),
+	}, nil
+}
+
+func (o *migrationDB) UpgradeTable() (core.Step, error) {
+	return core.Steps{
+		o.addColumnSteps(),
+		o.createHistoryTableStep(),
 	}, nil
 }
 
-const forwardMigrateQuery = `INSERT INTO %s (name, time) VALUES (?, ?) ON DUPLICATE KEY UPDATE time=?;`
+// addColumnSteps upgrades tables created by older versions of this
+// tool that didn't have some of the upgradeColumns.
+func (o *migrationDB) addColumnSteps() core.Steps {
+	var steps core.Steps
+	for _, c := range upgradeColumns {
+		steps = append(steps, &core.SQLExecUnlessExistsStep{
+			ExistsQuery: `SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?`,
+			ExistsArgs:  []interface{}{o.rawTableName, c.Name},
+			Step: &core.SQLExecStep{
+				Query:    fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s;`, o.tableName, c.Name, c.Definition),
+				IsSystem: true,
+			},
+		})
+	}
+	return steps
+}
+
+const forwardMigrateQuery = `INSERT INTO %s (name, time, checksum, duration_ms, applied_by, hostname, version) VALUES (?, ?, ?, ?, ?, ?, ?)
+ON DUPLICATE KEY UPDATE time=VALUES(time), checksum=VALUES(checksum), duration_ms=VALUES(duration_ms), applied_by=VALUES(applied_by), hostname=VALUES(hostname), version=VALUES(version);`
 
-func (o *migrationDB) ForwardMigrate(migrationName string) (core.Step, error) {
+func (o *migrationDB) ForwardMigrate(record *core.MigrationRecord) (core.Step, error) {
 	now := time.Now().UTC()
 	return &core.SQLExecStep{
 		Query:    fmt.Sprintf(forwardMigrateQuery, o.tableName),
-		Args:     []interface{}{migrationName, now, now},
+		Args:     []interface{}{record.Name, now, record.Checksum, record.DurationMillisArg(), record.AppliedBy, record.Hostname, record.Version},
 		IsSystem: true,
 	}, nil
 }
@@ -76,3 +167,154 @@ func (o *migrationDB) BackwardMigrate(migrationName string) (core.Step, error) {
 		IsSystem: true,
 	}, nil
 }
+
+// createHistoryTableQuery creates the append-only history table that keeps
+// a record of every executed migration including the backward migrated and
+// failed ones. TEXT columns can't have a default value in mysql.
+const createHistoryTableQuery = `
+CREATE TABLE IF NOT EXISTS %s (
+	id BIGINT NOT NULL AUTO_INCREMENT,
+	time DATETIME NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	direction VARCHAR(16) NOT NULL,
+	command VARCHAR(16) NOT NULL,
+	result VARCHAR(16) NOT NULL,
+	error TEXT NOT NULL,
+	checksum VARCHAR(64) NOT NULL DEFAULT '',
+	duration_ms BIGINT NOT NULL DEFAULT 0,
+	applied_by VARCHAR(255) NOT NULL DEFAULT '',
+	hostname VARCHAR(255) NOT NULL DEFAULT '',
+	version VARCHAR(64) NOT NULL DEFAULT '',
+	PRIMARY KEY (id)
+);
+`
+
+const recordHistoryQuery = `INSERT INTO %s (time, name, direction, command, result, error, checksum, duration_ms, applied_by, hostname, version)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+
+func (o *migrationDB) createHistoryTableStep() core.Step {
+	return &core.SQLExecStep{
+		Query:    fmt.Sprintf(createHistoryTableQuery, o.historyTableName),
+		IsSystem: true,
+	}
+}
+
+func (o *migrationDB) RecordHistory(entry *core.HistoryEntry) (core.Step, error) {
+	return &core.SQLExecStep{
+		Query: fmt.Sprintf(recordHistoryQuery, o.historyTableName),
+		Args: []interface{}{entry.Time, entry.Migration, entry.Direction, entry.Command, entry.Result, entry.Error,
+			entry.Checksum, entry.DurationMillisArg(), entry.AppliedBy, entry.Hostname, entry.Version},
+		IsSystem: true,
+	}, nil
+}
+
+func (o *migrationDB) GetHistory(ctx context.Context, q core.Querier, filter *core.HistoryFilter) ([]*core.HistoryEntry, error) {
+	where, args := filter.SQLCondition(func(int) string { return "?" })
+	limit := ""
+	if filter.Limit > 0 {
+		limit = fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+	// The newest entries are queried first because of the limit.
+	rows, err := q.QueryContext(ctx, `SELECT id, time, name, direction, command, result, error, checksum, duration_ms, applied_by, hostname, version FROM `+
+		o.historyTableName+where+` ORDER BY id DESC`+limit, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying the history: %s", err)
+	}
+	defer rows.Close()
+
+	var res []*core.HistoryEntry
+	for rows.Next() {
+		var e core.HistoryEntry
+		var durationMillis int64
+		if err := rows.Scan(&e.ID, &e.Time, &e.Migration, &e.Direction, &e.Command, &e.Result, &e.Error,
+			&e.Checksum, &durationMillis, &e.AppliedBy, &e.Hostname, &e.Version); err != nil {
+			return nil, fmt.Errorf("error scanning the history: %s", err)
+		}
+		e.Duration = time.Duration(durationMillis) * time.Millisecond
+		res = append(res, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row error during the scanning of the history: %s", err)
+	}
+	core.ReverseHistory(res)
+	return res, nil
+}
+
+// StatementTimeout sets session variables because mysql doesn't have
+// transaction scoped variables. The set step saves the previous session
+// values to user variables and the reset step restores them after the
+// migration step.
+//
+// Note that max_execution_time affects only SELECT statements and
+// lock_wait_timeout applies to metadata locks (e.g.: ALTER TABLE waiting for
+// the table) and it accepts only whole seconds.
+func (o *migrationDB) StatementTimeout(timeout time.Duration) (set, reset core.Step, err error) {
+	ms := (timeout + time.Millisecond - 1) / time.Millisecond
+	seconds := (timeout + time.Second - 1) / time.Second
+	set = &core.SQLExecStep{
+		Query: fmt.Sprintf(`SET @migrate_max_execution_time = @@SESSION.max_execution_time, `+
+			`@migrate_lock_wait_timeout = @@SESSION.lock_wait_timeout, `+
+			`SESSION max_execution_time = %d, SESSION lock_wait_timeout = %d;`, int64(ms), int64(seconds)),
+		IsSystem: true,
+	}
+	reset = &core.SQLExecStep{
+		Query:    `SET SESSION max_execution_time = @migrate_max_execution_time, SESSION lock_wait_timeout = @migrate_lock_wait_timeout;`,
+		IsSystem: true,
+	}
+	return set, reset, nil
+}
+
+// Lock names are server-wide in mysql so the lock name is derived from the
+// name of the current database and the migrations table. The lock name is
+// hashed because it can't be longer than 64 characters.
+const lockName = `CONCAT('migrate_', SHA1(CONCAT(IFNULL(DATABASE(), ''), '.', ?)))`
+
+// Lock uses GET_LOCK in a dedicated transaction. The only reason for the
+// transaction is to keep the connection that holds the lock out of the
+// connection pool until we release the lock. Mysql releases the lock
+// automatically if the connection is lost.
+func (o *migrationDB) Lock(ctx context.Context, db core.DB, timeout time.Duration) (unlock func() error, err error) {
+	// The lock is bound to the lifetime of the transaction so it doesn't
+	// use ctx. Otherwise cancelling ctx would release the lock immediately.
+	tx, err := db.BeginTX(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// GET_LOCK accepts only whole seconds.
+	seconds := (timeout + time.Second - 1) / time.Second
+	rows, err := tx.QueryContext(ctx, `SELECT GET_LOCK(`+lockName+`, ?)`, o.rawTableName, int64(seconds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var locked sql.NullInt64
+	if rows.Next() {
+		if err := rows.Scan(&locked); err != nil {
+			return nil, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if !locked.Valid {
+		return nil, errors.New("GET_LOCK failed")
+	}
+	if locked.Int64 != 1 {
+		return nil, core.ErrLocked
+	}
+
+	return func() error {
+		_, err := tx.ExecContext(context.Background(), `DO RELEASE_LOCK(`+lockName+`)`, o.rawTableName)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		return tx.Rollback()
+	}, nil
+}
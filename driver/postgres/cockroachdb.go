@@ -0,0 +1,85 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/pasztorpisti/migrate/core"
+	"time"
+)
+
+// cockroachMigrationDB is the MigrationDB of the cockroachdb driver.
+// Cockroachdb speaks the postgres wire protocol and understands the SQL
+// of the postgres driver with a few exceptions.
+type cockroachMigrationDB struct {
+	*migrationDB
+	// lockTableName is the quoted name of the table that contains the
+	// row locked by Lock.
+	lockTableName string
+}
+
+func newCockroachMigrationDB(mdb *migrationDB, lockTableName string) *cockroachMigrationDB {
+	// Sequences are a contention point in cockroachdb. Its unique_rowid()
+	// generates unique and roughly ordered IDs without coordination.
+	mdb.historyIDType = "INT8 DEFAULT unique_rowid()"
+	lockTableName = pq.QuoteIdentifier(lockTableName)
+	if mdb.schema != "" {
+		lockTableName = mdb.schema + "." + lockTableName
+	}
+	return &cockroachMigrationDB{
+		migrationDB:   mdb,
+		lockTableName: lockTableName,
+	}
+}
+
+// SeparateSystemTransaction returns true because cockroachdb executes the
+// schema changes of a transaction asynchronously during the commit. A
+// schema change that fails at that point can leave behind the writes of
+// the same transaction so the migrations table is updated only after the
+// transaction of the migration has been committed.
+func (o *cockroachMigrationDB) SeparateSystemTransaction() bool {
+	return true
+}
+
+// Lock locks the row of a lock table with SELECT FOR UPDATE because
+// cockroachdb doesn't support the advisory locks of postgres.
+func (o *cockroachMigrationDB) Lock(ctx context.Context, db core.DB, timeout time.Duration) (unlock func() error, err error) {
+	_, err = db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (id INT8 PRIMARY KEY)`, o.lockTableName))
+	if err != nil {
+		return nil, fmt.Errorf("error creating lock table: %s", err)
+	}
+	_, err = db.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (id) VALUES (1) ON CONFLICT (id) DO NOTHING`, o.lockTableName))
+	if err != nil {
+		return nil, fmt.Errorf("error creating lock row: %s", err)
+	}
+
+	// The lock is bound to the lifetime of the transaction so it doesn't
+	// use ctx. Otherwise cancelling ctx would release the lock immediately.
+	tx, err := db.BeginTX(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	query := fmt.Sprintf(`SELECT id FROM %s WHERE id = 1 FOR UPDATE`, o.lockTableName)
+	if timeout <= 0 {
+		query += ` NOWAIT`
+	} else {
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(`SET LOCAL lock_timeout = %d`, timeoutMillis(timeout)))
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, err = tx.ExecContext(ctx, query)
+	if e, ok := err.(*pq.Error); ok && e.Code == lockNotAvailable {
+		return nil, core.ErrLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tx.Rollback, nil
+}
@@ -0,0 +1,28 @@
+package postgres
+
+import (
+	"errors"
+	"github.com/lib/pq"
+	"github.com/pasztorpisti/migrate/sqlsplit"
+	"strconv"
+)
+
+type dialect struct{}
+
+func (dialect) SplitStatements(query string) ([]sqlsplit.Statement, error) {
+	return sqlsplit.Split(query, sqlsplit.Postgres)
+}
+
+// ErrorPosition uses the position field of the error response of the server.
+// It is a 1-based character index into the statement.
+func (dialect) ErrorPosition(err error, statement string) (line, column int) {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Position == "" {
+		return 0, 0
+	}
+	pos, err := strconv.Atoi(pqErr.Position)
+	if err != nil {
+		return 0, 0
+	}
+	return sqlsplit.LineColumn(statement, pos)
+}
@@ -5,13 +5,20 @@ import (
 	"fmt"
 	_ "github.com/lib/pq"
 	"github.com/pasztorpisti/migrate/core"
+	"net/url"
+	"strings"
 )
 
 func init() {
 	core.RegisterDriverFactory("postgres", driverFactory{})
+	core.RegisterDriverFactory("cockroachdb", driverFactory{Cockroach: true})
 }
 
-type driverFactory struct{}
+type driverFactory struct {
+	// Cockroach enables the cockroachdb specific behaviour.
+	// See cockroachMigrationDB.
+	Cockroach bool
+}
 
 func (o driverFactory) NewDriver(params map[string]string) (core.Driver, error) {
 	takeParam := func(key string) (string, bool) {
@@ -27,20 +34,48 @@ func (o driverFactory) NewDriver(params map[string]string) (core.Driver, error)
 		tableName = "migrations"
 	}
 
+	historyTableName, ok := takeParam("history_table")
+	if !ok || historyTableName == "" {
+		historyTableName = tableName + "_history"
+	}
+
+	schema, _ := takeParam("migrations_schema")
+	searchPath, _ := takeParam("search_path")
+
 	if len(params) != 0 {
 		return nil, fmt.Errorf("unrecognised driver params: %q", params)
 	}
 
 	return &driver{
-		TableName: tableName,
+		Schema:           schema,
+		TableName:        tableName,
+		HistoryTableName: historyTableName,
+		SearchPath:       searchPath,
+		Cockroach:        o.Cockroach,
 	}, nil
 }
 
 type driver struct {
-	TableName string
+	// Schema is optional. The migrations and history tables are
+	// created in this schema if it isn't empty.
+	Schema           string
+	TableName        string
+	HistoryTableName string
+	// SearchPath is optional. It is set as the search_path of every
+	// connection if it isn't empty.
+	SearchPath string
+	Cockroach  bool
 }
 
-func (*driver) Open(dataSourceName string) (core.ClosableDB, error) {
+func (o *driver) Open(dataSourceName string) (core.ClosableDB, error) {
+	if o.SearchPath != "" {
+		var err error
+		dataSourceName, err = withRuntimeParam(dataSourceName, "search_path", o.SearchPath)
+		if err != nil {
+			return nil, fmt.Errorf("error setting search_path in data_source: %s", err)
+		}
+	}
+
 	db, err := sql.Open("postgres", dataSourceName)
 	if err != nil {
 		return nil, fmt.Errorf("error opening postgres connection: %s", err)
@@ -49,5 +84,36 @@ func (*driver) Open(dataSourceName string) (core.ClosableDB, error) {
 }
 
 func (o *driver) NewMigrationDB() (core.MigrationDB, error) {
-	return newMigrationDB(o.TableName)
+	mdb, err := newMigrationDB(o.Schema, o.TableName, o.HistoryTableName)
+	if err != nil {
+		return nil, err
+	}
+	if o.Cockroach {
+		return newCockroachMigrationDB(mdb, o.TableName+"_lock"), nil
+	}
+	return mdb, nil
+}
+
+func (*driver) Dialect() core.Dialect {
+	return dialect{}
+}
+
+// withRuntimeParam adds a run-time parameter to a data source in URL or
+// key/value format. The pq driver sends the unrecognised parameters of the
+// data source to the server that applies them to the connection.
+func withRuntimeParam(dataSourceName, key, value string) (string, error) {
+	if strings.HasPrefix(dataSourceName, "postgres://") || strings.HasPrefix(dataSourceName, "postgresql://") {
+		u, err := url.Parse(dataSourceName)
+		if err != nil {
+			return "", err
+		}
+		q := u.Query()
+		q.Set(key, value)
+		u.RawQuery = q.Encode()
+		return u.String(), nil
+	}
+
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `'`, `\'`, -1)
+	return strings.TrimSpace(dataSourceName + " " + key + "='" + value + "'"), nil
 }
@@ -0,0 +1,69 @@
+package postgres
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestWithRuntimeParam(t *testing.T) {
+	tests := []*struct {
+		name   string
+		input  string
+		value  string
+		output string
+	}{
+		{
+			"url",
+			"postgres://steve@localhost:5432/postgres?sslmode=disable",
+			"ops, public",
+			"postgres://steve@localhost:5432/postgres?search_path=ops%2C+public&sslmode=disable",
+		},
+		{
+			"key/value",
+			"host=localhost dbname=postgres",
+			"ops, public",
+			"host=localhost dbname=postgres search_path='ops, public'",
+		},
+		{
+			"key/value with quotes",
+			"",
+			`"it's", \`,
+			`search_path='"it\'s", \\'`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := withRuntimeParam(test.input, "search_path", test.value)
+			require.NoError(t, err)
+			assert.Equal(t, test.output, output)
+		})
+	}
+}
+
+func TestNewMigrationDB(t *testing.T) {
+	mdb, err := newMigrationDB("", "migrations", `my"history`)
+	require.NoError(t, err)
+	assert.Equal(t, `"migrations"`, mdb.tableName)
+	assert.Equal(t, `"my""history"`, mdb.historyTableName)
+
+	mdb, err = newMigrationDB("ops", "migrations", "migrations_history")
+	require.NoError(t, err)
+	assert.Equal(t, `"ops"."migrations"`, mdb.tableName)
+	assert.Equal(t, `"ops"."migrations_history"`, mdb.historyTableName)
+	assert.NotEqual(t, advisoryLockKey("migrations"), mdb.lockKey)
+
+	_, err = newMigrationDB("ops", "migrations\x00", "migrations_history")
+	assert.Error(t, err)
+}
+
+func TestNewCockroachMigrationDB(t *testing.T) {
+	mdb, err := newMigrationDB("", "migrations", "migrations_history")
+	require.NoError(t, err)
+	assert.Equal(t, `"migrations_lock"`, newCockroachMigrationDB(mdb, "migrations_lock").lockTableName)
+
+	mdb, err = newMigrationDB("ops", "migrations", "migrations_history")
+	require.NoError(t, err)
+	assert.Equal(t, `"ops"."migrations_lock"`, newCockroachMigrationDB(mdb, "migrations_lock").lockTableName)
+}
@@ -1,29 +1,80 @@
 package postgres
 
 import (
+	"context"
 	"fmt"
+	"github.com/lib/pq"
 	"github.com/pasztorpisti/migrate/core"
+	"hash/fnv"
+	"strconv"
 	"strings"
 	"time"
 )
 
 type migrationDB struct {
-	tableName string
+	// schema is the quoted name of the schema of the migrations and history
+	// tables or an empty string if the tables are unqualified.
+	schema           string
+	tableName        string
+	historyTableName string
+	// historyIDType is the type of the id column of the history table.
+	historyIDType string
+	lockKey       int64
 }
 
-func newMigrationDB(tableName string) (core.MigrationDB, error) {
+// newMigrationDB creates a migrationDB that keeps its tables in the given
+// schema. An empty schema leaves the table names unqualified and the
+// search_path decides their schema.
+func newMigrationDB(schema, tableName, historyTableName string) (*migrationDB, error) {
+	for _, name := range []string{schema, tableName, historyTableName} {
+		if strings.ContainsRune(name, 0) {
+			return nil, fmt.Errorf("name contains the forbidden NUL character: %q", name)
+		}
+	}
+
 	// Table names can't be interpolated in SQL statements so we
 	// escape them manually and format them to the query strings.
-	if strings.ContainsRune(tableName, '"') {
-		return nil, fmt.Errorf("table name contains the forbidden quotation mark character: %q", tableName)
+	o := &migrationDB{
+		tableName:        pq.QuoteIdentifier(tableName),
+		historyTableName: pq.QuoteIdentifier(historyTableName),
+		historyIDType:    "BIGSERIAL",
+		lockKey:          advisoryLockKey(tableName),
 	}
-	return &migrationDB{
-		tableName: `"` + tableName + `"`,
-	}, nil
+	if schema != "" {
+		o.schema = pq.QuoteIdentifier(schema)
+		o.tableName = o.schema + "." + o.tableName
+		o.historyTableName = o.schema + "." + o.historyTableName
+		o.lockKey = advisoryLockKey(schema + "." + tableName)
+	}
+	return o, nil
 }
 
-func (o *migrationDB) GetForwardMigrations(q core.Querier) ([]*core.MigrationNameAndTime, error) {
-	rows, err := q.Query(`SELECT name, time FROM ` + o.tableName)
+// advisoryLockKey derives the advisory lock ID from the name of the migrations
+// table so that independent migration sets living in the same DB with
+// different migrations tables don't block each other.
+func advisoryLockKey(tableName string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("migrate:" + tableName))
+	return int64(h.Sum64())
+}
+
+func (o *migrationDB) GetForwardMigrations(ctx context.Context, q core.Querier) ([]*core.MigrationNameAndTime, error) {
+	// Tables created by older versions of this tool don't have the
+	// upgradeColumns until the first goto or hack command upgrades them.
+	columns, err := o.existingColumns(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("error inspecting the migrations table: %s", err)
+	}
+	selectList := []string{"name", "time"}
+	for _, c := range upgradeColumns {
+		if columns[c.Name] {
+			selectList = append(selectList, c.Name)
+		} else {
+			selectList = append(selectList, c.Default)
+		}
+	}
+
+	rows, err := q.QueryContext(ctx, `SELECT `+strings.Join(selectList, ", ")+` FROM `+o.tableName)
 	if err != nil {
 		return nil, fmt.Errorf("error querying froward migrated steps: %s", err)
 	}
@@ -32,9 +83,11 @@ func (o *migrationDB) GetForwardMigrations(q core.Querier) ([]*core.MigrationNam
 	var res []*core.MigrationNameAndTime
 	for rows.Next() {
 		var item core.MigrationNameAndTime
-		if err := rows.Scan(&item.Name, &item.Time); err != nil {
+		var durationMillis int64
+		if err := rows.Scan(&item.Name, &item.Time, &item.Checksum, &durationMillis, &item.AppliedBy, &item.Hostname, &item.Version); err != nil {
 			return nil, fmt.Errorf("error scanning forward migrations: %s", err)
 		}
+		item.Duration = time.Duration(durationMillis) * time.Millisecond
 		res = append(res, &item)
 	}
 	if err := rows.Err(); err != nil {
@@ -43,28 +96,105 @@ func (o *migrationDB) GetForwardMigrations(q core.Querier) ([]*core.MigrationNam
 	return res, nil
 }
 
+func (o *migrationDB) existingColumns(ctx context.Context, q core.Querier) (map[string]bool, error) {
+	rows, err := q.QueryContext(ctx, `SELECT attname FROM pg_attribute WHERE attrelid = $1::regclass AND attnum > 0 AND NOT attisdropped`, o.tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
 const createTableQuery = `
 CREATE TABLE IF NOT EXISTS %s (
 	name TEXT NOT NULL,
 	time TIMESTAMP NOT NULL,
+	checksum TEXT NOT NULL DEFAULT '',
+	duration_ms BIGINT NOT NULL DEFAULT 0,
+	applied_by TEXT NOT NULL DEFAULT '',
+	hostname TEXT NOT NULL DEFAULT '',
+	version TEXT NOT NULL DEFAULT '',
 	PRIMARY KEY (name)
 );
 `
 
+// upgradeColumns are the columns that were added to the migrations table
+// after its first version. Default is used in place of the missing ones.
+var upgradeColumns = []struct {
+	Name, Definition, Default string
+}{
+	{"checksum", "TEXT NOT NULL DEFAULT ''", "''"},
+	{"duration_ms", "BIGINT NOT NULL DEFAULT 0", "0"},
+	{"applied_by", "TEXT NOT NULL DEFAULT ''", "''"},
+	{"hostname", "TEXT NOT NULL DEFAULT ''", "''"},
+	{"version", "TEXT NOT NULL DEFAULT ''", "''"},
+}
+
 func (o *migrationDB) CreateTable() (core.Step, error) {
+	return core.Steps{
+		o.createSchemaStep(),
+		&core.SQLExecStep{
+			Query:    fmt.Sprintf(createTableQuery, o.tableName),
+			IsSystem: true,
+		},
+		o.addColumnSteps(),
+		o.createHistoryTableStep(),
+	}, nil
+}
+
+// createSchemaStep creates the schema of the tables if they
+// are schema-qualified.
+func (o *migrationDB) createSchemaStep() core.Step {
+	if o.schema == "" {
+		return core.Steps{}
+	}
 	return &core.SQLExecStep{
-		Query:    fmt.Sprintf(createTableQuery, o.tableName),
+		Query:    fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS %s;`, o.schema),
 		IsSystem: true,
+	}
+}
+
+func (o *migrationDB) UpgradeTable() (core.Step, error) {
+	return core.Steps{
+		o.addColumnSteps(),
+		o.createHistoryTableStep(),
 	}, nil
 }
 
-const forwardMigrateQuery = `INSERT INTO %s (name, time) VALUES ($1, $2) ON CONFLICT (name) DO UPDATE SET time=$2;`
+// addColumnSteps upgrades tables created by older versions of this
+// tool that didn't have some of the upgradeColumns.
+func (o *migrationDB) addColumnSteps() core.Steps {
+	var steps core.Steps
+	for _, c := range upgradeColumns {
+		steps = append(steps, &core.SQLExecUnlessExistsStep{
+			ExistsQuery: `SELECT COUNT(*) FROM pg_attribute WHERE attrelid = $1::regclass AND attname = $2 AND NOT attisdropped`,
+			ExistsArgs:  []interface{}{o.tableName, c.Name},
+			Step: &core.SQLExecStep{
+				Query:    fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s;`, o.tableName, c.Name, c.Definition),
+				IsSystem: true,
+			},
+		})
+	}
+	return steps
+}
+
+const forwardMigrateQuery = `INSERT INTO %s (name, time, checksum, duration_ms, applied_by, hostname, version) VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (name) DO UPDATE SET time=$2, checksum=$3, duration_ms=$4, applied_by=$5, hostname=$6, version=$7;`
 
-func (o *migrationDB) ForwardMigrate(migrationName string) (core.Step, error) {
+func (o *migrationDB) ForwardMigrate(record *core.MigrationRecord) (core.Step, error) {
 	now := time.Now().UTC()
 	return &core.SQLExecStep{
 		Query:    fmt.Sprintf(forwardMigrateQuery, o.tableName),
-		Args:     []interface{}{migrationName, now},
+		Args:     []interface{}{record.Name, now, record.Checksum, record.DurationMillisArg(), record.AppliedBy, record.Hostname, record.Version},
 		IsSystem: true,
 	}, nil
 }
@@ -76,3 +206,158 @@ func (o *migrationDB) BackwardMigrate(migrationName string) (core.Step, error) {
 		IsSystem: true,
 	}, nil
 }
+
+// createHistoryTableQuery creates the append-only history table that keeps
+// a record of every executed migration including the backward migrated and
+// failed ones.
+const createHistoryTableQuery = `
+CREATE TABLE IF NOT EXISTS %s (
+	id %s NOT NULL,
+	time TIMESTAMP NOT NULL,
+	name TEXT NOT NULL,
+	direction TEXT NOT NULL,
+	command TEXT NOT NULL,
+	result TEXT NOT NULL,
+	error TEXT NOT NULL DEFAULT '',
+	checksum TEXT NOT NULL DEFAULT '',
+	duration_ms BIGINT NOT NULL DEFAULT 0,
+	applied_by TEXT NOT NULL DEFAULT '',
+	hostname TEXT NOT NULL DEFAULT '',
+	version TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (id)
+);
+`
+
+const recordHistoryQuery = `INSERT INTO %s (time, name, direction, command, result, error, checksum, duration_ms, applied_by, hostname, version)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11);`
+
+func (o *migrationDB) createHistoryTableStep() core.Step {
+	return &core.SQLExecStep{
+		Query:    fmt.Sprintf(createHistoryTableQuery, o.historyTableName, o.historyIDType),
+		IsSystem: true,
+	}
+}
+
+func (o *migrationDB) RecordHistory(entry *core.HistoryEntry) (core.Step, error) {
+	return &core.SQLExecStep{
+		Query: fmt.Sprintf(recordHistoryQuery, o.historyTableName),
+		Args: []interface{}{entry.Time, entry.Migration, entry.Direction, entry.Command, entry.Result, entry.Error,
+			entry.Checksum, entry.DurationMillisArg(), entry.AppliedBy, entry.Hostname, entry.Version},
+		IsSystem: true,
+	}, nil
+}
+
+func (o *migrationDB) GetHistory(ctx context.Context, q core.Querier, filter *core.HistoryFilter) ([]*core.HistoryEntry, error) {
+	where, args := filter.SQLCondition(func(n int) string { return "$" + strconv.Itoa(n) })
+	limit := ""
+	if filter.Limit > 0 {
+		limit = fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+	// The newest entries are queried first because of the limit.
+	rows, err := q.QueryContext(ctx, `SELECT id, time, name, direction, command, result, error, checksum, duration_ms, applied_by, hostname, version FROM `+
+		o.historyTableName+where+` ORDER BY id DESC`+limit, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying the history: %s", err)
+	}
+	defer rows.Close()
+
+	var res []*core.HistoryEntry
+	for rows.Next() {
+		var e core.HistoryEntry
+		var durationMillis int64
+		if err := rows.Scan(&e.ID, &e.Time, &e.Migration, &e.Direction, &e.Command, &e.Result, &e.Error,
+			&e.Checksum, &durationMillis, &e.AppliedBy, &e.Hostname, &e.Version); err != nil {
+			return nil, fmt.Errorf("error scanning the history: %s", err)
+		}
+		e.Duration = time.Duration(durationMillis) * time.Millisecond
+		res = append(res, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row error during the scanning of the history: %s", err)
+	}
+	core.ReverseHistory(res)
+	return res, nil
+}
+
+// StatementTimeout uses SET LOCAL so the timeouts are reset automatically at
+// the end of the transaction.
+func (o *migrationDB) StatementTimeout(timeout time.Duration) (set, reset core.Step, err error) {
+	ms := timeoutMillis(timeout)
+	set = core.Steps{
+		&core.SQLExecStep{
+			Query:    fmt.Sprintf(`SET LOCAL statement_timeout = %d;`, ms),
+			IsSystem: true,
+		},
+		&core.SQLExecStep{
+			Query:    fmt.Sprintf(`SET LOCAL lock_timeout = %d;`, ms),
+			IsSystem: true,
+		},
+	}
+	return set, core.Steps{}, nil
+}
+
+// timeoutMillis converts timeout to the milliseconds expected by the
+// timeout settings of postgres. A zero timeout would mean infinite wait in
+// postgres so the result is at least 1 millisecond.
+func timeoutMillis(timeout time.Duration) int64 {
+	ms := int64((timeout + time.Millisecond - 1) / time.Millisecond)
+	if ms < 1 {
+		ms = 1
+	}
+	return ms
+}
+
+// lockNotAvailable is the SQLSTATE of the error we get when
+// lock_timeout expires while waiting for the advisory lock.
+const lockNotAvailable = "55P03"
+
+// Lock holds a transaction level advisory lock in a dedicated transaction.
+// Rolling back the transaction releases the lock. This way the lock is
+// released by the server even if we lose the connection.
+func (o *migrationDB) Lock(ctx context.Context, db core.DB, timeout time.Duration) (unlock func() error, err error) {
+	// The lock is bound to the lifetime of the transaction so it doesn't
+	// use ctx. Otherwise cancelling ctx would release the lock immediately.
+	tx, err := db.BeginTX(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if timeout <= 0 {
+		rows, err := tx.QueryContext(ctx, `SELECT pg_try_advisory_xact_lock($1)`, o.lockKey)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		var locked bool
+		if rows.Next() {
+			if err := rows.Scan(&locked); err != nil {
+				return nil, err
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		if !locked {
+			return nil, core.ErrLocked
+		}
+		return tx.Rollback, nil
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`SET LOCAL lock_timeout = %d`, timeoutMillis(timeout)))
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, o.lockKey)
+	if e, ok := err.(*pq.Error); ok && e.Code == lockNotAvailable {
+		return nil, core.ErrLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tx.Rollback, nil
+}
@@ -0,0 +1,17 @@
+package sqlite
+
+import (
+	"github.com/pasztorpisti/migrate/sqlsplit"
+)
+
+type dialect struct{}
+
+func (dialect) SplitStatements(query string) ([]sqlsplit.Statement, error) {
+	return sqlsplit.Split(query, sqlsplit.SQLite)
+}
+
+// ErrorPosition returns zeros because sqlite doesn't report
+// the position of errors.
+func (dialect) ErrorPosition(err error, statement string) (line, column int) {
+	return 0, 0
+}
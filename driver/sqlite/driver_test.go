@@ -0,0 +1,37 @@
+package sqlite
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestDriver_Open_Memory(t *testing.T) {
+	ctx := context.Background()
+	db, err := (&driver{}).Open(":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.ExecContext(ctx, "CREATE TABLE t (id INTEGER)")
+	require.NoError(t, err)
+
+	tx, err := db.BeginTX(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	// The transaction holds the only connection. A second connection
+	// would have its own empty database without table t.
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = db.ExecContext(waitCtx, "INSERT INTO t VALUES (1)")
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO t VALUES (2)")
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	_, err = db.ExecContext(ctx, "INSERT INTO t VALUES (3)")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,252 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"github.com/pasztorpisti/migrate/core"
+	"strings"
+	"time"
+)
+
+type migrationDB struct {
+	tableName string
+	// rawTableName is the unquoted tableName.
+	rawTableName     string
+	historyTableName string
+}
+
+func newMigrationDB(tableName, historyTableName string) (core.MigrationDB, error) {
+	// Table names can't be interpolated in SQL statements so we
+	// escape them manually and format them to the query strings.
+	for _, name := range []string{tableName, historyTableName} {
+		if strings.ContainsRune(name, '"') {
+			return nil, fmt.Errorf("table name contains the forbidden quotation mark character: %q", name)
+		}
+	}
+	return &migrationDB{
+		tableName:        `"` + tableName + `"`,
+		rawTableName:     tableName,
+		historyTableName: `"` + historyTableName + `"`,
+	}, nil
+}
+
+func (o *migrationDB) GetForwardMigrations(ctx context.Context, q core.Querier) ([]*core.MigrationNameAndTime, error) {
+	// Tables created by older versions of this tool don't have the
+	// upgradeColumns until the first goto or hack command upgrades them.
+	columns, err := o.existingColumns(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("error inspecting the migrations table: %s", err)
+	}
+	selectList := []string{"name", "time"}
+	for _, c := range upgradeColumns {
+		if columns[c.Name] {
+			selectList = append(selectList, c.Name)
+		} else {
+			selectList = append(selectList, c.Default)
+		}
+	}
+
+	rows, err := q.QueryContext(ctx, `SELECT `+strings.Join(selectList, ", ")+` FROM `+o.tableName)
+	if err != nil {
+		return nil, fmt.Errorf("error querying froward migrated steps: %s", err)
+	}
+	defer rows.Close()
+
+	var res []*core.MigrationNameAndTime
+	for rows.Next() {
+		var item core.MigrationNameAndTime
+		var durationMillis int64
+		if err := rows.Scan(&item.Name, &item.Time, &item.Checksum, &durationMillis, &item.AppliedBy, &item.Hostname, &item.Version); err != nil {
+			return nil, fmt.Errorf("error scanning forward migrations: %s", err)
+		}
+		item.Duration = time.Duration(durationMillis) * time.Millisecond
+		res = append(res, &item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row error durig the scanning of forward migrations: %s", err)
+	}
+	return res, nil
+}
+
+func (o *migrationDB) existingColumns(ctx context.Context, q core.Querier) (map[string]bool, error) {
+	rows, err := q.QueryContext(ctx, `SELECT name FROM pragma_table_info(?)`, o.rawTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
+// The go-sqlite3 driver scans columns declared as TIMESTAMP into time.Time.
+const createTableQuery = `
+CREATE TABLE IF NOT EXISTS %s (
+	name TEXT NOT NULL,
+	time TIMESTAMP NOT NULL,
+	checksum TEXT NOT NULL DEFAULT '',
+	duration_ms INTEGER NOT NULL DEFAULT 0,
+	applied_by TEXT NOT NULL DEFAULT '',
+	hostname TEXT NOT NULL DEFAULT '',
+	version TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (name)
+);
+`
+
+// upgradeColumns are the columns that were added to the migrations table
+// after its first version. Default is used in place of the missing ones.
+var upgradeColumns = []struct {
+	Name, Definition, Default string
+}{
+	{"checksum", "TEXT NOT NULL DEFAULT ''", "''"},
+	{"duration_ms", "INTEGER NOT NULL DEFAULT 0", "0"},
+	{"applied_by", "TEXT NOT NULL DEFAULT ''", "''"},
+	{"hostname", "TEXT NOT NULL DEFAULT ''", "''"},
+	{"version", "TEXT NOT NULL DEFAULT ''", "''"},
+}
+
+func (o *migrationDB) CreateTable() (core.Step, error) {
+	return core.Steps{
+		&core.SQLExecStep{
+			Query:    fmt.Sprintf(createTableQuery, o.tableName),
+			IsSystem: true,
+		},
+		o.addColumnSteps(),
+		o.createHistoryTableStep(),
+	}, nil
+}
+
+func (o *migrationDB) UpgradeTable() (core.Step, error) {
+	return core.Steps{
+		o.addColumnSteps(),
+		o.createHistoryTableStep(),
+	}, nil
+}
+
+// addColumnSteps upgrades tables created by older versions of this
+// tool that didn't have some of the upgradeColumns.
+func (o *migrationDB) addColumnSteps() core.Steps {
+	var steps core.Steps
+	for _, c := range upgradeColumns {
+		steps = append(steps, &core.SQLExecUnlessExistsStep{
+			ExistsQuery: `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`,
+			ExistsArgs:  []interface{}{o.rawTableName, c.Name},
+			Step: &core.SQLExecStep{
+				Query:    fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s;`, o.tableName, c.Name, c.Definition),
+				IsSystem: true,
+			},
+		})
+	}
+	return steps
+}
+
+const forwardMigrateQuery = `INSERT OR REPLACE INTO %s (name, time, checksum, duration_ms, applied_by, hostname, version) VALUES (?, ?, ?, ?, ?, ?, ?);`
+
+func (o *migrationDB) ForwardMigrate(record *core.MigrationRecord) (core.Step, error) {
+	now := time.Now().UTC()
+	return &core.SQLExecStep{
+		Query:    fmt.Sprintf(forwardMigrateQuery, o.tableName),
+		Args:     []interface{}{record.Name, now, record.Checksum, record.DurationMillisArg(), record.AppliedBy, record.Hostname, record.Version},
+		IsSystem: true,
+	}, nil
+}
+
+func (o *migrationDB) BackwardMigrate(migrationName string) (core.Step, error) {
+	return &core.SQLExecStep{
+		Query:    fmt.Sprintf(`DELETE FROM %s WHERE name = ?;`, o.tableName),
+		Args:     []interface{}{migrationName},
+		IsSystem: true,
+	}, nil
+}
+
+// createHistoryTableQuery creates the append-only history table that keeps
+// a record of every executed migration including the backward migrated and
+// failed ones.
+const createHistoryTableQuery = `
+CREATE TABLE IF NOT EXISTS %s (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	time TIMESTAMP NOT NULL,
+	name TEXT NOT NULL,
+	direction TEXT NOT NULL,
+	command TEXT NOT NULL,
+	result TEXT NOT NULL,
+	error TEXT NOT NULL DEFAULT '',
+	checksum TEXT NOT NULL DEFAULT '',
+	duration_ms INTEGER NOT NULL DEFAULT 0,
+	applied_by TEXT NOT NULL DEFAULT '',
+	hostname TEXT NOT NULL DEFAULT '',
+	version TEXT NOT NULL DEFAULT ''
+);
+`
+
+const recordHistoryQuery = `INSERT INTO %s (time, name, direction, command, result, error, checksum, duration_ms, applied_by, hostname, version)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+
+func (o *migrationDB) createHistoryTableStep() core.Step {
+	return &core.SQLExecStep{
+		Query:    fmt.Sprintf(createHistoryTableQuery, o.historyTableName),
+		IsSystem: true,
+	}
+}
+
+func (o *migrationDB) RecordHistory(entry *core.HistoryEntry) (core.Step, error) {
+	return &core.SQLExecStep{
+		Query: fmt.Sprintf(recordHistoryQuery, o.historyTableName),
+		Args: []interface{}{entry.Time, entry.Migration, entry.Direction, entry.Command, entry.Result, entry.Error,
+			entry.Checksum, entry.DurationMillisArg(), entry.AppliedBy, entry.Hostname, entry.Version},
+		IsSystem: true,
+	}, nil
+}
+
+func (o *migrationDB) GetHistory(ctx context.Context, q core.Querier, filter *core.HistoryFilter) ([]*core.HistoryEntry, error) {
+	where, args := filter.SQLCondition(func(int) string { return "?" })
+	limit := ""
+	if filter.Limit > 0 {
+		limit = fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+	// The newest entries are queried first because of the limit.
+	rows, err := q.QueryContext(ctx, `SELECT id, time, name, direction, command, result, error, checksum, duration_ms, applied_by, hostname, version FROM `+
+		o.historyTableName+where+` ORDER BY id DESC`+limit, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying the history: %s", err)
+	}
+	defer rows.Close()
+
+	var res []*core.HistoryEntry
+	for rows.Next() {
+		var e core.HistoryEntry
+		var durationMillis int64
+		if err := rows.Scan(&e.ID, &e.Time, &e.Migration, &e.Direction, &e.Command, &e.Result, &e.Error,
+			&e.Checksum, &durationMillis, &e.AppliedBy, &e.Hostname, &e.Version); err != nil {
+			return nil, fmt.Errorf("error scanning the history: %s", err)
+		}
+		e.Duration = time.Duration(durationMillis) * time.Millisecond
+		res = append(res, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row error during the scanning of the history: %s", err)
+	}
+	core.ReverseHistory(res)
+	return res, nil
+}
+
+// StatementTimeout returns no-op steps because sqlite doesn't have server-side
+// timeouts. The goto command still cancels the migration when its timeout
+// expires.
+func (o *migrationDB) StatementTimeout(timeout time.Duration) (set, reset core.Step, err error) {
+	return core.Steps{}, core.Steps{}, nil
+}
+
+// Lock is a no-op because sqlite doesn't have advisory locks. Holding a
+// write transaction would block our own migration steps that are executed
+// on other connections of the pool.
+func (o *migrationDB) Lock(ctx context.Context, db core.DB, timeout time.Duration) (unlock func() error, err error) {
+	return func() error { return nil }, nil
+}
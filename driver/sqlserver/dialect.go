@@ -0,0 +1,26 @@
+package sqlserver
+
+import (
+	"errors"
+	mssql "github.com/denisenkom/go-mssqldb"
+	"github.com/pasztorpisti/migrate/sqlsplit"
+)
+
+type dialect struct{}
+
+// SplitStatements splits the query into batches at the GO lines. The
+// statements of a batch are sent to the server together because some of
+// them (e.g.: CREATE PROCEDURE) have to be the only statement in the batch.
+func (dialect) SplitStatements(query string) ([]sqlsplit.Statement, error) {
+	return sqlsplit.Split(query, sqlsplit.SQLServer)
+}
+
+// ErrorPosition returns the line number of the error in the batch.
+// SQL Server doesn't report the column.
+func (dialect) ErrorPosition(err error, statement string) (line, column int) {
+	var msErr mssql.Error
+	if !errors.As(err, &msErr) || msErr.LineNo < 1 {
+		return 0, 0
+	}
+	return int(msErr.LineNo), 0
+}
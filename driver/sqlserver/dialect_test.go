@@ -0,0 +1,75 @@
+package sqlserver
+
+import (
+	"errors"
+	"fmt"
+	mssql "github.com/denisenkom/go-mssqldb"
+	"github.com/pasztorpisti/migrate/sqlsplit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDialect_SplitStatements(t *testing.T) {
+	tests := []*struct {
+		name   string
+		query  string
+		output []sqlsplit.Statement
+	}{
+		{
+			"single batch",
+			"CREATE TABLE t (id INT);\nINSERT INTO t VALUES (1);\n",
+			[]sqlsplit.Statement{{SQL: "CREATE TABLE t (id INT);\nINSERT INTO t VALUES (1);", Line: 1, Column: 1}},
+		},
+		{
+			"GO separated batches",
+			"CREATE TABLE t (id INT);\nGO\nCREATE PROCEDURE p AS\nBEGIN\n  SELECT 1;\nEND\ngo\n",
+			[]sqlsplit.Statement{
+				{SQL: "CREATE TABLE t (id INT);", Line: 1, Column: 1},
+				{SQL: "CREATE PROCEDURE p AS\nBEGIN\n  SELECT 1;\nEND", Line: 3, Column: 1},
+			},
+		},
+		{
+			"GO count",
+			"INSERT INTO t DEFAULT VALUES\nGO 2\n",
+			[]sqlsplit.Statement{
+				{SQL: "INSERT INTO t DEFAULT VALUES", Line: 1, Column: 1},
+				{SQL: "INSERT INTO t DEFAULT VALUES", Line: 1, Column: 1},
+			},
+		},
+		{
+			"GO in a string",
+			"SELECT 'a\nGO\nb'\n",
+			[]sqlsplit.Statement{{SQL: "SELECT 'a\nGO\nb'", Line: 1, Column: 1}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := dialect{}.SplitStatements(test.query)
+			require.NoError(t, err)
+			assert.Equal(t, test.output, output)
+		})
+	}
+}
+
+func TestDialect_ErrorPosition(t *testing.T) {
+	tests := []*struct {
+		name string
+		err  error
+		line int
+	}{
+		{"mssql error", mssql.Error{Message: "syntax error", LineNo: 3}, 3},
+		{"wrapped mssql error", fmt.Errorf("exec: %w", mssql.Error{LineNo: 2}), 2},
+		{"mssql error without line", mssql.Error{Message: "deadlock"}, 0},
+		{"other error", errors.New("other"), 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			line, column := dialect{}.ErrorPosition(test.err, "SELECT 1")
+			assert.Equal(t, test.line, line)
+			assert.Equal(t, 0, column)
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package sqlserver
+
+import (
+	"database/sql"
+	"fmt"
+	_ "github.com/denisenkom/go-mssqldb"
+	"github.com/pasztorpisti/migrate/core"
+)
+
+func init() {
+	core.RegisterDriverFactory("sqlserver", driverFactory{})
+}
+
+type driverFactory struct{}
+
+func (o driverFactory) NewDriver(params map[string]string) (core.Driver, error) {
+	takeParam := func(key string) (string, bool) {
+		val, ok := params[key]
+		if ok {
+			delete(params, key)
+		}
+		return val, ok
+	}
+
+	tableName, ok := takeParam("migrations_table")
+	if !ok || tableName == "" {
+		tableName = "migrations"
+	}
+
+	historyTableName, ok := takeParam("history_table")
+	if !ok || historyTableName == "" {
+		historyTableName = tableName + "_history"
+	}
+
+	if len(params) != 0 {
+		return nil, fmt.Errorf("unrecognised driver params: %q", params)
+	}
+
+	return &driver{
+		TableName:        tableName,
+		HistoryTableName: historyTableName,
+	}, nil
+}
+
+type driver struct {
+	TableName        string
+	HistoryTableName string
+}
+
+func (*driver) Open(dataSourceName string) (core.ClosableDB, error) {
+	db, err := sql.Open("sqlserver", dataSourceName)
+	if err != nil {
+		return nil, fmt.Errorf("error opening sqlserver connection: %s", err)
+	}
+	return core.WrapDB(db), nil
+}
+
+func (o *driver) NewMigrationDB() (core.MigrationDB, error) {
+	return newMigrationDB(o.TableName, o.HistoryTableName)
+}
+
+func (*driver) Dialect() core.Dialect {
+	return dialect{}
+}
@@ -0,0 +1,269 @@
+package sqlserver
+
+import (
+	"context"
+	"fmt"
+	"github.com/pasztorpisti/migrate/core"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type migrationDB struct {
+	tableName        string
+	historyTableName string
+	// lockResource is the name of the application lock of sp_getapplock.
+	lockResource string
+}
+
+func newMigrationDB(tableName, historyTableName string) (core.MigrationDB, error) {
+	// Table names can't be interpolated in SQL statements so we
+	// escape them manually and format them to the query strings.
+	return &migrationDB{
+		tableName:        quoteIdentifier(tableName),
+		historyTableName: quoteIdentifier(historyTableName),
+		lockResource:     "migrate:" + tableName,
+	}, nil
+}
+
+// quoteIdentifier returns the bracket-quoted form of name.
+// The closing bracket is escaped by doubling it.
+func quoteIdentifier(name string) string {
+	return "[" + strings.Replace(name, "]", "]]", -1) + "]"
+}
+
+// quoteString returns s as a unicode string literal.
+func quoteString(s string) string {
+	return "N'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+func (o *migrationDB) GetForwardMigrations(ctx context.Context, q core.Querier) ([]*core.MigrationNameAndTime, error) {
+	rows, err := q.QueryContext(ctx, `SELECT name, time, checksum, duration_ms, applied_by, hostname, version FROM `+o.tableName)
+	if err != nil {
+		return nil, fmt.Errorf("error querying froward migrated steps: %s", err)
+	}
+	defer rows.Close()
+
+	var res []*core.MigrationNameAndTime
+	for rows.Next() {
+		var item core.MigrationNameAndTime
+		var durationMillis int64
+		if err := rows.Scan(&item.Name, &item.Time, &item.Checksum, &durationMillis, &item.AppliedBy, &item.Hostname, &item.Version); err != nil {
+			return nil, fmt.Errorf("error scanning forward migrations: %s", err)
+		}
+		item.Duration = time.Duration(durationMillis) * time.Millisecond
+		res = append(res, &item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row error durig the scanning of forward migrations: %s", err)
+	}
+	return res, nil
+}
+
+// SQL Server doesn't have CREATE TABLE IF NOT EXISTS.
+const createTableQuery = `
+IF OBJECT_ID(%s, N'U') IS NULL
+CREATE TABLE %s (
+	name NVARCHAR(255) NOT NULL,
+	time DATETIME2 NOT NULL,
+	checksum NVARCHAR(64) NOT NULL DEFAULT '',
+	duration_ms BIGINT NOT NULL DEFAULT 0,
+	applied_by NVARCHAR(255) NOT NULL DEFAULT '',
+	hostname NVARCHAR(255) NOT NULL DEFAULT '',
+	version NVARCHAR(64) NOT NULL DEFAULT '',
+	PRIMARY KEY (name)
+);
+`
+
+// createHistoryTableQuery creates the append-only history table that keeps
+// a record of every executed migration including the backward migrated and
+// failed ones.
+const createHistoryTableQuery = `
+IF OBJECT_ID(%s, N'U') IS NULL
+CREATE TABLE %s (
+	id BIGINT IDENTITY(1,1) NOT NULL,
+	time DATETIME2 NOT NULL,
+	name NVARCHAR(255) NOT NULL,
+	direction NVARCHAR(16) NOT NULL,
+	command NVARCHAR(16) NOT NULL,
+	result NVARCHAR(16) NOT NULL,
+	error NVARCHAR(MAX) NOT NULL DEFAULT '',
+	checksum NVARCHAR(64) NOT NULL DEFAULT '',
+	duration_ms BIGINT NOT NULL DEFAULT 0,
+	applied_by NVARCHAR(255) NOT NULL DEFAULT '',
+	hostname NVARCHAR(255) NOT NULL DEFAULT '',
+	version NVARCHAR(64) NOT NULL DEFAULT '',
+	PRIMARY KEY (id)
+);
+`
+
+func (o *migrationDB) CreateTable() (core.Step, error) {
+	return core.Steps{
+		&core.SQLExecStep{
+			Query:    fmt.Sprintf(createTableQuery, quoteString(o.tableName), o.tableName),
+			IsSystem: true,
+		},
+		o.createHistoryTableStep(),
+	}, nil
+}
+
+// UpgradeTable creates only the history table if it doesn't exist because
+// the first version of this driver created the current migrations table.
+func (o *migrationDB) UpgradeTable() (core.Step, error) {
+	return o.createHistoryTableStep(), nil
+}
+
+func (o *migrationDB) createHistoryTableStep() core.Step {
+	return &core.SQLExecStep{
+		Query:    fmt.Sprintf(createHistoryTableQuery, quoteString(o.historyTableName), o.historyTableName),
+		IsSystem: true,
+	}
+}
+
+// HOLDLOCK makes the MERGE atomic. Without it concurrent MERGE statements
+// could try to insert the same row.
+const forwardMigrateQuery = `MERGE %s WITH (HOLDLOCK) AS t
+USING (SELECT @p1 AS name) AS s ON t.name = s.name
+WHEN MATCHED THEN UPDATE SET time = @p2, checksum = @p3, duration_ms = @p4, applied_by = @p5, hostname = @p6, version = @p7
+WHEN NOT MATCHED THEN INSERT (name, time, checksum, duration_ms, applied_by, hostname, version) VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7);`
+
+func (o *migrationDB) ForwardMigrate(record *core.MigrationRecord) (core.Step, error) {
+	now := time.Now().UTC()
+	return &core.SQLExecStep{
+		Query:    fmt.Sprintf(forwardMigrateQuery, o.tableName),
+		Args:     []interface{}{record.Name, now, record.Checksum, record.DurationMillisArg(), record.AppliedBy, record.Hostname, record.Version},
+		IsSystem: true,
+	}, nil
+}
+
+func (o *migrationDB) BackwardMigrate(migrationName string) (core.Step, error) {
+	return &core.SQLExecStep{
+		Query:    fmt.Sprintf(`DELETE FROM %s WHERE name = @p1;`, o.tableName),
+		Args:     []interface{}{migrationName},
+		IsSystem: true,
+	}, nil
+}
+
+const recordHistoryQuery = `INSERT INTO %s (time, name, direction, command, result, error, checksum, duration_ms, applied_by, hostname, version)
+VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7, @p8, @p9, @p10, @p11);`
+
+func (o *migrationDB) RecordHistory(entry *core.HistoryEntry) (core.Step, error) {
+	return &core.SQLExecStep{
+		Query: fmt.Sprintf(recordHistoryQuery, o.historyTableName),
+		Args: []interface{}{entry.Time, entry.Migration, entry.Direction, entry.Command, entry.Result, entry.Error,
+			entry.Checksum, entry.DurationMillisArg(), entry.AppliedBy, entry.Hostname, entry.Version},
+		IsSystem: true,
+	}, nil
+}
+
+func (o *migrationDB) GetHistory(ctx context.Context, q core.Querier, filter *core.HistoryFilter) ([]*core.HistoryEntry, error) {
+	top := ""
+	if filter.Limit > 0 {
+		top = fmt.Sprintf("TOP (%d) ", filter.Limit)
+	}
+	where, args := filter.SQLCondition(func(n int) string { return "@p" + strconv.Itoa(n) })
+	// The newest entries are queried first because of the limit.
+	rows, err := q.QueryContext(ctx, `SELECT `+top+`id, time, name, direction, command, result, error, checksum, duration_ms, applied_by, hostname, version FROM `+
+		o.historyTableName+where+` ORDER BY id DESC`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying the history: %s", err)
+	}
+	defer rows.Close()
+
+	var res []*core.HistoryEntry
+	for rows.Next() {
+		var e core.HistoryEntry
+		var durationMillis int64
+		if err := rows.Scan(&e.ID, &e.Time, &e.Migration, &e.Direction, &e.Command, &e.Result, &e.Error,
+			&e.Checksum, &durationMillis, &e.AppliedBy, &e.Hostname, &e.Version); err != nil {
+			return nil, fmt.Errorf("error scanning the history: %s", err)
+		}
+		e.Duration = time.Duration(durationMillis) * time.Millisecond
+		res = append(res, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row error during the scanning of the history: %s", err)
+	}
+	core.ReverseHistory(res)
+	return res, nil
+}
+
+// StatementTimeout sets only the session level LOCK_TIMEOUT because SQL
+// Server doesn't have a server-side statement timeout. The goto command
+// still cancels the migration when its timeout expires. The reset step
+// restores the default infinite wait after the migration step even if the
+// migration fails so the setting doesn't stay on the pooled connection.
+func (o *migrationDB) StatementTimeout(timeout time.Duration) (set, reset core.Step, err error) {
+	ms := (timeout + time.Millisecond - 1) / time.Millisecond
+	set = &core.SQLExecStep{
+		Query:    fmt.Sprintf(`SET LOCK_TIMEOUT %d;`, int64(ms)),
+		IsSystem: true,
+	}
+	reset = &core.SQLExecStep{
+		Query:    `SET LOCK_TIMEOUT -1;`,
+		IsSystem: true,
+	}
+	return set, reset, nil
+}
+
+// getAppLockQuery returns the result of sp_getapplock: 0 and 1 mean success,
+// -1 means timeout and the other negative values are errors.
+const getAppLockQuery = `DECLARE @result INT;
+EXEC @result = sp_getapplock @Resource = @p1, @LockMode = 'Exclusive', @LockOwner = 'Transaction', @LockTimeout = @p2;
+SELECT @result;`
+
+// Lock holds a transaction owned application lock in a dedicated
+// transaction. Rolling back the transaction releases the lock. This way the
+// lock is released by the server even if we lose the connection.
+func (o *migrationDB) Lock(ctx context.Context, db core.DB, timeout time.Duration) (unlock func() error, err error) {
+	// The lock is bound to the lifetime of the transaction so it doesn't
+	// use ctx. Otherwise cancelling ctx would release the lock immediately.
+	tx, err := db.BeginTX(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	ms := int64((timeout + time.Millisecond - 1) / time.Millisecond)
+	if ms < 0 {
+		ms = 0
+	}
+	rows, err := tx.QueryContext(ctx, getAppLockQuery, o.lockResource, ms)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result int64
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("sp_getapplock returned no result")
+	}
+	if err := rows.Scan(&result); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := appLockError(result); err != nil {
+		return nil, err
+	}
+	return tx.Rollback, nil
+}
+
+// appLockError converts the result of sp_getapplock to an error.
+func appLockError(result int64) error {
+	switch {
+	case result >= 0:
+		return nil
+	case result == -1:
+		return core.ErrLocked
+	default:
+		return fmt.Errorf("sp_getapplock failed with %d", result)
+	}
+}
@@ -0,0 +1,50 @@
+package sqlserver
+
+import (
+	"errors"
+	"github.com/pasztorpisti/migrate/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestMigrationDB_StatementTimeout(t *testing.T) {
+	mdb, err := newMigrationDB("migrations", "migrations_history")
+	require.NoError(t, err)
+
+	tests := []*struct {
+		name    string
+		timeout time.Duration
+		set     string
+	}{
+		{"milliseconds", 1500 * time.Millisecond, "SET LOCK_TIMEOUT 1500;"},
+		{"rounded up", 1500 * time.Microsecond, "SET LOCK_TIMEOUT 2;"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			set, reset, err := mdb.StatementTimeout(test.timeout)
+			require.NoError(t, err)
+			assert.Equal(t, &core.SQLExecStep{Query: test.set, IsSystem: true}, set)
+			assert.Equal(t, &core.SQLExecStep{Query: "SET LOCK_TIMEOUT -1;", IsSystem: true}, reset)
+		})
+	}
+}
+
+func TestAppLockError(t *testing.T) {
+	assert.NoError(t, appLockError(0))
+	assert.NoError(t, appLockError(1))
+	assert.Equal(t, core.ErrLocked, appLockError(-1))
+	for _, result := range []int64{-2, -3, -999} {
+		err := appLockError(result)
+		assert.Error(t, err)
+		assert.False(t, errors.Is(err, core.ErrLocked))
+	}
+	assert.EqualError(t, appLockError(-3), "sp_getapplock failed with -3")
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	assert.Equal(t, "[migrations]", quoteIdentifier("migrations"))
+	assert.Equal(t, "[a]]b]", quoteIdentifier("a]b"))
+}
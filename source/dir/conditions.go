@@ -0,0 +1,110 @@
+package dir
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var migrateConditionDirectiveRegex = regexp.MustCompile(`^\s*--\s*\+migrate\s+(if|endif)(\s+(.*?))?\s*$`)
+
+// SetConditions implements core.ConditionalMigrationSource.
+func (o *source) SetConditions(conditions map[string]string) {
+	o.Conditions = conditions
+}
+
+// applyConditions processes the conditional blocks of a migration:
+//
+//	-- +migrate if db=dev,test
+//	INSERT INTO users (name) VALUES ('test');
+//	-- +migrate endif
+//
+// The condition is a space separated list of key=value1,value2 expressions.
+// It is true if the value of each key in conditions is one of the listed
+// values. Conditional blocks can be nested.
+//
+// The lines of the excluded blocks are commented out instead of being
+// removed so the line numbers of the returned lines don't change. The if
+// directives of the evaluated blocks are marked with "(included)" or
+// "(skipped)" to make the result easy to check with "migrate plan -sql".
+// Included[i] is true if lines[i] is an included SQL line.
+func applyConditions(lines []string, firstLine int, conditions map[string]string) (res []string, included []bool, err error) {
+	res = make([]string, len(lines))
+	included = make([]bool, len(lines))
+	// stack contains the active state of the enclosing blocks.
+	var stack []bool
+	active := true
+	for i, line := range lines {
+		m := migrateConditionDirectiveRegex.FindStringSubmatch(line)
+		switch {
+		case m == nil:
+			included[i] = active
+			if active {
+				res[i] = line
+			} else {
+				res[i] = "-- " + line
+			}
+
+		case m[1] == "if":
+			ok, err := evalCondition(m[3], conditions)
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: %s", firstLine+i, err)
+			}
+			stack = append(stack, active)
+			if !active {
+				res[i] = "-- " + line
+				continue
+			}
+			active = ok
+			if ok {
+				res[i] = line + " (included)"
+			} else {
+				res[i] = line + " (skipped)"
+			}
+
+		default:
+			if m[3] != "" {
+				return nil, nil, fmt.Errorf("line %d: endif doesn't have parameters", firstLine+i)
+			}
+			if len(stack) == 0 {
+				return nil, nil, fmt.Errorf("line %d: endif without if", firstLine+i)
+			}
+			active = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if active {
+				res[i] = line
+			} else {
+				res[i] = "-- " + line
+			}
+		}
+	}
+	if len(stack) != 0 {
+		return nil, nil, errors.New("missing +migrate endif directive")
+	}
+	return res, included, nil
+}
+
+// evalCondition evaluates the condition of a "+migrate if" directive.
+func evalCondition(condition string, conditions map[string]string) (bool, error) {
+	fields := strings.Fields(condition)
+	if len(fields) == 0 {
+		return false, errors.New("if without condition")
+	}
+	result := true
+	for _, f := range fields {
+		i := strings.IndexByte(f, '=')
+		if i <= 0 || i == len(f)-1 {
+			return false, fmt.Errorf("invalid condition %q - it should be in key=value1,value2 format", f)
+		}
+		value, ok := conditions[f[:i]]
+		match := false
+		for _, v := range strings.Split(f[i+1:], ",") {
+			if ok && v == value {
+				match = true
+			}
+		}
+		result = result && match
+	}
+	return result, nil
+}
@@ -0,0 +1,75 @@
+package dir
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestApplyConditions(t *testing.T) {
+	conditions := map[string]string{"db": "dev", "region": "eu"}
+
+	t.Run("valid", func(t *testing.T) {
+		lines := strings.Split(`CREATE TABLE t (id INT);
+-- +migrate if db=dev,test
+INSERT INTO t VALUES (1);
+-- +migrate if region=us
+INSERT INTO t VALUES (2);
+-- +migrate endif
+-- +migrate endif
+-- +migrate if db=prod region=eu
+INSERT INTO t VALUES (3);
+-- +migrate if db=dev
+INSERT INTO t VALUES (4);
+-- +migrate endif
+-- +migrate endif`, "\n")
+
+		res, _, err := applyConditions(lines, 1, conditions)
+		require.NoError(t, err)
+		assert.Equal(t, `CREATE TABLE t (id INT);
+-- +migrate if db=dev,test (included)
+INSERT INTO t VALUES (1);
+-- +migrate if region=us (skipped)
+-- INSERT INTO t VALUES (2);
+-- +migrate endif
+-- +migrate endif
+-- +migrate if db=prod region=eu (skipped)
+-- INSERT INTO t VALUES (3);
+-- -- +migrate if db=dev
+-- INSERT INTO t VALUES (4);
+-- -- +migrate endif
+-- +migrate endif`, strings.Join(res, "\n"))
+	})
+
+	for _, sql := range []string{
+		"-- +migrate if db=dev",
+		"-- +migrate endif",
+		"-- +migrate if\n-- +migrate endif",
+		"-- +migrate if db\n-- +migrate endif",
+		"-- +migrate if db=\n-- +migrate endif",
+		"-- +migrate if db=dev\n-- +migrate endif db=dev",
+	} {
+		t.Run("invalid "+sql, func(t *testing.T) {
+			_, _, err := applyConditions(strings.Split(sql, "\n"), 1, conditions)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoadStepPair_Conditions(t *testing.T) {
+	src, err := NewFSMigrationSource(fstest.MapFS{
+		"0001_a.sql": &fstest.MapFile{Data: []byte("-- +migrate forward\nSELECT 1;\n-- +migrate if db=prod\nSELECT 2;\n-- +migrate endif\n")},
+	}, "", "")
+	require.NoError(t, err)
+	src.(*source).SetConditions(map[string]string{"db": "dev"})
+
+	fwd, _, err := src.(*source).loadMigrationFile("0001_a.sql")
+	require.NoError(t, err)
+	require.Len(t, fwd, 1)
+	s := fwd[0].Step
+	assert.Equal(t, "SELECT 1;\n-- +migrate if db=prod (skipped)\n-- SELECT 2;\n-- +migrate endif\n", s.Query)
+	assert.Equal(t, "SELECT 1;\n-- +migrate if db=prod\nSELECT 2;\n-- +migrate endif\n", s.RawQuery)
+	assert.Equal(t, 2, s.Line)
+}
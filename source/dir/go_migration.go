@@ -0,0 +1,121 @@
+package dir
+
+import (
+	"fmt"
+	"github.com/pasztorpisti/migrate/core"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// GoMigration is a migration implemented with Go functions instead of SQL.
+// The registered Go migrations are loaded by the dir and fs migration sources
+// that use their registry together with the migration files and they are
+// sorted by ID.
+type GoMigration struct {
+	ID int64
+	// Description is used to generate the name of the migration using the
+	// filename_pattern of the migration source. The extension of the
+	// generated name is replaced with ".go". E.g.: 0005_backfill_users.go
+	Description string
+	Forward     func(ctx core.ExecCtx) error
+	// Backward is optional.
+	Backward func(ctx core.ExecCtx) error
+	// NoTransaction has the same effect as the notransaction flag of the
+	// +migrate directive in SQL files. When it is false ctx.DB is the
+	// transaction of the migration.
+	NoTransaction bool
+	// Timeout is optional. It has the same effect as the timeout parameter
+	// of the +migrate directive in SQL files.
+	Timeout time.Duration
+}
+
+// goMigrations contains the registered Go migrations by registry and ID.
+var goMigrations = make(map[string]map[int64]*GoMigration)
+
+// RegisterGoMigration registers a Go migration in the given registry.
+// Only the migration sources that have the same go_migrations param (or
+// goMigrations argument in case of NewFSMigrationSource) load it so every
+// migrations dir can have its own Go migrations. It is typically called from
+// the init function of a package that is linked into your own build of the
+// migrate tool or your application that uses the fs migration source.
+func RegisterGoMigration(registry string, m *GoMigration) {
+	if registry == "" {
+		panic("empty Go migration registry")
+	}
+	if m == nil || m.Forward == nil {
+		panic("Go migration without a forward function")
+	}
+	migrations, ok := goMigrations[registry]
+	if !ok {
+		migrations = make(map[int64]*GoMigration)
+		goMigrations[registry] = migrations
+	}
+	if _, ok := migrations[m.ID]; ok {
+		panic(fmt.Sprintf("duplicate Go migration ID in registry %q: %d", registry, m.ID))
+	}
+	migrations[m.ID] = m
+}
+
+// loadGoMigrations returns the Go migrations of the GoMigrations registry
+// of the source sorted by ID.
+func (o *source) loadGoMigrations() ([]*entry, error) {
+	if o.GoMigrations == "" {
+		return nil, nil
+	}
+	migrations := goMigrations[o.GoMigrations]
+	var ids []int64
+	for id := range migrations {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	entries := make([]*entry, 0, len(ids))
+	for _, id := range ids {
+		m := migrations[id]
+		fwd, err := o.newGoStep(m, true)
+		if err != nil {
+			return nil, err
+		}
+		e := &entry{
+			MigrationID: fwd.ParsedName.ID,
+			Forward:     fwd,
+		}
+		if m.Backward != nil {
+			e.Backward, err = o.newGoStep(m, false)
+			if err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (o *source) newGoStep(m *GoMigration, forward bool) (*step, error) {
+	filename := o.FilenamePattern.FormatFilename(m.ID, m.Description, forward)
+	parsedName, err := o.FilenamePattern.ParseFilename(filename)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Go migration ID=%d description=%q: %s", m.ID, m.Description, err)
+	}
+	name := strings.TrimSuffix(filename, filepath.Ext(filename)) + ".go"
+	if !o.FilenamePattern.HasDirection {
+		parsedName.ID.Names[0] = name
+	}
+
+	f := m.Forward
+	if !forward {
+		f = m.Backward
+	}
+	return &step{
+		Name:       name,
+		ParsedName: parsedName,
+		Func: &core.FuncStep{
+			Name:          name,
+			Func:          f,
+			NoTransaction: m.NoTransaction,
+			Timeout:       m.Timeout,
+		},
+	}, nil
+}
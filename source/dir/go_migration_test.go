@@ -0,0 +1,86 @@
+package dir
+
+import (
+	"errors"
+	"github.com/pasztorpisti/migrate/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"testing/fstest"
+)
+
+func TestGoMigrations(t *testing.T) {
+	defer func(orig map[string]map[int64]*GoMigration) { goMigrations = orig }(goMigrations)
+	goMigrations = make(map[string]map[int64]*GoMigration)
+
+	errFwd := errors.New("forward called")
+	RegisterGoMigration("test", &GoMigration{
+		ID:          2,
+		Description: "backfill",
+		Forward: func(ctx core.ExecCtx) error {
+			return errFwd
+		},
+		NoTransaction: true,
+	})
+
+	RegisterGoMigration("other", &GoMigration{
+		ID:      4,
+		Forward: func(ctx core.ExecCtx) error { return nil },
+	})
+
+	newSource := func(t *testing.T, fsys fstest.MapFS) *source {
+		src, err := NewFSMigrationSource(fsys, "", "test")
+		require.NoError(t, err)
+		return src.(*source)
+	}
+
+	t.Run("sorted together with files", func(t *testing.T) {
+		src := newSource(t, fstest.MapFS{
+			"0001_a.sql": &fstest.MapFile{Data: []byte("-- +migrate forward\nSELECT 1;\n")},
+			"0003_c.sql": &fstest.MapFile{Data: []byte("-- +migrate forward\nSELECT 3;\n")},
+		})
+		entries, err := src.MigrationEntries()
+		require.NoError(t, err)
+
+		require.Equal(t, 3, entries.NumMigrations())
+		assert.Equal(t, "0001_a.sql", entries.Name(0))
+		assert.Equal(t, "0002_backfill.go", entries.Name(1))
+		assert.Equal(t, "0003_c.sql", entries.Name(2))
+
+		idx, ok := entries.IndexForName("2")
+		assert.True(t, ok)
+		assert.Equal(t, 1, idx)
+
+		fwd, back, err := entries.Steps(1)
+		require.NoError(t, err)
+		assert.Nil(t, back)
+		assert.False(t, fwd.AllowsTransaction())
+		assert.Equal(t, errFwd, fwd.Execute(core.ExecCtx{}))
+		assert.Equal(t, "", core.StepChecksum(fwd))
+	})
+
+	t.Run("source without registry", func(t *testing.T) {
+		src, err := NewFSMigrationSource(fstest.MapFS{
+			"0001_a.sql": &fstest.MapFile{Data: []byte("-- +migrate forward\nSELECT 1;\n")},
+		}, "", "")
+		require.NoError(t, err)
+		entries, err := src.MigrationEntries()
+		require.NoError(t, err)
+		require.Equal(t, 1, entries.NumMigrations())
+		assert.Equal(t, "0001_a.sql", entries.Name(0))
+	})
+
+	t.Run("duplicate ID", func(t *testing.T) {
+		src := newSource(t, fstest.MapFS{
+			"0002_b.sql": &fstest.MapFile{Data: []byte("-- +migrate forward\nSELECT 2;\n")},
+		})
+		_, err := src.MigrationEntries()
+		assert.EqualError(t, err, "duplicate forward step - 0002_backfill.go (Go migration), 0002_b.sql")
+	})
+
+	t.Run("duplicate registration", func(t *testing.T) {
+		assert.Panics(t, func() {
+			RegisterGoMigration("test", &GoMigration{ID: 2, Forward: func(core.ExecCtx) error { return nil }})
+		})
+	})
+}
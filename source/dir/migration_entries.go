@@ -14,22 +14,25 @@ import (
 )
 
 func newEntries(src *source) (core.MigrationEntries, error) {
-	st, err := os.Stat(src.MigrationsDir)
-	if err != nil {
-		return nil, fmt.Errorf("error reading migrations directory %q: %s", src.MigrationsDir, err)
-	}
-	if !st.IsDir() {
-		return nil, fmt.Errorf("%q isn't a directory", src.MigrationsDir)
+	if src.MigrationsDir != "" {
+		st, err := os.Stat(src.MigrationsDir)
+		if err != nil {
+			return nil, fmt.Errorf("error reading migrations directory %q: %s", src.MigrationsDir, err)
+		}
+		if !st.IsDir() {
+			return nil, fmt.Errorf("%q isn't a directory", src.MigrationsDir)
+		}
 	}
 
-	items, err := src.loadMigrationsDir()
+	items, repeatables, err := src.loadMigrationsDir()
 	if err != nil {
 		return nil, err
 	}
 
 	e := &entries{
-		Source: src,
-		Items:  items,
+		Source:      src,
+		Items:       items,
+		Repeatables: repeatables,
 	}
 	e.updateMaps()
 	return e, nil
@@ -40,6 +43,8 @@ type entries struct {
 	Items   []*entry
 	Names   map[string]*entry
 	Indexes map[string]int
+	// Repeatables are sorted by name.
+	Repeatables []*step
 }
 
 func (o *entries) updateMaps() {
@@ -65,7 +70,12 @@ func (o *entries) Name(index int) string {
 
 func (o *entries) Steps(index int) (forward, backward core.Step, err error) {
 	e := o.Items[index]
-	return e.Forward.Step, e.Backward.Step, nil
+	// Backward is optional. Returning a nil *core.SQLExecStep
+	// would result in a non-nil core.Step interface.
+	if e.Backward == nil {
+		return e.Forward.coreStep(), nil, nil
+	}
+	return e.Forward.coreStep(), e.Backward.coreStep(), nil
 }
 
 func (o *entries) IndexForName(name string) (index int, ok bool) {
@@ -73,6 +83,35 @@ func (o *entries) IndexForName(name string) (index int, ok bool) {
 	return
 }
 
+func (o *entries) NumRepeatableMigrations() int {
+	return len(o.Repeatables)
+}
+
+func (o *entries) RepeatableName(index int) string {
+	return o.Repeatables[index].Name
+}
+
+func (o *entries) RepeatableStep(index int) (core.Step, error) {
+	return o.Repeatables[index].Step, nil
+}
+
+// IsRepeatableName returns true if name has the repeatable prefix or if it
+// doesn't match the filename pattern. Names that don't match the pattern
+// can belong only to repeatable migrations with a "+migrate repeatable"
+// directive.
+func (o *entries) IsRepeatableName(name string) bool {
+	if strings.HasPrefix(name, repeatablePrefix) {
+		return true
+	}
+	// Go migrations have the ".go" extension instead of the one in the pattern.
+	if strings.HasSuffix(name, ".go") {
+		ext := filepath.Ext(o.Source.FilenamePattern.FormatFilename(1, "", true))
+		name = strings.TrimSuffix(name, ".go") + ext
+	}
+	_, err := o.Source.FilenamePattern.ParseFilename(name)
+	return err != nil
+}
+
 const newUsageFmtStr = `Usage: migrate new [-squashed] %s
 
 Creates a new migration file in the migration directory specified
@@ -89,6 +128,9 @@ Options:
 `
 
 func (o *entries) New(args []string) (name string, err error) {
+	if o.Source.MigrationsDir == "" {
+		return "", errors.New("this migration source is read-only")
+	}
 	fp := o.Source.FilenamePattern
 
 	fs := flag.NewFlagSet("new", flag.ExitOnError)
@@ -225,6 +267,11 @@ func (o *entries) createSquashedMigrationFile(description string) (name string,
 	if len(o.Items) == 0 {
 		return "", errors.New("there is nothing to squash")
 	}
+	for _, e := range o.Items {
+		if e.Forward.Func != nil {
+			return "", fmt.Errorf("squashing Go migrations isn't supported - %s", e.Forward)
+		}
+	}
 
 	var fwdLines []string
 	for i, e := range o.Items {
@@ -235,11 +282,22 @@ func (o *entries) createSquashedMigrationFile(description string) (name string,
 		if e.Forward.MigrateDirective != "" {
 			fwdLines = append(fwdLines, e.Forward.MigrateDirective)
 		}
-		fwdLines = append(fwdLines, e.Forward.Step.Query)
+		fwdLines = append(fwdLines, e.Forward.rawQuery())
+	}
+
+	// The squashed migration gets a backward step only if all migrations
+	// have one because a backward step that reverts only some of them
+	// would leave the DB in a state that doesn't match any migration.
+	hasBackward := true
+	for _, e := range o.Items {
+		if e.Backward == nil {
+			hasBackward = false
+			break
+		}
 	}
 
 	var backLines []string
-	for i := len(o.Items) - 1; i >= 0; i-- {
+	for i := len(o.Items) - 1; hasBackward && i >= 0; i-- {
 		if i != len(o.Items)-1 {
 			backLines = append(backLines, "")
 		}
@@ -248,7 +306,7 @@ func (o *entries) createSquashedMigrationFile(description string) (name string,
 		if e.Backward.MigrateDirective != "" {
 			backLines = append(backLines, e.Backward.MigrateDirective)
 		}
-		backLines = append(backLines, e.Backward.Step.Query)
+		backLines = append(backLines, e.Backward.rawQuery())
 	}
 
 	id := o.Items[len(o.Items)-1].MigrationID.Number
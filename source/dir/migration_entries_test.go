@@ -0,0 +1,73 @@
+package dir
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEntries_CreateSquashedMigrationFile(t *testing.T) {
+	squash := func(t *testing.T, files map[string]string) string {
+		dir, err := ioutil.TempDir("", "migrate_squash_test")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+		for name, contents := range files {
+			err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644)
+			require.NoError(t, err)
+		}
+		pfp, err := parseFilenamePattern(defaultFilenamePattern)
+		require.NoError(t, err)
+		src := &source{
+			FS:              os.DirFS(dir),
+			MigrationsDir:   dir,
+			FilenamePattern: pfp,
+		}
+
+		me, err := src.MigrationEntries()
+		require.NoError(t, err)
+		name, err := me.(*entries).createSquashedMigrationFile("squashed")
+		require.NoError(t, err)
+		assert.Equal(t, "0002_squashed.sql", name)
+
+		remaining, err := ioutil.ReadDir(dir)
+		require.NoError(t, err)
+		require.Len(t, remaining, 1)
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	t.Run("with backward steps", func(t *testing.T) {
+		contents := squash(t, map[string]string{
+			"0001_a.sql": "-- +migrate forward\nSELECT 1;\n-- +migrate backward\nSELECT 2;\n",
+			"0002_b.sql": "-- +migrate forward\nSELECT 3;\n-- +migrate backward\nSELECT 4;\n",
+		})
+		assert.Equal(t, "-- +migrate squashed 0001_a.sql\n\n-- +migrate forward\nSELECT 1;\n\n"+
+			"-- +migrate squashed 0002_b.sql\n\n-- +migrate forward\nSELECT 3;\n\n"+
+			"-- +migrate squashed 0002_b.sql\n\n-- +migrate backward\nSELECT 4;\n\n\n"+
+			"-- +migrate squashed 0001_a.sql\n\n-- +migrate backward\nSELECT 2;\n", contents)
+	})
+
+	t.Run("without a backward step", func(t *testing.T) {
+		contents := squash(t, map[string]string{
+			"0001_a.sql": "-- +migrate forward\nSELECT 1;\n",
+			"0002_b.sql": "-- +migrate forward\nSELECT 3;\n-- +migrate backward\nSELECT 4;\n",
+		})
+		assert.Equal(t, "-- +migrate squashed 0001_a.sql\n\n-- +migrate forward\nSELECT 1;\n\n\n"+
+			"-- +migrate squashed 0002_b.sql\n\n-- +migrate forward\nSELECT 3;\n", contents)
+	})
+}
+
+func TestEntries_IsRepeatableName(t *testing.T) {
+	pfp, err := parseFilenamePattern(defaultFilenamePattern)
+	require.NoError(t, err)
+	e := &entries{Source: &source{FilenamePattern: pfp}}
+
+	assert.True(t, e.IsRepeatableName("R_views.sql"))
+	assert.True(t, e.IsRepeatableName("views.sql"))
+	assert.False(t, e.IsRepeatableName("0001_initial.sql"))
+	assert.False(t, e.IsRepeatableName("0001_initial.go"))
+}
@@ -4,11 +4,14 @@ import (
 	"errors"
 	"fmt"
 	"github.com/pasztorpisti/migrate/core"
-	"io/ioutil"
+	"io/fs"
+	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 	"unicode"
 )
 
@@ -55,19 +58,78 @@ func (sourceFactory) NewMigrationSource(baseDir string, params map[string]string
 		return nil, fmt.Errorf("invalid filename_pattern: %s", err)
 	}
 
+	substituteVars := false
+	if val, ok := takeParam("substitute_vars"); ok {
+		substituteVars, err = strconv.ParseBool(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid substitute_vars: %q", val)
+		}
+	}
+
+	goMigrations, _ := takeParam("go_migrations")
+
 	if len(params) != 0 {
 		return nil, fmt.Errorf("unrecognised migration_source params: %q", params)
 	}
 
 	return &source{
+		FS:              os.DirFS(path),
 		MigrationsDir:   path,
 		FilenamePattern: pfp,
+		SubstituteVars:  substituteVars,
+		GoMigrations:    goMigrations,
+	}, nil
+}
+
+// NewFSMigrationSource creates a read-only migration source that loads the
+// migration files from the root directory of fsys (e.g.: an embed.FS).
+// The format of the migration files and the filenamePattern are the same as
+// in case of the dir migration source. An empty filenamePattern means the
+// default pattern. GoMigrations is the registry of the Go migrations to load
+// (see RegisterGoMigration) or empty.
+func NewFSMigrationSource(fsys fs.FS, filenamePattern, goMigrations string) (core.MigrationSource, error) {
+	if filenamePattern == "" {
+		filenamePattern = defaultFilenamePattern
+	}
+	pfp, err := parseFilenamePattern(filenamePattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filename_pattern: %s", err)
+	}
+	return &source{
+		FS:              fsys,
+		FilenamePattern: pfp,
+		GoMigrations:    goMigrations,
 	}, nil
 }
 
 type source struct {
+	// FS is the root of the migration files.
+	FS fs.FS
+	// MigrationsDir is the absolute path of FS in the OS filesystem.
+	// It is empty in case of read-only sources that don't have one.
 	MigrationsDir   string
 	FilenamePattern *parsedFilenamePattern
+	// Conditions are the values of the conditional blocks.
+	// See applyConditions.
+	Conditions map[string]string
+	// SubstituteVars enables the substitution of the {env:VAR} and
+	// {var:name} template parameters in the migrations.
+	// See substituteVars.
+	SubstituteVars bool
+	// Vars are the values of the {var:name} template parameters.
+	Vars map[string]string
+	// GoMigrations is the registry of the Go migrations loaded by the
+	// source. Empty means no Go migrations. See RegisterGoMigration.
+	GoMigrations string
+}
+
+// path returns the path of a file from FS to be used in messages and
+// file operations.
+func (o *source) path(filename string) string {
+	if o.MigrationsDir == "" {
+		return filename
+	}
+	return filepath.Join(o.MigrationsDir, filename)
 }
 
 func (o *source) MigrationEntries() (core.MigrationEntries, error) {
@@ -80,25 +142,60 @@ type entry struct {
 	Backward    *step
 }
 
-func (o *source) loadMigrationsDir() ([]*entry, error) {
-	files, err := ioutil.ReadDir(o.MigrationsDir)
+func (o *source) loadMigrationsDir() ([]*entry, []*step, error) {
+	var firstErr error
+	entries, repeatables, _ := o.loadEntries(func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	})
+	if firstErr != nil {
+		return nil, nil, firstErr
+	}
+	return entries, repeatables, nil
+}
+
+// loadEntries loads the migrations dir and reports every problem it finds
+// instead of stopping at the first one. The returned entries and repeatable
+// migrations are usable only if there were no reported problems.
+// BrokenIDs contains the IDs of the reported migrations that are missing
+// from the returned entries.
+func (o *source) loadEntries(report func(error)) (entries []*entry, repeatables []*step, brokenIDs map[int64]bool) {
+	files, err := fs.ReadDir(o.FS, ".")
 	if err != nil {
-		return nil, err
+		report(err)
+		return nil, nil, nil
+	}
+
+	goEntries, err := o.loadGoMigrations()
+	if err != nil {
+		report(err)
 	}
 
-	entryMap := make(map[int64]*entry, len(files))
+	brokenIDs = make(map[int64]bool)
+	entryMap := make(map[int64]*entry, len(files)+len(goEntries))
+	for _, e := range goEntries {
+		entryMap[e.MigrationID.Number] = e
+	}
 	for _, item := range files {
 		if item.IsDir() {
 			continue
 		}
 
-		path := filepath.Join(o.MigrationsDir, item.Name())
-		fwdSteps, backSteps, err := o.loadMigrationFile(path)
+		fwdSteps, backSteps, err := o.loadMigrationFile(item.Name())
 		if err != nil {
-			return nil, err
+			report(err)
+			if parsedName, err := o.FilenamePattern.ParseFilename(item.Name()); err == nil {
+				brokenIDs[parsedName.ID.Number] = true
+			}
+			continue
 		}
 
 		for _, fwdStep := range fwdSteps {
+			if fwdStep.Repeatable {
+				repeatables = append(repeatables, fwdStep)
+				continue
+			}
 			e, ok := entryMap[fwdStep.ParsedName.ID.Number]
 			if !ok {
 				entryMap[fwdStep.ParsedName.ID.Number] = &entry{
@@ -109,7 +206,8 @@ func (o *source) loadMigrationsDir() ([]*entry, error) {
 			}
 
 			if e.Forward != nil {
-				return nil, fmt.Errorf("duplicate forward step - %s, %s", e.Forward, fwdStep)
+				report(fmt.Errorf("duplicate forward step - %s, %s", e.Forward, fwdStep))
+				continue
 			}
 			e.Forward = fwdStep
 		}
@@ -125,36 +223,46 @@ func (o *source) loadMigrationsDir() ([]*entry, error) {
 			}
 
 			if e.Backward != nil {
-				return nil, fmt.Errorf("duplicate backward step - %s, %s", e.Backward, backStep)
+				report(fmt.Errorf("duplicate backward step - %s, %s", e.Backward, backStep))
+				continue
+			}
+			if e.Forward != nil && e.Forward.Func != nil {
+				report(fmt.Errorf("backward migration file for a Go migration - %s, %s", e.Forward, backStep))
+				continue
 			}
 			e.Backward = backStep
 		}
 	}
 
-	entryList := make([]*entry, 0, len(entryMap))
+	entries = make([]*entry, 0, len(entryMap))
 	for _, e := range entryMap {
-		entryList = append(entryList, e)
-
 		if e.Forward == nil {
-			return nil, fmt.Errorf("backward migration without a forward step - %s", e.Backward)
+			report(fmt.Errorf("backward migration without a forward step - %s", e.Backward))
+			brokenIDs[e.MigrationID.Number] = true
+			continue
 		}
 		if e.Backward != nil {
 			if !e.Backward.ParsedName.equals(e.Forward.ParsedName) {
-				return nil, fmt.Errorf("forward and backward migrations have different descriptions - %s, %s", e.Forward, e.Backward)
+				report(fmt.Errorf("forward and backward migrations have different descriptions - %s, %s", e.Forward, e.Backward))
 			}
 		}
+		entries = append(entries, e)
 	}
 
-	sort.Slice(entryList, func(i, j int) bool {
-		return entryList[i].MigrationID.Number < entryList[j].MigrationID.Number
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].MigrationID.Number < entries[j].MigrationID.Number
+	})
+	sort.Slice(repeatables, func(i, j int) bool {
+		return repeatables[i].Name < repeatables[j].Name
 	})
 
-	return entryList, nil
+	return entries, repeatables, brokenIDs
 }
 
 type step struct {
 	// Path contains the absolute path to the file from which this migration
-	// step has been loaded. The name of the file can be different from the
+	// step has been loaded (or the path inside the fs.FS if the source
+	// doesn't have a MigrationsDir). The name of the file can be different from the
 	// Name of the migration if Squashed==true.
 	Path     string
 	Squashed bool
@@ -167,10 +275,36 @@ type step struct {
 	// +migrate directive for this file. Empty string if there is no directive.
 	MigrateDirective string
 	Step             *core.SQLExecStep
+
+	// Func is non-nil in case of Go migrations. Go migrations have
+	// no Path and Step.
+	Func *core.FuncStep
+
+	// Repeatable is true in case of repeatable migrations. Their
+	// ParsedName is nil. See core.RepeatableMigrations.
+	Repeatable bool
+}
+
+func (o *step) coreStep() core.Step {
+	if o.Func != nil {
+		return o.Func
+	}
+	return o.Step
+}
+
+// rawQuery returns the SQL of the step as it is in the migration file.
+func (o *step) rawQuery() string {
+	if o.Step.RawQuery != "" {
+		return o.Step.RawQuery
+	}
+	return o.Step.Query
 }
 
 func (o *step) String() string {
 	s := o.Name
+	if o.Func != nil {
+		return s + " (Go migration)"
+	}
 	if o.Squashed {
 		s += " squashed into " + filepath.Base(o.Path)
 	}
@@ -180,13 +314,22 @@ func (o *step) String() string {
 var migrateStepDirectiveRegex = regexp.MustCompile(`^\s*--\s*\+migrate(\s+(.*?))?\s*$`)
 var migrateSquashedDirectiveRegex = regexp.MustCompile(`^\s*--\s*\+migrate\s+squashed\s+(.*?)\s*$`)
 
-func (o *source) loadMigrationFile(path string) (forward, backward []*step, err error) {
-	b, err := ioutil.ReadFile(path)
+func (o *source) loadMigrationFile(filename string) (forward, backward []*step, err error) {
+	path := o.path(filename)
+	b, err := fs.ReadFile(o.FS, filename)
 	if err != nil {
 		return nil, nil, err
 	}
 	lines := strings.Split(string(b), "\n")
 
+	repeatable, err := o.loadRepeatable(path, filename, lines)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing repeatable migration from file %q: %s", path, err)
+	}
+	if repeatable != nil {
+		return []*step{repeatable}, nil, nil
+	}
+
 	// Find all lines that contain the '-- +migrate squash <name>' directive.
 	type squashDirective struct {
 		LineIdx int
@@ -209,7 +352,7 @@ func (o *source) loadMigrationFile(path string) (forward, backward []*step, err
 		// This is a file without '+migrate squash' directives.
 		// This means it has to contain exactly one '+migrate forward'
 		// directive and an optional '+migrate backward'.
-		fwdStep, backStep, err := o.loadStepPair(path, filepath.Base(path), lines, false)
+		fwdStep, backStep, err := o.loadStepPair(path, filepath.Base(path), lines, 1, false)
 		if err != nil {
 			return nil, nil, fmt.Errorf("error parsing migration from file %q: %s", path, err)
 		}
@@ -231,14 +374,16 @@ func (o *source) loadMigrationFile(path string) (forward, backward []*step, err
 
 	for i, directive := range squashDirectives {
 		squashLines := lines[directive.LineIdx+1 : indexes[i+1].LineIdx]
+		firstLine := directive.LineIdx + 2
 		if len(squashLines) > 0 && strings.TrimSpace(squashLines[0]) == "" {
 			squashLines = squashLines[1:]
+			firstLine++
 		}
 		if i+1 < len(squashDirectives) && len(squashLines) > 0 && strings.TrimSpace(squashLines[len(squashLines)-1]) == "" {
 			squashLines = squashLines[:len(squashLines)-1]
 		}
 
-		fwdStep, backStep, err := o.loadStepPair(path, directive.Name, squashLines, true)
+		fwdStep, backStep, err := o.loadStepPair(path, directive.Name, squashLines, firstLine, true)
 		if err != nil {
 			return nil, nil, fmt.Errorf("error parsing squashed migration %q from file %q: %s", directive.Name, path, err)
 		}
@@ -253,18 +398,93 @@ func (o *source) loadMigrationFile(path string) (forward, backward []*step, err
 	return forward, backward, nil
 }
 
+// repeatablePrefix is the filename prefix of repeatable migrations.
+// Files with other names are repeatable if they contain a
+// "+migrate repeatable" directive.
+const repeatablePrefix = "R_"
+
+// loadRepeatable returns nil if the given lines of a file don't contain a
+// repeatable migration. A repeatable migration has at most one +migrate
+// directive. The SQL is either the whole file or the lines following the
+// directive.
+func (o *source) loadRepeatable(path, name string, lines []string) (*step, error) {
+	type directive struct {
+		LineIdx int
+		Params  *directiveParams
+		Err     error
+	}
+	var directives []directive
+	repeatable := strings.HasPrefix(name, repeatablePrefix)
+	for i, line := range lines {
+		m := migrateStepDirectiveRegex.FindStringSubmatch(line)
+		if m == nil || migrateConditionDirectiveRegex.MatchString(line) {
+			continue
+		}
+		params, err := parseDirectiveParams(m[2])
+		if err == nil && params.Repeatable {
+			repeatable = true
+		}
+		directives = append(directives, directive{
+			LineIdx: i,
+			Params:  params,
+			Err:     err,
+		})
+	}
+	if !repeatable {
+		return nil, nil
+	}
+
+	migrateDirective := ""
+	params := &directiveParams{}
+	begin := 0
+	switch len(directives) {
+	case 0:
+	case 1:
+		d := directives[0]
+		if d.Err != nil {
+			return nil, fmt.Errorf("error parsing +migrate directive params: %s", d.Err)
+		}
+		if d.Params.Forward || d.Params.Backward {
+			return nil, errors.New("repeatable migrations can't have forward and backward directives")
+		}
+		migrateDirective = lines[d.LineIdx]
+		params = d.Params
+		begin = d.LineIdx + 1
+	default:
+		return nil, errors.New("repeatable migrations can have only one +migrate directive")
+	}
+
+	s, err := o.newSQLExecStep(lines[begin:], begin+1)
+	if err != nil {
+		return nil, err
+	}
+	s.Path = path
+	s.NoTransaction = params.NoTransaction
+	s.NoSplit = params.NoSplit
+	s.Timeout = params.Timeout
+
+	return &step{
+		Path:             path,
+		Name:             name,
+		MigrateDirective: migrateDirective,
+		Step:             s,
+		Repeatable:       true,
+	}, nil
+}
+
 // loadStepPair loads either a forward or a backward step, or both.
 // Name is either the name of the file that contains the given lines or the
 // squashed name.
 //
 // If the given migration is a squashed one then lines contains only those
 // lines that belong to the given squashed entry.
+// FirstLine is the 1-based line number of lines[0] in the file.
 //
 // When the filename pattern contains {direction} the given lines
 // don't have to contain a "+migrate" directive to specify a direction.
 // If they still have a "+migrate" directive then the direction in that has
 // to match the direction in the filename.
-func (o *source) loadStepPair(path, name string, lines []string, squashed bool) (forward, backward *step, err error) {
+func (o *source) loadStepPair(path, name string, lines []string, firstLine int, squashed bool) (forward, backward *step, err error) {
 	parsedFilename, err := o.FilenamePattern.ParseFilename(filepath.Base(path))
 	if err != nil {
 		return nil, nil, err
@@ -278,7 +498,7 @@ func (o *source) loadStepPair(path, name string, lines []string, squashed bool)
 	var directives []directive
 	for i, line := range lines {
 		m := migrateStepDirectiveRegex.FindStringSubmatch(line)
-		if m == nil {
+		if m == nil || migrateConditionDirectiveRegex.MatchString(line) {
 			continue
 		}
 		directives = append(directives, directive{
@@ -292,6 +512,7 @@ func (o *source) loadStepPair(path, name string, lines []string, squashed bool)
 		if err != nil {
 			return nil, err
 		}
+		s.Path = path
 		return &step{
 			Path:             path,
 			Squashed:         squashed,
@@ -306,9 +527,11 @@ func (o *source) loadStepPair(path, name string, lines []string, squashed bool)
 		if o.FilenamePattern.HasDirection {
 			// The filename contains the migration direction so
 			// the "+migrate <forward|backward>" directive is optional.
-			step, err := newStep("", &core.SQLExecStep{
-				Query: strings.Join(lines, "\n"),
-			})
+			s, err := o.newSQLExecStep(lines, firstLine)
+			if err != nil {
+				return nil, nil, err
+			}
+			step, err := newStep("", s)
 			if err != nil {
 				return nil, nil, err
 			}
@@ -328,11 +551,12 @@ func (o *source) loadStepPair(path, name string, lines []string, squashed bool)
 
 	// Processing the found directives.
 	for i, d := range directives {
-		fwd, bwd, notransaction, err := parseDirectiveParams(d.Params)
+		params, err := parseDirectiveParams(d.Params)
 		if err != nil {
 			return nil, nil, fmt.Errorf("error parsing +migrate directive params: %s", err)
 		}
 
+		fwd, bwd := params.Forward, params.Backward
 		if o.FilenamePattern.HasDirection {
 			// If the filename contains the direction then the +migrate directive
 			// doesn't even have to specify it. However, if it specifies it then
@@ -353,10 +577,14 @@ func (o *source) loadStepPair(path, name string, lines []string, squashed bool)
 
 		begin := indexes[i].LineIdx
 		end := indexes[i+1].LineIdx
-		step, err := newStep(lines[begin], &core.SQLExecStep{
-			Query:         strings.Join(lines[begin+1:end], "\n"),
-			NoTransaction: notransaction,
-		})
+		s, err := o.newSQLExecStep(lines[begin+1:end], firstLine+begin+1)
+		if err != nil {
+			return nil, nil, err
+		}
+		s.NoTransaction = params.NoTransaction
+		s.NoSplit = params.NoSplit
+		s.Timeout = params.Timeout
+		step, err := newStep(lines[begin], s)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -377,41 +605,108 @@ func (o *source) loadStepPair(path, name string, lines []string, squashed bool)
 	return forward, backward, nil
 }
 
-// TODO: create a direction enum
-func parseDirectiveParams(params string) (forward, backward, notransaction bool, err error) {
-	forward, backward, notransaction = false, false, false
+// newSQLExecStep returns a step that executes the given lines of a migration
+// file. FirstLine is the 1-based line number of lines[0] in the file.
+// The lines of the conditional blocks are commented out in the Query of the
+// step if their condition is false (see applyConditions) and the template
+// parameters of the included lines are substituted if SubstituteVars is
+// true (see substituteVars). The checksum of the step is calculated from
+// the RawQuery that is the unprocessed text of the lines.
+func (o *source) newSQLExecStep(lines []string, firstLine int) (*core.SQLExecStep, error) {
+	processed, included, err := applyConditions(lines, firstLine, o.Conditions)
+	if err != nil {
+		return nil, err
+	}
+	if o.SubstituteVars {
+		for i, line := range processed {
+			if !included[i] {
+				continue
+			}
+			processed[i], err = substituteVars(line, o.Vars)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", firstLine+i, err)
+			}
+		}
+	}
+	s := &core.SQLExecStep{
+		Query: strings.Join(processed, "\n"),
+		Line:  firstLine,
+	}
+	if raw := strings.Join(lines, "\n"); raw != s.Query {
+		s.RawQuery = raw
+	}
+	return s, nil
+}
+
+// directiveParams is the parsed form of the parameters of a
+// "+migrate" directive.
+type directiveParams struct {
+	// TODO: create a direction enum
+	Forward       bool
+	Backward      bool
+	NoTransaction bool
+	// NoSplit sends the SQL of the step to the DB in one piece without
+	// splitting it into statements.
+	NoSplit bool
+	// Repeatable marks a repeatable migration. It is exclusive with
+	// Forward and Backward.
+	Repeatable bool
+	// Timeout is set by the timeout=<duration> parameter. E.g.: timeout=30s
+	Timeout time.Duration
+}
+
+func parseDirectiveParams(params string) (*directiveParams, error) {
+	var p directiveParams
+	hasTimeout := false
 	for _, f := range strings.FieldsFunc(params, unicode.IsSpace) {
-		switch f {
-		case "backward":
-			if backward {
-				err = errors.New("duplicate backward flag")
-				return
+		switch {
+		case f == "backward":
+			if p.Backward {
+				return nil, errors.New("duplicate backward flag")
 			}
-			if forward {
-				err = errors.New("backward and forward are exlusive")
-				return
+			if p.Forward {
+				return nil, errors.New("backward and forward are exlusive")
 			}
-			backward = true
-		case "forward":
-			if forward {
-				err = errors.New("duplicate forward flag")
-				return
+			p.Backward = true
+		case f == "forward":
+			if p.Forward {
+				return nil, errors.New("duplicate forward flag")
 			}
-			if backward {
-				err = errors.New("backward and forward are exlusive")
-				return
+			if p.Backward {
+				return nil, errors.New("backward and forward are exlusive")
+			}
+			p.Forward = true
+		case f == "repeatable":
+			if p.Repeatable {
+				return nil, errors.New("duplicate repeatable flag")
+			}
+			p.Repeatable = true
+		case f == "nosplit":
+			if p.NoSplit {
+				return nil, errors.New("duplicate nosplit flag")
+			}
+			p.NoSplit = true
+		case f == "notransaction":
+			if p.NoTransaction {
+				return nil, errors.New("duplicate notransaction flag")
+			}
+			p.NoTransaction = true
+		case strings.HasPrefix(f, "timeout="):
+			if hasTimeout {
+				return nil, errors.New("duplicate timeout parameter")
+			}
+			hasTimeout = true
+			d, err := time.ParseDuration(strings.TrimPrefix(f, "timeout="))
+			if err != nil {
+				return nil, fmt.Errorf("invalid timeout: %s", err)
 			}
-			forward = true
-		case "notransaction":
-			if notransaction {
-				err = errors.New("duplicate notransaction flag")
-				return
+			if d <= 0 {
+				return nil, fmt.Errorf("timeout has to be positive: %q", f)
 			}
-			notransaction = true
+			p.Timeout = d
 		default:
-			err = fmt.Errorf("invalid parameter: %q", f)
-			return
+			return nil, fmt.Errorf("invalid parameter: %q", f)
 		}
 	}
-	return forward, backward, notransaction, nil
+	return &p, nil
 }
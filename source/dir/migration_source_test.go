@@ -0,0 +1,122 @@
+package dir
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestParseDirectiveParams(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		p, err := parseDirectiveParams("forward notransaction nosplit timeout=1m30s")
+		require.NoError(t, err)
+		assert.Equal(t, &directiveParams{
+			Forward:       true,
+			NoTransaction: true,
+			NoSplit:       true,
+			Timeout:       90 * time.Second,
+		}, p)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		p, err := parseDirectiveParams("")
+		require.NoError(t, err)
+		assert.Equal(t, &directiveParams{}, p)
+	})
+
+	for _, params := range []string{
+		"forward backward",
+		"forward forward",
+		"notransaction notransaction",
+		"nosplit nosplit",
+		"repeatable repeatable",
+		"timeout=1s timeout=2s",
+		"timeout=abc",
+		"timeout=0s",
+		"timeout=-1s",
+		"invalid",
+	} {
+		t.Run("invalid "+params, func(t *testing.T) {
+			_, err := parseDirectiveParams(params)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoadMigrationFile_Line(t *testing.T) {
+	newSource := func(t *testing.T, pattern string, fsys fstest.MapFS) *source {
+		src, err := NewFSMigrationSource(fsys, pattern, "")
+		require.NoError(t, err)
+		return src.(*source)
+	}
+
+	t.Run("directives", func(t *testing.T) {
+		src := newSource(t, "", fstest.MapFS{
+			"0001_a.sql": &fstest.MapFile{Data: []byte("-- comment\n-- +migrate forward\nSELECT 1;\n-- +migrate backward nosplit\nSELECT 2;\n")},
+		})
+		fwd, back, err := src.loadMigrationFile("0001_a.sql")
+		require.NoError(t, err)
+		require.Len(t, fwd, 1)
+		require.Len(t, back, 1)
+		assert.Equal(t, 3, fwd[0].Step.Line)
+		assert.Equal(t, "0001_a.sql", fwd[0].Step.Path)
+		assert.Equal(t, 5, back[0].Step.Line)
+		assert.False(t, fwd[0].Step.NoSplit)
+		assert.True(t, back[0].Step.NoSplit)
+	})
+
+	t.Run("squashed", func(t *testing.T) {
+		src := newSource(t, "", fstest.MapFS{
+			"0002_b.sql": &fstest.MapFile{Data: []byte(
+				"-- +migrate squashed 0001_a.sql\n\n-- +migrate forward\nSELECT 1;\n\n" +
+					"-- +migrate squashed 0002_b.sql\n\n-- +migrate forward\nSELECT 2;\n",
+			)},
+		})
+		fwd, _, err := src.loadMigrationFile("0002_b.sql")
+		require.NoError(t, err)
+		require.Len(t, fwd, 2)
+		assert.Equal(t, 4, fwd[0].Step.Line)
+		assert.Equal(t, 9, fwd[1].Step.Line)
+	})
+
+	t.Run("repeatable", func(t *testing.T) {
+		src := newSource(t, "", fstest.MapFS{
+			"R_views.sql":    &fstest.MapFile{Data: []byte("CREATE VIEW v AS SELECT 1;\n")},
+			"functions.sql":  &fstest.MapFile{Data: []byte("-- comment\n-- +migrate repeatable notransaction\nSELECT 1;\n")},
+			"R_invalid.sql":  &fstest.MapFile{Data: []byte("-- +migrate forward\nSELECT 1;\n")},
+			"R_too_many.sql": &fstest.MapFile{Data: []byte("-- +migrate repeatable\nSELECT 1;\n-- +migrate repeatable\n")},
+		})
+		fwd, back, err := src.loadMigrationFile("R_views.sql")
+		require.NoError(t, err)
+		require.Len(t, fwd, 1)
+		assert.Empty(t, back)
+		assert.True(t, fwd[0].Repeatable)
+		assert.Equal(t, "R_views.sql", fwd[0].Name)
+		assert.Equal(t, 1, fwd[0].Step.Line)
+
+		fwd, _, err = src.loadMigrationFile("functions.sql")
+		require.NoError(t, err)
+		require.Len(t, fwd, 1)
+		assert.True(t, fwd[0].Repeatable)
+		assert.True(t, fwd[0].Step.NoTransaction)
+		assert.Equal(t, "SELECT 1;\n", fwd[0].Step.Query)
+		assert.Equal(t, 3, fwd[0].Step.Line)
+
+		_, _, err = src.loadMigrationFile("R_invalid.sql")
+		assert.Error(t, err)
+		_, _, err = src.loadMigrationFile("R_too_many.sql")
+		assert.Error(t, err)
+	})
+
+	t.Run("without directives", func(t *testing.T) {
+		src := newSource(t, "[id][description,prefix:_].[direction,forward:fw,backward:bw].sql", fstest.MapFS{
+			"0001_a.fw.sql": &fstest.MapFile{Data: []byte("SELECT 1;\n")},
+		})
+		fwd, _, err := src.loadMigrationFile("0001_a.fw.sql")
+		require.NoError(t, err)
+		require.Len(t, fwd, 1)
+		assert.Equal(t, 1, fwd[0].Step.Line)
+	})
+}
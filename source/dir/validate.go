@@ -0,0 +1,66 @@
+package dir
+
+import (
+	"fmt"
+	"github.com/pasztorpisti/migrate/core"
+	"strings"
+)
+
+func (o *source) Validate() []*core.ValidationProblem {
+	var problems []*core.ValidationProblem
+	addError := func(err error) {
+		problems = append(problems, &core.ValidationProblem{
+			Message: err.Error(),
+		})
+	}
+	addWarning := func(format string, a ...interface{}) {
+		problems = append(problems, &core.ValidationProblem{
+			Warning: true,
+			Message: fmt.Sprintf(format, a...),
+		})
+	}
+
+	entries, repeatables, brokenIDs := o.loadEntries(addError)
+
+	for i, e := range entries {
+		if e.Forward.Func == nil && isEmptySQL(e.Forward.rawQuery()) {
+			addError(fmt.Errorf("forward step doesn't contain SQL statements - %s", e.Forward))
+		}
+		if e.Backward == nil {
+			addWarning("migration doesn't have a backward step - %s", e.Forward)
+		}
+
+		if o.FilenamePattern.IDSequence && i > 0 {
+			// The IDs of the migrations that failed to load aren't gaps
+			// because their errors have already been reported.
+			prevID := entries[i-1].MigrationID.Number
+			for prevID+1 < e.MigrationID.Number && brokenIDs[prevID+1] {
+				prevID++
+			}
+			if e.MigrationID.Number != prevID+1 {
+				addError(fmt.Errorf("gap between migration IDs %d and %d (the filename_pattern uses generate:sequence) - %s, %s",
+					entries[i-1].MigrationID.Number, e.MigrationID.Number, entries[i-1].Forward, e.Forward))
+			}
+		}
+	}
+
+	for _, r := range repeatables {
+		if isEmptySQL(r.rawQuery()) {
+			addError(fmt.Errorf("repeatable migration doesn't contain SQL statements - %s", r))
+		}
+	}
+
+	return problems
+}
+
+// isEmptySQL returns true if the given SQL contains only whitespaces and
+// single line comments.
+func isEmptySQL(query string) bool {
+	for _, line := range strings.Split(query, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "--") {
+			return false
+		}
+	}
+	return true
+}
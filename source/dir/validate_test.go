@@ -0,0 +1,88 @@
+package dir
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSource_Validate(t *testing.T) {
+	newSource := func(t *testing.T, files map[string]string) (src *source, cleanup func()) {
+		dir, err := ioutil.TempDir("", "migrate_validate_test")
+		require.NoError(t, err)
+		for name, contents := range files {
+			err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644)
+			require.NoError(t, err)
+		}
+		pfp, err := parseFilenamePattern(defaultFilenamePattern)
+		require.NoError(t, err)
+		return &source{
+			FS:              os.DirFS(dir),
+			MigrationsDir:   dir,
+			FilenamePattern: pfp,
+		}, func() { os.RemoveAll(dir) }
+	}
+
+	messages := func(src *source) (errs, warnings []string) {
+		for _, p := range src.Validate() {
+			if p.Warning {
+				warnings = append(warnings, p.Message)
+			} else {
+				errs = append(errs, p.Message)
+			}
+		}
+		return
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		src, cleanup := newSource(t, map[string]string{
+			"0001_a.sql": "-- +migrate forward\nSELECT 1;\n-- +migrate backward\nSELECT 2;\n",
+			"0002_b.sql": "-- +migrate forward\nSELECT 3;\n-- +migrate backward\nSELECT 4;\n",
+		})
+		defer cleanup()
+
+		errs, warnings := messages(src)
+		assert.Empty(t, errs)
+		assert.Empty(t, warnings)
+	})
+
+	t.Run("reports all problems", func(t *testing.T) {
+		src, cleanup := newSource(t, map[string]string{
+			"0001_a.sql":  "-- +migrate forward\nSELECT 1;\n-- +migrate backward\nSELECT 2;\n",
+			"0001_b.sql":  "-- +migrate forward\nSELECT 1;\n",
+			"0002_c.sql":  "-- +migrate forward\n-- TODO\n\n-- +migrate backward\nSELECT 2;\n",
+			"0004_d.sql":  "-- +migrate forward invalid\nSELECT 1;\n",
+			"0005_e.sql":  "-- +migrate forward\nSELECT 1;\n",
+			"invalid.sql": "",
+		})
+		defer cleanup()
+
+		errs, warnings := messages(src)
+		assert.Len(t, errs, 5)
+		assert.Contains(t, errs[0], "duplicate forward step - 0001_a.sql, 0001_b.sql")
+		assert.Contains(t, errs[1], `invalid parameter: "invalid"`)
+		assert.Contains(t, errs[2], `filename "invalid.sql" doesn't match`)
+		assert.Equal(t, "forward step doesn't contain SQL statements - 0002_c.sql", errs[3])
+		assert.Contains(t, errs[4], "gap between migration IDs 2 and 5")
+		assert.Equal(t, []string{"migration doesn't have a backward step - 0005_e.sql"}, warnings)
+	})
+
+	t.Run("no gap at migrations that failed to load", func(t *testing.T) {
+		src, cleanup := newSource(t, map[string]string{
+			"0001_a.sql": "-- +migrate forward\nSELECT 1;\n-- +migrate backward\nSELECT 2;\n",
+			"0002_b.sql": "-- +migrate forward invalid\nSELECT 1;\n",
+			"0003_c.sql": "-- +migrate backward\nSELECT 1;\n",
+			"0004_d.sql": "-- +migrate forward\nSELECT 1;\n-- +migrate backward\nSELECT 2;\n",
+		})
+		defer cleanup()
+
+		errs, warnings := messages(src)
+		assert.Len(t, errs, 2)
+		assert.Contains(t, errs[0], `invalid parameter: "invalid"`)
+		assert.Contains(t, errs[1], "backward migration without a forward step - 0003_c.sql")
+		assert.Empty(t, warnings)
+	})
+}
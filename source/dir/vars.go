@@ -0,0 +1,52 @@
+package dir
+
+import (
+	"errors"
+	"github.com/pasztorpisti/migrate/template"
+	"os"
+)
+
+// SetVars implements core.VariableMigrationSource.
+func (o *source) SetVars(vars map[string]string) {
+	o.Vars = vars
+}
+
+// substituteVars substitutes the {env:VAR} and {var:name} template
+// parameters of a line. The values of {var:name} come from vars.
+// The '{' and '\' characters have to be escaped as '\{' and '\\' in the
+// lines of the migrations that have substitution enabled.
+func substituteVars(line string, vars map[string]string) (string, error) {
+	noCmd := func(command, dir string, env []string) (string, error) {
+		return "", errors.New("commands aren't allowed in migrations")
+	}
+
+	sections, err := template.Parse(line)
+	if err != nil {
+		return "", err
+	}
+	line, err = template.Execute(&template.ExecuteInput{
+		Sections:                    sections,
+		LookupVar:                   os.LookupEnv,
+		ExecCmd:                     noCmd,
+		VarParamName:                "env",
+		IgnoreUnknownTemplateParams: true,
+		EscapedResult:               true,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sections, err = template.Parse(line)
+	if err != nil {
+		return "", err
+	}
+	return template.Execute(&template.ExecuteInput{
+		Sections: sections,
+		LookupVar: func(name string) (string, bool) {
+			v, ok := vars[name]
+			return v, ok
+		},
+		ExecCmd:      noCmd,
+		VarParamName: "var",
+	})
+}
@@ -0,0 +1,86 @@
+package dir
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSubstituteVars(t *testing.T) {
+	require.NoError(t, os.Setenv("MIGRATE_TEST_VAR", "from_env"))
+	defer os.Unsetenv("MIGRATE_TEST_VAR")
+	vars := map[string]string{"user": "replicator", "brace": "{x}"}
+
+	tests := []*struct {
+		input  string
+		output string
+	}{
+		{"SELECT 1;", "SELECT 1;"},
+		{"CREATE USER {var:user};", "CREATE USER replicator;"},
+		{"SET search_path = {env:MIGRATE_TEST_VAR};", "SET search_path = from_env;"},
+		{"SELECT '{var:brace}';", "SELECT '{x}';"},
+		{`SELECT '\{var:user\}', '\\';`, `SELECT '{var:user}', '\';`},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			output, err := substituteVars(test.input, vars)
+			require.NoError(t, err)
+			assert.Equal(t, test.output, output)
+		})
+	}
+
+	for _, input := range []string{
+		"{var:undefined}",
+		"{env:MIGRATE_TEST_UNDEFINED_VAR}",
+		"{cmd:echo x}",
+		"{unknown:x}",
+		"{var:user",
+	} {
+		t.Run("invalid "+input, func(t *testing.T) {
+			_, err := substituteVars(input, vars)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoadStepPair_Vars(t *testing.T) {
+	src, err := NewFSMigrationSource(fstest.MapFS{
+		"0001_a.sql": &fstest.MapFile{Data: []byte("-- +migrate forward\nCREATE USER {var:user};\n-- +migrate if db=prod\nSELECT {var:undefined};\n-- +migrate endif\n")},
+	}, "", "")
+	require.NoError(t, err)
+	s := src.(*source)
+	s.SetConditions(map[string]string{"db": "dev"})
+	s.SetVars(map[string]string{"user": "replicator"})
+
+	t.Run("disabled", func(t *testing.T) {
+		fwd, _, err := s.loadMigrationFile("0001_a.sql")
+		require.NoError(t, err)
+		require.Len(t, fwd, 1)
+		assert.Contains(t, fwd[0].Step.Query, "CREATE USER {var:user};")
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		s.SubstituteVars = true
+		defer func() { s.SubstituteVars = false }()
+
+		fwd, _, err := s.loadMigrationFile("0001_a.sql")
+		require.NoError(t, err)
+		require.Len(t, fwd, 1)
+		step := fwd[0].Step
+		// The skipped block isn't substituted.
+		assert.Equal(t, "CREATE USER replicator;\n-- +migrate if db=prod (skipped)\n-- SELECT {var:undefined};\n-- +migrate endif\n", step.Query)
+		assert.Equal(t, "CREATE USER {var:user};\n-- +migrate if db=prod\nSELECT {var:undefined};\n-- +migrate endif\n", step.RawQuery)
+	})
+
+	t.Run("undefined var", func(t *testing.T) {
+		s.SubstituteVars = true
+		defer func() { s.SubstituteVars = false }()
+		s.SetConditions(map[string]string{"db": "prod"})
+		defer s.SetConditions(map[string]string{"db": "dev"})
+
+		_, _, err := s.loadMigrationFile("0001_a.sql")
+		assert.Error(t, err)
+	})
+}
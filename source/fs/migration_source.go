@@ -0,0 +1,78 @@
+// Package fs provides a read-only migration source that loads the migration
+// files from an io/fs.FS (e.g.: embed.FS, zip.Reader, fstest.MapFS).
+//
+// The migration files have the same format as in case of the dir migration
+// source. Unlike the dir source this one isn't registered automatically
+// because it needs an fs.FS instance. You can register it from your own
+// main package:
+//
+//	//go:embed migrations/*.sql
+//	var migrations embed.FS
+//
+//	func init() {
+//		core.RegisterMigrationSourceFactory("embedded", fs.NewMigrationSourceFactory(migrations))
+//	}
+//
+// and refer to it from the config file:
+//
+//	migration_source:
+//	  type: embedded
+//	  path: migrations
+package fs
+
+import (
+	"fmt"
+	"github.com/pasztorpisti/migrate/core"
+	"github.com/pasztorpisti/migrate/source/dir"
+	iofs "io/fs"
+)
+
+// NewMigrationSource creates a migration source that loads the migrations
+// from the root directory of fsys. An empty filenamePattern means the default
+// filename pattern of the dir migration source. GoMigrations is the registry
+// of the Go migrations to load (see dir.RegisterGoMigration) or empty.
+func NewMigrationSource(fsys iofs.FS, filenamePattern, goMigrations string) (core.MigrationSource, error) {
+	return dir.NewFSMigrationSource(fsys, filenamePattern, goMigrations)
+}
+
+// NewMigrationSourceFactory returns a factory that can be registered with
+// core.RegisterMigrationSourceFactory. It accepts the following
+// migration_source params:
+//   - path: optional directory inside fsys that contains the migration files
+//   - filename_pattern: the same as in case of the dir migration source
+//   - go_migrations: the same as in case of the dir migration source
+func NewMigrationSourceFactory(fsys iofs.FS) core.MigrationSourceFactory {
+	return &sourceFactory{fsys: fsys}
+}
+
+type sourceFactory struct {
+	fsys iofs.FS
+}
+
+func (o *sourceFactory) NewMigrationSource(baseDir string, params map[string]string) (core.MigrationSource, error) {
+	takeParam := func(key string) (string, bool) {
+		val, ok := params[key]
+		if ok {
+			delete(params, key)
+		}
+		return val, ok
+	}
+
+	fsys := o.fsys
+	if path, ok := takeParam("path"); ok && path != "" && path != "." {
+		sub, err := iofs.Sub(fsys, path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path parameter %q: %s", path, err)
+		}
+		fsys = sub
+	}
+
+	filenamePattern, _ := takeParam("filename_pattern")
+	goMigrations, _ := takeParam("go_migrations")
+
+	if len(params) != 0 {
+		return nil, fmt.Errorf("unrecognised migration_source params: %q", params)
+	}
+
+	return NewMigrationSource(fsys, filenamePattern, goMigrations)
+}
@@ -0,0 +1,57 @@
+package fs
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMigrationSourceFactory(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0001_initial.sql": &fstest.MapFile{Data: []byte(
+			"-- +migrate forward\nCREATE TABLE a (id INT);\n-- +migrate backward\nDROP TABLE a;\n")},
+		"migrations/0002_second.sql": &fstest.MapFile{Data: []byte(
+			"-- +migrate forward\nCREATE TABLE b (id INT);\n")},
+	}
+
+	t.Run("loads migrations from path", func(t *testing.T) {
+		src, err := NewMigrationSourceFactory(fsys).NewMigrationSource("", map[string]string{"path": "migrations"})
+		require.NoError(t, err)
+		entries, err := src.MigrationEntries()
+		require.NoError(t, err)
+
+		require.Equal(t, 2, entries.NumMigrations())
+		assert.Equal(t, "0001_initial.sql", entries.Name(0))
+		assert.Equal(t, "0002_second.sql", entries.Name(1))
+
+		idx, ok := entries.IndexForName("2")
+		assert.True(t, ok)
+		assert.Equal(t, 1, idx)
+
+		fwd, back, err := entries.Steps(0)
+		require.NoError(t, err)
+		assert.NotNil(t, fwd)
+		assert.NotNil(t, back)
+
+		fwd, back, err = entries.Steps(1)
+		require.NoError(t, err)
+		assert.NotNil(t, fwd)
+		assert.Nil(t, back)
+
+		_, err = entries.New(nil)
+		assert.EqualError(t, err, "this migration source is read-only")
+	})
+
+	t.Run("unrecognised param", func(t *testing.T) {
+		_, err := NewMigrationSourceFactory(fsys).NewMigrationSource("", map[string]string{"foo": "bar"})
+		assert.Error(t, err)
+	})
+
+	t.Run("missing directory", func(t *testing.T) {
+		src, err := NewMigrationSourceFactory(fsys).NewMigrationSource("", map[string]string{"path": "missing"})
+		require.NoError(t, err)
+		_, err = src.MigrationEntries()
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,513 @@
+// Package sqlsplit splits SQL scripts into individual statements.
+// It understands the quoting and comment syntax of the supported SQL dialects
+// so it doesn't split at delimiters that are inside string literals, quoted
+// identifiers, comments or dollar-quoted (postgres) function bodies.
+package sqlsplit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Options describes the dialect specific syntax rules used by Split.
+type Options struct {
+	// BackslashEscapes allows backslash escape sequences in quoted strings.
+	// E.g.: 'it\'s'
+	BackslashEscapes bool
+	// EscapeStrings enables the escape string constants of postgres that
+	// allow backslash escape sequences. E.g.: E'it\'s'
+	EscapeStrings bool
+	// DollarQuoting enables dollar-quoted strings. E.g.: $$text$$, $tag$text$tag$
+	DollarQuoting bool
+	// NestedComments allows nesting /* */ comments.
+	NestedComments bool
+	// HashComments enables single line comments that start with '#'.
+	HashComments bool
+	// DashCommentSpace requires a whitespace or control character after
+	// "--" to start a single line comment like in mysql. E.g.: "1--1" is
+	// an expression without this comment syntax.
+	DashCommentSpace bool
+	// BacktickQuotes enables `quoted` identifiers.
+	BacktickQuotes bool
+	// BracketQuotes enables [quoted] identifiers.
+	BracketQuotes bool
+	// Delimiter enables the DELIMITER command of the mysql client that
+	// changes the statement delimiter. E.g.: "DELIMITER //" at the beginning
+	// of a line. The DELIMITER lines aren't included in the result.
+	Delimiter bool
+	// TriggerBlocks keeps the BEGIN ... END body of CREATE TRIGGER
+	// statements in one piece.
+	TriggerBlocks bool
+	// AtomicBlocks keeps the BEGIN ATOMIC ... END body of the SQL-standard
+	// functions and procedures of postgres 14+ in one piece.
+	AtomicBlocks bool
+	// BatchSeparator splits the input into batches at "GO" lines like the
+	// sqlcmd tool of SQL Server does. Statement delimiters don't split the
+	// batches. An optional count after GO repeats the batch. E.g.: "GO 3".
+	// The GO lines aren't included in the result.
+	BatchSeparator bool
+}
+
+var (
+	Postgres = &Options{
+		EscapeStrings:  true,
+		DollarQuoting:  true,
+		NestedComments: true,
+		AtomicBlocks:   true,
+	}
+	MySQL = &Options{
+		BackslashEscapes: true,
+		HashComments:     true,
+		DashCommentSpace: true,
+		BacktickQuotes:   true,
+		Delimiter:        true,
+	}
+	SQLite = &Options{
+		BacktickQuotes: true,
+		BracketQuotes:  true,
+		TriggerBlocks:  true,
+	}
+	SQLServer = &Options{
+		NestedComments: true,
+		BracketQuotes:  true,
+		BatchSeparator: true,
+	}
+)
+
+// Statement is a single SQL statement returned by Split.
+type Statement struct {
+	// SQL is the statement without the trailing delimiter and
+	// without leading and trailing whitespaces.
+	SQL string
+	// Line and Column are the 1-based position of the first character
+	// of the statement in the input of Split. Column counts runes.
+	Line   int
+	Column int
+}
+
+// SyntaxError is returned by Split when the input contains an unterminated
+// quoted string, identifier or comment.
+type SyntaxError struct {
+	// Line is the 1-based line number where the unterminated item begins.
+	Line    int
+	Message string
+}
+
+func (o *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %s", o.Line, o.Message)
+}
+
+const defaultDelimiter = ";"
+
+// Split splits sql into statements. Statements that contain only comments
+// and whitespaces are omitted from the result.
+func Split(sql string, opts *Options) ([]Statement, error) {
+	s := &splitter{
+		opts:      opts,
+		sql:       sql,
+		line:      1,
+		delimiter: defaultDelimiter,
+		stmtStart: -1,
+	}
+	if err := s.split(); err != nil {
+		return nil, err
+	}
+	return s.statements, nil
+}
+
+type splitter struct {
+	opts *Options
+	sql  string
+	pos  int
+	line int
+	// lineStart is the position of the first character of the current line.
+	lineStart  int
+	delimiter  string
+	statements []Statement
+
+	// stmtStart is the position of the first character of the current
+	// statement or -1 if we haven't found one yet.
+	stmtStart  int
+	stmtLine   int
+	stmtColumn int
+
+	// The following fields are used only with Options.TriggerBlocks and
+	// Options.AtomicBlocks.
+	words     []string
+	isTrigger bool
+	isAtomic  bool
+	prevWord  string
+	depth     int
+}
+
+func (o *splitter) split() error {
+	for o.pos < len(o.sql) {
+		if o.opts.Delimiter && o.stmtStart < 0 && o.atLineStart() {
+			if ok, err := o.delimiterCommand(); err != nil {
+				return err
+			} else if ok {
+				continue
+			}
+		}
+
+		if o.opts.BatchSeparator && o.atLineStart() {
+			if ok, err := o.goCommand(); err != nil {
+				return err
+			} else if ok {
+				continue
+			}
+		}
+
+		if !o.opts.BatchSeparator && o.depth == 0 && strings.HasPrefix(o.sql[o.pos:], o.delimiter) {
+			o.endStatement()
+			o.pos += len(o.delimiter)
+			continue
+		}
+
+		c := o.sql[o.pos]
+		switch {
+		case c == '\n':
+			o.newLine(o.pos + 1)
+			o.pos++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+			o.pos++
+		case c == '-' && o.peek(1) == '-' && (!o.opts.DashCommentSpace || isSpaceOrControl(o.peek(2))),
+			c == '#' && o.opts.HashComments:
+			o.skipLineComment()
+		case c == '/' && o.peek(1) == '*':
+			if err := o.skipBlockComment(); err != nil {
+				return err
+			}
+		case c == '\'':
+			o.startStatement()
+			if err := o.skipQuoted('\'', '\'', o.opts.BackslashEscapes, "string literal"); err != nil {
+				return err
+			}
+		case c == '"':
+			o.startStatement()
+			if err := o.skipQuoted('"', '"', o.opts.BackslashEscapes, "quoted identifier"); err != nil {
+				return err
+			}
+		case c == '`' && o.opts.BacktickQuotes:
+			o.startStatement()
+			if err := o.skipQuoted('`', '`', false, "quoted identifier"); err != nil {
+				return err
+			}
+		case c == '[' && o.opts.BracketQuotes:
+			o.startStatement()
+			if err := o.skipQuoted('[', ']', false, "quoted identifier"); err != nil {
+				return err
+			}
+		case (c == 'E' || c == 'e') && o.opts.EscapeStrings && o.peek(1) == '\'' && (o.pos == 0 || !isWordChar(o.sql[o.pos-1])):
+			o.startStatement()
+			o.pos++
+			if err := o.skipQuoted('\'', '\'', true, "string literal"); err != nil {
+				return err
+			}
+		case c == '$' && o.opts.DollarQuoting && o.dollarTag() != "":
+			o.startStatement()
+			if err := o.skipDollarQuoted(); err != nil {
+				return err
+			}
+		case isWordChar(c):
+			o.startStatement()
+			o.word()
+		default:
+			o.startStatement()
+			o.pos++
+		}
+	}
+	o.endStatement()
+	return nil
+}
+
+func (o *splitter) peek(offset int) byte {
+	if o.pos+offset < len(o.sql) {
+		return o.sql[o.pos+offset]
+	}
+	return 0
+}
+
+// newLine has to be called after each newline character.
+// LineStart is the position of the first character of the new line.
+func (o *splitter) newLine(lineStart int) {
+	o.line++
+	o.lineStart = lineStart
+}
+
+func (o *splitter) atLineStart() bool {
+	return o.pos == 0 || o.sql[o.pos-1] == '\n'
+}
+
+func (o *splitter) startStatement() {
+	if o.stmtStart < 0 {
+		o.stmtStart = o.pos
+		o.stmtLine = o.line
+		o.stmtColumn = utf8.RuneCountInString(o.sql[o.lineStart:o.pos]) + 1
+	}
+}
+
+func (o *splitter) endStatement() {
+	if o.stmtStart >= 0 {
+		o.statements = append(o.statements, Statement{
+			SQL:    strings.TrimRightFunc(o.sql[o.stmtStart:o.pos], unicode.IsSpace),
+			Line:   o.stmtLine,
+			Column: o.stmtColumn,
+		})
+	}
+	o.stmtStart = -1
+	o.words = nil
+	o.isTrigger = false
+	o.isAtomic = false
+	o.prevWord = ""
+	o.depth = 0
+}
+
+// delimiterCommand processes a "DELIMITER <delimiter>" line if there is one
+// at the current position.
+func (o *splitter) delimiterCommand() (bool, error) {
+	const cmd = "DELIMITER"
+	rest := o.sql[o.pos:]
+	if len(rest) <= len(cmd) || !strings.EqualFold(rest[:len(cmd)], cmd) {
+		return false, nil
+	}
+	if c := rest[len(cmd)]; c != ' ' && c != '\t' {
+		return false, nil
+	}
+	end := strings.IndexByte(rest, '\n')
+	if end < 0 {
+		end = len(rest)
+	}
+	delimiter := strings.TrimSpace(rest[len(cmd):end])
+	if delimiter == "" {
+		return false, &SyntaxError{Line: o.line, Message: "missing delimiter after DELIMITER"}
+	}
+	o.delimiter = delimiter
+	o.pos += end
+	return true, nil
+}
+
+// goCommand processes a "GO [count]" batch separator line if there is one
+// at the current position.
+func (o *splitter) goCommand() (bool, error) {
+	rest := o.sql[o.pos:]
+	end := strings.IndexByte(rest, '\n')
+	if end < 0 {
+		end = len(rest)
+	}
+	line := rest[:end]
+	if i := strings.Index(line, "--"); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 || len(fields) > 2 || !strings.EqualFold(fields[0], "GO") {
+		return false, nil
+	}
+	count := 1
+	if len(fields) == 2 {
+		n, err := strconv.Atoi(fields[1])
+		if err != nil {
+			// Only a number can follow GO in a batch separator line.
+			return false, nil
+		}
+		if n < 1 {
+			return false, &SyntaxError{Line: o.line, Message: fmt.Sprintf("invalid GO count: %d", n)}
+		}
+		count = n
+	}
+
+	first := len(o.statements)
+	o.endStatement()
+	if len(o.statements) > first {
+		for i := 1; i < count; i++ {
+			o.statements = append(o.statements, o.statements[first])
+		}
+	}
+	o.pos += end
+	return true, nil
+}
+
+func (o *splitter) skipLineComment() {
+	for o.pos < len(o.sql) && o.sql[o.pos] != '\n' {
+		o.pos++
+	}
+}
+
+func (o *splitter) skipBlockComment() error {
+	startLine := o.line
+	depth := 0
+	for o.pos < len(o.sql) {
+		switch {
+		case o.sql[o.pos] == '/' && o.peek(1) == '*':
+			if depth == 0 || o.opts.NestedComments {
+				depth++
+			}
+			o.pos += 2
+		case o.sql[o.pos] == '*' && o.peek(1) == '/':
+			depth--
+			o.pos += 2
+			if depth == 0 {
+				return nil
+			}
+		default:
+			if o.sql[o.pos] == '\n' {
+				o.newLine(o.pos + 1)
+			}
+			o.pos++
+		}
+	}
+	return &SyntaxError{Line: startLine, Message: "unterminated block comment"}
+}
+
+// skipQuoted skips a quoted item. A doubled close character inside
+// the item stands for the close character itself.
+func (o *splitter) skipQuoted(open, close byte, backslashEscapes bool, what string) error {
+	startLine := o.line
+	o.pos++
+	for o.pos < len(o.sql) {
+		c := o.sql[o.pos]
+		switch {
+		case c == '\\' && backslashEscapes:
+			if o.peek(1) == '\n' {
+				o.newLine(o.pos + 2)
+			}
+			o.pos += 2
+		case c == close:
+			if o.peek(1) == close {
+				o.pos += 2
+				continue
+			}
+			o.pos++
+			return nil
+		default:
+			if c == '\n' {
+				o.newLine(o.pos + 1)
+			}
+			o.pos++
+		}
+	}
+	return &SyntaxError{Line: startLine, Message: "unterminated " + what}
+}
+
+// dollarTag returns the "$tag$" or "$$" opening tag of a dollar-quoted string
+// at the current position or an empty string if there isn't one.
+func (o *splitter) dollarTag() string {
+	// The $ character can be part of an identifier in postgres.
+	if o.pos > 0 && isWordChar(o.sql[o.pos-1]) {
+		return ""
+	}
+	for i := o.pos + 1; i < len(o.sql); i++ {
+		c := o.sql[i]
+		if c == '$' {
+			return o.sql[o.pos : i+1]
+		}
+		// The tag can't start with a digit because $1 is a parameter.
+		if !isWordChar(c) || c == '$' || (i == o.pos+1 && c >= '0' && c <= '9') {
+			return ""
+		}
+	}
+	return ""
+}
+
+func (o *splitter) skipDollarQuoted() error {
+	startLine := o.line
+	tag := o.dollarTag()
+	body := o.sql[o.pos+len(tag):]
+	end := strings.Index(body, tag)
+	if end < 0 {
+		return &SyntaxError{Line: startLine, Message: "unterminated dollar-quoted string " + tag}
+	}
+	if n := strings.Count(body[:end], "\n"); n != 0 {
+		o.line += n
+		o.lineStart = o.pos + len(tag) + strings.LastIndexByte(body[:end], '\n') + 1
+	}
+	o.pos += len(tag) + end + len(tag)
+	return nil
+}
+
+// word skips a keyword or identifier and keeps track of the BEGIN ... END
+// blocks of CREATE TRIGGER statements and the BEGIN ATOMIC ... END blocks.
+func (o *splitter) word() {
+	start := o.pos
+	for o.pos < len(o.sql) && isWordChar(o.sql[o.pos]) {
+		o.pos++
+	}
+	if !o.opts.TriggerBlocks && !o.opts.AtomicBlocks {
+		return
+	}
+
+	w := strings.ToUpper(o.sql[start:o.pos])
+	prev := o.prevWord
+	o.prevWord = w
+	if o.opts.AtomicBlocks {
+		switch {
+		case w == "ATOMIC" && prev == "BEGIN":
+			o.isAtomic = true
+			o.depth++
+		case o.isAtomic && w == "CASE":
+			o.depth++
+		case o.isAtomic && w == "END":
+			o.depth--
+			if o.depth == 0 {
+				o.isAtomic = false
+			}
+		}
+	}
+	if !o.opts.TriggerBlocks {
+		return
+	}
+
+	if len(o.words) < 3 {
+		o.words = append(o.words, w)
+		if len(o.words) >= 2 && o.words[0] == "CREATE" {
+			o.isTrigger = o.words[1] == "TRIGGER" ||
+				len(o.words) == 3 && (o.words[1] == "TEMP" || o.words[1] == "TEMPORARY") && o.words[2] == "TRIGGER"
+		}
+	}
+	if !o.isTrigger {
+		return
+	}
+	switch w {
+	case "BEGIN", "CASE":
+		o.depth++
+	case "END":
+		if o.depth > 0 {
+			o.depth--
+		}
+	}
+}
+
+// isSpaceOrControl reports whether c is an ASCII whitespace or control
+// character. The end of the input (zero) counts as a control character.
+func isSpaceOrControl(c byte) bool {
+	return c <= ' ' || c == 0x7f
+}
+
+func isWordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '$' || c >= 0x80
+}
+
+// LineColumn converts the 1-based rune position pos in s to 1-based line
+// and column numbers. It returns zeros if pos is out of range.
+func LineColumn(s string, pos int) (line, column int) {
+	if pos < 1 {
+		return 0, 0
+	}
+	line, column = 1, 1
+	for _, r := range s {
+		pos--
+		if pos == 0 {
+			return line, column
+		}
+		if r == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+	return 0, 0
+}
@@ -0,0 +1,181 @@
+package sqlsplit
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []*struct {
+		name   string
+		opts   *Options
+		input  string
+		output []Statement
+	}{
+		{"empty", Postgres, "", nil},
+		{"comments only", Postgres, "-- c1\n/* c2 */\n;\n", nil},
+		{"single without delimiter", Postgres, "SELECT 1", []Statement{{"SELECT 1", 1, 1}}},
+		{
+			"multiple", Postgres,
+			"SELECT 1;\n\n-- comment\nSELECT\n  2;  SELECT 3;\n",
+			[]Statement{{"SELECT 1", 1, 1}, {"SELECT\n  2", 4, 1}, {"SELECT 3", 5, 7}},
+		},
+		{
+			"quotes", Postgres,
+			"SELECT ';', \"a;\nb\", 'it''s;';\nSELECT 2;",
+			[]Statement{{"SELECT ';', \"a;\nb\", 'it''s;'", 1, 1}, {"SELECT 2", 3, 1}},
+		},
+		{
+			"comments in statement", Postgres,
+			"SELECT 1 -- ;\n, /* ; */ 2;\nSELECT 3;",
+			[]Statement{{"SELECT 1 -- ;\n, /* ; */ 2", 1, 1}, {"SELECT 3", 3, 1}},
+		},
+		{
+			"column after multi-line literals", Postgres,
+			"SELECT 'a\nbc'; SELECT 2;\nSELECT $$a\nb$$; SELECT 4;",
+			[]Statement{{"SELECT 'a\nbc'", 1, 1}, {"SELECT 2", 2, 6}, {"SELECT $$a\nb$$", 3, 1}, {"SELECT 4", 4, 6}},
+		},
+		{
+			"postgres nested comment", Postgres,
+			"/* a /* ; */ ; */ SELECT 1;",
+			[]Statement{{"SELECT 1", 1, 19}},
+		},
+		{
+			"postgres dollar quoting", Postgres,
+			"CREATE FUNCTION f() RETURNS int AS $$\nBEGIN\n  RETURN 1;\nEND;\n$$ LANGUAGE plpgsql;\n" +
+				"CREATE FUNCTION g() RETURNS text AS $body$ SELECT '$$;' $body$ LANGUAGE sql;\n" +
+				"SELECT $1, a$b$;",
+			[]Statement{
+				{"CREATE FUNCTION f() RETURNS int AS $$\nBEGIN\n  RETURN 1;\nEND;\n$$ LANGUAGE plpgsql", 1, 1},
+				{"CREATE FUNCTION g() RETURNS text AS $body$ SELECT '$$;' $body$ LANGUAGE sql", 6, 1},
+				{"SELECT $1, a$b$", 7, 1},
+			},
+		},
+		{
+			"postgres escape strings", Postgres,
+			"SELECT E'it\\'s; x', e'\\\\';\nSELECT 'a\\';\nSELECTE'x';",
+			[]Statement{{"SELECT E'it\\'s; x', e'\\\\'", 1, 1}, {"SELECT 'a\\'", 2, 1}, {"SELECTE'x'", 3, 1}},
+		},
+		{
+			"postgres begin atomic", Postgres,
+			"CREATE FUNCTION f(a int) RETURNS int LANGUAGE sql\nBEGIN ATOMIC\n  INSERT INTO t VALUES (a);\n  SELECT CASE WHEN a > 0 THEN 1 END;\nEND;\n" +
+				"BEGIN;\nSELECT 2;\nEND;",
+			[]Statement{
+				{"CREATE FUNCTION f(a int) RETURNS int LANGUAGE sql\nBEGIN ATOMIC\n  INSERT INTO t VALUES (a);\n  SELECT CASE WHEN a > 0 THEN 1 END;\nEND", 1, 1},
+				{"BEGIN", 6, 1},
+				{"SELECT 2", 7, 1},
+				{"END", 8, 1},
+			},
+		},
+		{
+			"mysql dash comments need a space", MySQL,
+			"SELECT 1--1;\nSELECT 2 --;\nSELECT 3 --\t;\n;\nSELECT 4;--",
+			[]Statement{{"SELECT 1--1", 1, 1}, {"SELECT 2 --", 2, 1}, {"SELECT 3 --\t;", 3, 1}, {"SELECT 4", 5, 1}},
+		},
+		{
+			"mysql backslash escapes and hash comments", MySQL,
+			"# comment;\nINSERT INTO `t;` VALUES ('it\\'s;', \"a\\\";\");\nSELECT 2;",
+			[]Statement{{"INSERT INTO `t;` VALUES ('it\\'s;', \"a\\\";\")", 2, 1}, {"SELECT 2", 3, 1}},
+		},
+		{
+			"mysql delimiter", MySQL,
+			"DELIMITER //\nCREATE PROCEDURE p()\nBEGIN\n  SELECT 1;\n  SELECT 2;\nEND //\ndelimiter ;\nCALL p();\n",
+			[]Statement{
+				{"CREATE PROCEDURE p()\nBEGIN\n  SELECT 1;\n  SELECT 2;\nEND", 2, 1},
+				{"CALL p()", 8, 1},
+			},
+		},
+		{
+			"sqlite trigger", SQLite,
+			"CREATE TEMP TRIGGER [t;] AFTER INSERT ON x BEGIN\n  UPDATE y SET a = CASE WHEN 1 THEN 2 END;\n  DELETE FROM z;\nEND;\nSELECT `c;`;",
+			[]Statement{
+				{"CREATE TEMP TRIGGER [t;] AFTER INSERT ON x BEGIN\n  UPDATE y SET a = CASE WHEN 1 THEN 2 END;\n  DELETE FROM z;\nEND", 1, 1},
+				{"SELECT `c;`", 5, 1},
+			},
+		},
+		{
+			"sqlite begin transaction isn't a block", SQLite,
+			"BEGIN;\nSELECT 1;\nEND;",
+			[]Statement{{"BEGIN", 1, 1}, {"SELECT 1", 2, 1}, {"END", 3, 1}},
+		},
+		{
+			"sqlserver batches", SQLServer,
+			"CREATE TABLE [a;b] (id INT);\nINSERT INTO [a;b] VALUES (1);\ngo\nCREATE PROCEDURE p AS\nBEGIN\n  SELECT 1;\nEND\n  GO  -- end of p\n",
+			[]Statement{
+				{"CREATE TABLE [a;b] (id INT);\nINSERT INTO [a;b] VALUES (1);", 1, 1},
+				{"CREATE PROCEDURE p AS\nBEGIN\n  SELECT 1;\nEND", 4, 1},
+			},
+		},
+		{
+			"sqlserver GO in strings and comments", SQLServer,
+			"SELECT '\nGO\n'\n/* /*\nGO\n*/ */\nSELECT 2\nGO\nGO\n",
+			[]Statement{{"SELECT '\nGO\n'\n/* /*\nGO\n*/ */\nSELECT 2", 1, 1}},
+		},
+		{
+			"sqlserver GO count", SQLServer,
+			"INSERT INTO t DEFAULT VALUES\nGO 3\nSELECT 1",
+			[]Statement{{"INSERT INTO t DEFAULT VALUES", 1, 1}, {"INSERT INTO t DEFAULT VALUES", 1, 1}, {"INSERT INTO t DEFAULT VALUES", 1, 1}, {"SELECT 1", 3, 1}},
+		},
+		{
+			"sqlserver go isn't a separator inside a line", SQLServer,
+			"SELECT 1 AS go\nGO x\n",
+			[]Statement{{"SELECT 1 AS go\nGO x", 1, 1}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			statements, err := Split(test.input, test.opts)
+			require.NoError(t, err)
+			assert.Equal(t, test.output, statements)
+		})
+	}
+}
+
+func TestSplit_Error(t *testing.T) {
+	tests := []*struct {
+		name  string
+		opts  *Options
+		input string
+		line  int
+	}{
+		{"unterminated string", Postgres, "SELECT 1;\nSELECT 'a;", 2},
+		{"unterminated escape string", Postgres, "SELECT E'a\\';", 1},
+		{"unterminated quoted identifier", Postgres, "SELECT \"a;", 1},
+		{"unterminated block comment", Postgres, "SELECT 1;\n\n/* /* */", 3},
+		{"unterminated dollar-quoted string", Postgres, "SELECT $a$ $$;", 1},
+		{"unterminated backtick", MySQL, "SELECT `a;", 1},
+		{"unterminated bracket", SQLite, "\nSELECT [a;", 2},
+		{"missing delimiter", MySQL, "DELIMITER  \nSELECT 1;", 1},
+		{"invalid GO count", SQLServer, "SELECT 1\nGO 0", 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Split(test.input, test.opts)
+			require.IsType(t, &SyntaxError{}, err)
+			assert.Equal(t, test.line, err.(*SyntaxError).Line)
+		})
+	}
+}
+
+func TestLineColumn(t *testing.T) {
+	tests := []*struct {
+		pos, line, column int
+	}{
+		{0, 0, 0},
+		{1, 1, 1},
+		{3, 1, 3},
+		{4, 1, 4},
+		{5, 2, 1},
+		{7, 2, 3},
+		{8, 0, 0},
+	}
+
+	for _, test := range tests {
+		line, column := LineColumn("abé\nxyz", test.pos)
+		assert.Equal(t, test.line, line, "pos=%d", test.pos)
+		assert.Equal(t, test.column, column, "pos=%d", test.pos)
+	}
+}
@@ -32,6 +32,16 @@ func RemoveTrailingNewlines(execCmd ExecCmdFunc) ExecCmdFunc {
 // This function can be used as a parameter to RemoveTrailingNewlines or as
 // a possible value of ExecuteOptions.ExecCmd.
 func ExecCmd(command, dir string, env []string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+	return ExecCmdContext(ctx, command, dir, env, false)
+}
+
+// ExecCmdContext is ExecCmd without its 15 second timeout. The command is
+// killed when ctx is done. With inheritEnv==true env is added to the
+// environment of the current process instead of replacing it. Only env is
+// listed in the error message in both cases.
+func ExecCmdContext(ctx context.Context, command, dir string, env []string, inheritEnv bool) (string, error) {
 	var name string
 	var carg string
 	if runtime.GOOS == "windows" {
@@ -53,15 +63,15 @@ func ExecCmd(command, dir string, env []string) (string, error) {
 		}
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
-	defer cancel()
-
 	var stdOut bytes.Buffer
 	var all bytes.Buffer
 	syncedAll := &syncWriter{Writer: &all}
 
 	cmd := exec.CommandContext(ctx, name, carg, command)
 	cmd.Env = env
+	if inheritEnv {
+		cmd.Env = append(os.Environ(), env...)
+	}
 	cmd.Dir = dir
 	cmd.Stderr = syncedAll
 	cmd.Stdout = io.MultiWriter(&stdOut, syncedAll)
@@ -1,8 +1,10 @@
 package template
 
 import (
+	"context"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"os"
 	"testing"
 )
 
@@ -45,3 +47,20 @@ func TestExecCmd(t *testing.T) {
 		assert.Error(t, err)
 	})
 }
+
+func TestExecCmdContext(t *testing.T) {
+	t.Run("inherit env", func(t *testing.T) {
+		require.NoError(t, os.Setenv("MIGRATE_TEST_PARENT_ENV", "parent"))
+		defer os.Unsetenv("MIGRATE_TEST_PARENT_ENV")
+		s, err := ExecCmdContext(context.Background(), "echo \"${MIGRATE_TEST_PARENT_ENV} ${MY_ENV}\"", "", []string{"MY_ENV=MY_VAL"}, true)
+		require.NoError(t, err)
+		assert.Equal(t, "parent MY_VAL\n", s)
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := ExecCmdContext(ctx, "echo x", "", nil, false)
+		assert.Error(t, err)
+	})
+}